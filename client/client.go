@@ -0,0 +1,475 @@
+// Package client speaks the universe WebSocket protocol for bots, importers
+// and tests. A Client keeps a mirror of its room's points, delivers every
+// message it receives to callbacks or a channel, and reconnects after the
+// connection drops, resuming from the last sequence number it saw.
+//
+// The client exchanges JSON with camelCase keys, the server's default.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kfrico/universe/hub"
+)
+
+// ErrNotConnected is returned by sends while the client is reconnecting.
+var ErrNotConnected = errors.New("client: not connected")
+
+// ErrClosed is returned by sends after Close.
+var ErrClosed = errors.New("client: closed")
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHandler calls fn with every message received, after the mirror has
+// been updated. Callbacks run on the read goroutine, in order; messages
+// batched in delta and sync frames are delivered one by one.
+func WithHandler(fn func(hub.Message)) Option {
+	return func(c *Client) { c.handlers = append(c.handlers, fn) }
+}
+
+// WithEvents delivers every message received on the channel returned by
+// Events, buffering up to buffer of them. Reading stalls while the channel
+// is full.
+func WithEvents(buffer int) Option {
+	return func(c *Client) { c.events = make(chan hub.Message, buffer) }
+}
+
+// WithToken sends token as a bearer token when connecting and fetching
+// snapshots.
+func WithToken(token string) Option {
+	return func(c *Client) { c.header.Set("Authorization", "Bearer "+token) }
+}
+
+// WithReconnectDelay sets the wait before reconnecting, unless the server
+// suggests one when shutting down. The default is one second.
+func WithReconnectDelay(d time.Duration) Option {
+	return func(c *Client) { c.reconnectDelay = d }
+}
+
+// Client is a connection to one room that survives reconnects.
+type Client struct {
+	url            string
+	header         http.Header
+	handlers       []func(hub.Message)
+	events         chan hub.Message
+	reconnectDelay time.Duration
+
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+
+	mu     sync.Mutex
+	points map[string]hub.Point
+	idMode bool
+	// keyDecimals is the precision of the room's coordinate keys, as
+	// announced in init and sync.
+	keyDecimals int
+	lastSeq     uint64
+	// baseSeq is the sequence number of the state last loaded whole, from an
+	// init, snapshot or sync. Frames at or below it are already reflected.
+	baseSeq uint64
+	closed  bool
+	done    chan struct{}
+}
+
+// Connect dials a /ws URL, such as ws://localhost:8080/ws?room=lobby, and
+// returns once the room's initial snapshot has been received.
+func Connect(rawURL string, opts ...Option) (*Client, error) {
+	c := &Client{
+		url:            rawURL,
+		header:         http.Header{},
+		reconnectDelay: time.Second,
+		points:         make(map[string]hub.Point),
+		keyDecimals:    6,
+		done:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	ready := make(chan error, 1)
+	go c.run(conn, ready)
+	if err := <-ready; err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) dial() (*websocket.Conn, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	since := c.lastSeq
+	c.mu.Unlock()
+	if since > 0 {
+		q := u.Query()
+		q.Set("since", fmt.Sprint(since))
+		u.RawQuery = q.Encode()
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), c.header)
+	return conn, err
+}
+
+// run reads from conn and from every connection that replaces it until the
+// client is closed. ready receives the outcome of the first snapshot.
+func (c *Client) run(conn *websocket.Conn, ready chan<- error) {
+	if c.events != nil {
+		defer close(c.events)
+	}
+	for conn != nil {
+		// Close sets closed before closing c.conn, so either it sees this
+		// connection or this sees closed.
+		c.writeMu.Lock()
+		if c.isClosed() {
+			c.writeMu.Unlock()
+			conn.Close()
+			return
+		}
+		c.conn = conn
+		c.writeMu.Unlock()
+		delay, err := c.read(conn, ready)
+		ready = nil
+		c.writeMu.Lock()
+		c.conn = nil
+		c.writeMu.Unlock()
+		conn.Close()
+		if c.isClosed() {
+			return
+		}
+		slog.Warn("client: connection lost", "err", err)
+		conn = c.redial(delay)
+	}
+}
+
+// redial retries until a connection succeeds, returning nil if the client
+// is closed meanwhile.
+func (c *Client) redial(delay time.Duration) *websocket.Conn {
+	for {
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return nil
+		}
+		conn, err := c.dial()
+		if err == nil {
+			return conn
+		}
+		slog.Warn("client: reconnect failed", "err", err)
+		delay = c.reconnectDelay
+	}
+}
+
+// read handles the frames of one connection and returns how long to wait
+// before reconnecting.
+func (c *Client) read(conn *websocket.Conn, ready chan<- error) (time.Duration, error) {
+	delay := c.reconnectDelay
+	pointless := false
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ready != nil {
+				ready <- err
+			}
+			return delay, err
+		}
+		var msg hub.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			slog.Warn("client: invalid message", "err", err)
+			continue
+		}
+		if msg.Type == "shutdown" && msg.ReconnectAfter > 0 {
+			delay = time.Duration(msg.ReconnectAfter) * time.Millisecond
+		}
+		if err := c.handle(msg); err != nil {
+			if ready != nil {
+				ready <- err
+			}
+			return delay, err
+		}
+		if ready != nil && synced(msg, &pointless) {
+			ready <- nil
+			ready = nil
+		}
+	}
+}
+
+// synced reports whether msg completes the state a connection starts with.
+// An init without points is followed by initChunk frames when the room is
+// large; when the next frame is anything else the room was empty.
+func synced(msg hub.Message, pointless *bool) bool {
+	switch msg.Type {
+	case "init":
+		*pointless = msg.Points == nil
+		return !*pointless
+	case "initChunk":
+		return msg.Done
+	case "initRef", "sync":
+		return true
+	}
+	return *pointless
+}
+
+func (c *Client) handle(msg hub.Message) error {
+	switch msg.Type {
+	case "delta", "sync":
+		if msg.Type == "sync" {
+			c.mu.Lock()
+			c.learnKeys(msg)
+			c.mu.Unlock()
+		}
+		for _, inner := range msg.Messages {
+			if err := c.handle(inner); err != nil {
+				return err
+			}
+		}
+		c.mu.Lock()
+		if msg.Seq > c.lastSeq {
+			c.lastSeq = msg.Seq
+		}
+		if msg.Type == "sync" && msg.Seq > c.baseSeq {
+			c.baseSeq = msg.Seq
+		}
+		c.mu.Unlock()
+		return nil
+	case "initRef":
+		c.mu.Lock()
+		c.learnKeys(msg)
+		c.mu.Unlock()
+		if err := c.loadSnapshot(msg.URL); err != nil {
+			return fmt.Errorf("client: snapshot: %v", err)
+		}
+	default:
+		c.apply(msg)
+	}
+	for _, fn := range c.handlers {
+		fn(msg)
+	}
+	if c.events != nil {
+		c.events <- msg
+	}
+	return nil
+}
+
+// key identifies p in the mirror the way the server does. Must be called
+// with c.mu held.
+func (c *Client) key(p hub.Point) string {
+	if c.idMode {
+		return p.ID
+	}
+	return hub.CoordKey(p, c.keyDecimals)
+}
+
+// learnKeys records how the room keys its points, from an init, initRef or
+// sync message. Must be called with c.mu held.
+func (c *Client) learnKeys(msg hub.Message) {
+	if msg.Mode != "" {
+		c.idMode = msg.Mode == "id"
+	}
+	if msg.KeyDecimals != nil {
+		c.keyDecimals = *msg.KeyDecimals
+	}
+}
+
+// apply updates the mirror with msg. Messages at or below the sequence
+// number of the last state loaded whole are already reflected in it. Later
+// ones are applied in the order they arrive, as their numbers need not
+// increase: frames that report no mutation carry the number current when
+// they were sent, which the mutation after them may share, and coalesced
+// moves keep the number of their move.
+func (c *Client) apply(msg hub.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if msg.Type == "simTick" {
+		// Simulation steps are not sequenced; every frame is current.
+		for _, p := range msg.Points {
+			c.points[c.key(p)] = p
+		}
+		return
+	}
+	if msg.Type == "init" {
+		c.points = make(map[string]hub.Point)
+		c.learnKeys(msg)
+		c.lastSeq, c.baseSeq = msg.Seq, msg.Seq
+	} else if msg.Seq > 0 {
+		if msg.Seq <= c.baseSeq {
+			return
+		}
+		if msg.Seq > c.lastSeq {
+			c.lastSeq = msg.Seq
+		}
+	}
+	put := func(ps ...hub.Point) {
+		for _, p := range ps {
+			c.points[c.key(p)] = p
+		}
+	}
+	switch msg.Type {
+	case "init", "initChunk", "addBatch", "updateBatch":
+		put(msg.Points...)
+	case "add", "update":
+		if msg.Point != nil {
+			put(*msg.Point)
+		}
+	case "remove":
+		if msg.Point != nil {
+			delete(c.points, c.key(*msg.Point))
+		}
+	case "removeBatch":
+		for _, p := range msg.Points {
+			delete(c.points, c.key(p))
+		}
+	case "reset":
+		// The restored state follows in an init.
+		c.points = make(map[string]hub.Point)
+	case "clear":
+		// Pinned points survive a clear.
+		for k, p := range c.points {
+			if !p.Pinned {
+				delete(c.points, k)
+			}
+		}
+	case "move":
+		if msg.From != nil && msg.To != nil {
+			delete(c.points, c.key(*msg.From))
+			put(*msg.To)
+		}
+	}
+}
+
+// loadSnapshot replaces the mirror with the snapshot an initRef names.
+func (c *Client) loadSnapshot(ref string) error {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "wss":
+		u.Scheme = "https"
+	default:
+		u.Scheme = "http"
+	}
+	target, err := u.Parse(ref)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header.Clone()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	var snap struct {
+		Seq    uint64      `json:"seq"`
+		Points []hub.Point `json:"points"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.points = make(map[string]hub.Point, len(snap.Points))
+	for _, p := range snap.Points {
+		c.points[c.key(p)] = p
+	}
+	c.lastSeq, c.baseSeq = snap.Seq, snap.Seq
+	return nil
+}
+
+// Events returns the channel set up by WithEvents, or nil. It is closed
+// after Close.
+func (c *Client) Events() <-chan hub.Message { return c.events }
+
+// Snapshot returns the points of the room as last received.
+func (c *Client) Snapshot() []hub.Point {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]hub.Point, 0, len(c.points))
+	for _, p := range c.points {
+		out = append(out, p)
+	}
+	return out
+}
+
+// Send writes msg to the server. Failures the server reports arrive later
+// as error messages.
+func (c *Client) Send(msg hub.Message) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// AddPoint asks the server to add p.
+func (c *Client) AddPoint(p hub.Point) error {
+	return c.Send(hub.Message{Type: "add", Point: &p})
+}
+
+// AddPoints asks the server to add ps in one batch.
+func (c *Client) AddPoints(ps []hub.Point) error {
+	return c.Send(hub.Message{Type: "addBatch", Points: ps})
+}
+
+// RemovePoint asks the server to remove the point with p's key.
+func (c *Client) RemovePoint(p hub.Point) error {
+	return c.Send(hub.Message{Type: "remove", Point: &p})
+}
+
+// MovePoint asks the server to move the point at from to to.
+func (c *Client) MovePoint(from, to hub.Point) error {
+	return c.Send(hub.Message{Type: "move", From: &from, To: &to})
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Close disconnects and stops reconnecting.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	c.mu.Unlock()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	return c.conn.Close()
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kfrico/universe/hub"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newServer starts a universe server and returns the /ws URL of room.
+func newServer(t *testing.T, room string, opts ...hub.Option) string {
+	t.Helper()
+	srv, err := hub.NewServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Close()
+	})
+	return "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?room=" + room
+}
+
+func connect(t *testing.T, url string, opts ...Option) *Client {
+	t.Helper()
+	c, err := Connect(url, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// waitFor reads events until one of type msgType arrives.
+func waitFor(t *testing.T, events <-chan hub.Message, msgType string) hub.Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-events:
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no %s message received", msgType)
+		}
+	}
+}
+
+func TestMirrorFollowsAddAndRemove(t *testing.T) {
+	url := newServer(t, "mirror")
+	watcher := connect(t, url, WithEvents(64))
+	editor := connect(t, url)
+
+	p := hub.Point{X: 1, Y: 2, Z: 3}
+	if err := editor.AddPoint(p); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, watcher.Events(), "add")
+	if got := watcher.Snapshot(); len(got) != 1 || got[0].X != 1 {
+		t.Fatalf("after add, mirror = %+v", got)
+	}
+	if err := editor.RemovePoint(p); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, watcher.Events(), "remove")
+	if got := watcher.Snapshot(); len(got) != 0 {
+		t.Fatalf("after remove, mirror = %+v", got)
+	}
+}
+
+// Removing a selected point broadcasts a deselect stamped with the
+// removal's sequence number ahead of the remove itself.
+func TestRemoveOfSelectedPointLeavesMirror(t *testing.T) {
+	url := newServer(t, "selected")
+	watcher := connect(t, url, WithEvents(64))
+	editor := connect(t, url)
+
+	p := hub.Point{X: 1, Y: 2, Z: 3}
+	editor.AddPoint(p)
+	waitFor(t, watcher.Events(), "add")
+	editor.Send(hub.Message{Type: "select", Point: &p})
+	waitFor(t, watcher.Events(), "select")
+	editor.RemovePoint(p)
+	// The deselect has low priority, so the two may arrive in either order.
+	seen := map[string]bool{}
+	timeout := time.After(5 * time.Second)
+	for !seen["deselect"] || !seen["remove"] {
+		select {
+		case msg := <-watcher.Events():
+			seen[msg.Type] = true
+		case <-timeout:
+			t.Fatalf("got %v, want deselect and remove", seen)
+		}
+	}
+	if got := watcher.Snapshot(); len(got) != 0 {
+		t.Fatalf("removed point still mirrored: %+v", got)
+	}
+}
+
+// A coalesced move is broadcast after mutations made during its window and
+// must still be applied.
+func TestCoalescedMoveAfterLaterAdd(t *testing.T) {
+	url := newServer(t, "coalesced", hub.WithMoveCoalesce(50*time.Millisecond))
+	watcher := connect(t, url, WithEvents(64))
+	editor := connect(t, url)
+
+	from, to, other := hub.Point{X: 1}, hub.Point{X: 2}, hub.Point{X: 3}
+	editor.AddPoint(from)
+	waitFor(t, watcher.Events(), "add")
+	editor.MovePoint(from, to)
+	editor.AddPoint(other)
+	waitFor(t, watcher.Events(), "add")
+	move := waitFor(t, watcher.Events(), "move")
+	if move.Seq != 2 {
+		t.Errorf("coalesced move seq = %d, want that of the move, 2", move.Seq)
+	}
+	xs := map[float64]bool{}
+	for _, p := range watcher.Snapshot() {
+		xs[p.X] = true
+	}
+	if len(xs) != 2 || !xs[2] || !xs[3] {
+		t.Fatalf("mirror x = %v, want 2 and 3", xs)
+	}
+}
+
+// Frames already reflected in the initial state are skipped.
+func TestInitCoversEarlierSequenceNumbers(t *testing.T) {
+	c := &Client{points: make(map[string]hub.Point)}
+	c.apply(hub.Message{Type: "init", Seq: 5, Points: []hub.Point{{X: 1}}})
+	c.apply(hub.Message{Type: "remove", Seq: 5, Point: &hub.Point{X: 1}})
+	if len(c.points) != 1 {
+		t.Fatalf("remove at the init's seq was applied")
+	}
+	c.apply(hub.Message{Type: "deselect", Seq: 6})
+	c.apply(hub.Message{Type: "remove", Seq: 6, Point: &hub.Point{X: 1}})
+	if len(c.points) != 0 {
+		t.Fatalf("remove sharing a deselect's seq was skipped")
+	}
+	if c.lastSeq != 6 {
+		t.Errorf("lastSeq = %d, want 6", c.lastSeq)
+	}
+}
+
+// The mirror keys points with the precision the room announces, so points
+// the server keeps apart stay apart.
+func TestMirrorUsesRoomKeyDecimals(t *testing.T) {
+	url := newServer(t, "fine", hub.WithKeyDecimals(9))
+	watcher := connect(t, url, WithEvents(64))
+	editor := connect(t, url)
+
+	ps := []hub.Point{{X: 1.0000001}, {X: 1.0000002}}
+	if err := editor.AddPoints(ps); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, watcher.Events(), "addBatch")
+	if got := watcher.Snapshot(); len(got) != 2 {
+		t.Fatalf("mirror = %+v, want both points", got)
+	}
+	if err := editor.RemovePoint(ps[0]); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, watcher.Events(), "remove")
+	if got := watcher.Snapshot(); len(got) != 1 || got[0].X != ps[1].X {
+		t.Fatalf("after remove, mirror = %+v", got)
+	}
+}
+
+func TestKeysIgnoreTheSignOfZero(t *testing.T) {
+	c := &Client{keyDecimals: 2}
+	if a, b := c.key(hub.Point{X: -0.001, Y: 1}), c.key(hub.Point{Y: 1}); a != b {
+		t.Errorf("key(-0.001) = %q, key(0) = %q", a, b)
+	}
+}
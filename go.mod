@@ -3,3 +3,9 @@ module github.com/kfrico/universe
 go 1.21
 
 require github.com/gorilla/websocket v1.5.3
+
+require (
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+)
@@ -0,0 +1,52 @@
+package hub
+
+// acceptQueue serializes connection registration through one goroutine so a
+// burst of upgrades takes each room's lock one at a time, in arrival order,
+// instead of all at once. At most depth connections may be waiting; further
+// ones are turned away before the upgrade.
+type acceptQueue struct {
+	slots chan struct{}
+	reqs  chan acceptRequest
+}
+
+type acceptRequest struct {
+	h          *Hub
+	conn       conn
+	compressed bool
+	format     wireFormat
+	done       chan *client
+}
+
+func newAcceptQueue(depth int) *acceptQueue {
+	q := &acceptQueue{slots: make(chan struct{}, depth), reqs: make(chan acceptRequest, depth)}
+	go q.run()
+	return q
+}
+
+func (q *acceptQueue) run() {
+	for req := range q.reqs {
+		req.done <- req.h.addConn(req.conn, req.compressed, req.format)
+	}
+}
+
+// reserve claims a place in the queue, reporting false when it is full.
+func (q *acceptQueue) reserve() bool {
+	select {
+	case q.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// register adds conn to h through the registrar and frees the place claimed
+// by reserve.
+func (q *acceptQueue) register(h *Hub, conn conn, compressed bool, format wireFormat) *client {
+	done := make(chan *client, 1)
+	q.reqs <- acceptRequest{h: h, conn: conn, compressed: compressed, format: format, done: done}
+	c := <-done
+	<-q.slots
+	return c
+}
+
+func (q *acceptQueue) release() { <-q.slots }
@@ -0,0 +1,42 @@
+package hub
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAcceptBurstRegistersEveryConnection(t *testing.T) {
+	const depth = 64
+	h := newHub("test")
+	q := newAcceptQueue(depth)
+
+	var wg sync.WaitGroup
+	for i := 0; i < depth; i++ {
+		if !q.reserve() {
+			t.Fatalf("reservation %d refused below the cap", i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.register(h, discardConn{}, false, formatJSON)
+		}()
+	}
+	wg.Wait()
+	if n := h.connCount(); n != depth {
+		t.Errorf("registered %d connections, want %d", n, depth)
+	}
+
+	// Every place is free again; once they are taken the next is refused.
+	for i := 0; i < depth; i++ {
+		if !q.reserve() {
+			t.Fatalf("reservation %d refused after the burst", i)
+		}
+	}
+	if q.reserve() {
+		t.Error("reservation beyond the cap accepted")
+	}
+	q.release()
+	if !q.reserve() {
+		t.Error("released place not reusable")
+	}
+}
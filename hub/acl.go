@@ -0,0 +1,295 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// role is what an identity may do in a room; each role includes the ones
+// below it.
+type role int32
+
+const (
+	roleNone role = iota
+	roleViewer
+	roleEditor
+	roleAdmin
+)
+
+var roleNames = map[string]role{"viewer": roleViewer, "editor": roleEditor, "admin": roleAdmin}
+
+func (r role) String() string {
+	for name, v := range roleNames {
+		if v == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+// requiredRole is the role needed to send a message of type t. Viewers may
+// only watch; clearing a room or a whole path takes an admin.
+func requiredRole(t string) role {
+	switch t {
+	case "clear", "removePrefix":
+		return roleAdmin
+	case "select", "deselect", "clearSelection", "signal", "chat":
+		return roleEditor
+	}
+	if mutates(t) {
+		return roleEditor
+	}
+	return roleViewer
+}
+
+// anyIdentity matches every caller, including ones without a token.
+const anyIdentity = "*"
+
+// accessList maps identities to roles, with a default table and per-room
+// tables consulted first. It is loaded from the -acl file and written back
+// whenever a room admin edits it.
+type accessList struct {
+	path string
+
+	mu      sync.RWMutex
+	Default map[string]string            `json:"default,omitempty"`
+	Rooms   map[string]map[string]string `json:"rooms,omitempty"`
+}
+
+func loadAccessList(path string) (*accessList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &accessList{path: path}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	if err := validateEntries(a.Default); err != nil {
+		return nil, fmt.Errorf("%s: default: %v", path, err)
+	}
+	for name, entries := range a.Rooms {
+		if !roomNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s: invalid room name %q", path, name)
+		}
+		if err := validateEntries(entries); err != nil {
+			return nil, fmt.Errorf("%s: room %s: %v", path, name, err)
+		}
+	}
+	return a, nil
+}
+
+func validateEntries(entries map[string]string) error {
+	for identity, name := range entries {
+		if identity == "" {
+			return fmt.Errorf("empty identity")
+		}
+		if _, ok := roleNames[name]; !ok {
+			return fmt.Errorf("identity %s: unknown role %q", identity, name)
+		}
+	}
+	return nil
+}
+
+// roleOf resolves identity's role in room: its own entry, then the room's
+// "*" entry, then the same two in the default table. Without an access list
+// everyone is an admin.
+func (a *accessList) roleOf(room, identity string) role {
+	if a == nil {
+		return roleAdmin
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, entries := range []map[string]string{a.Rooms[room], a.Default} {
+		if name, ok := entries[identity]; ok && identity != "" {
+			return roleNames[name]
+		}
+		if name, ok := entries[anyIdentity]; ok {
+			return roleNames[name]
+		}
+	}
+	return roleNone
+}
+
+func (a *accessList) roomEntries(room string) map[string]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make(map[string]string, len(a.Rooms[room]))
+	for identity, name := range a.Rooms[room] {
+		out[identity] = name
+	}
+	return out
+}
+
+// set gives identity the named role in room, or removes its entry when name
+// is empty, and saves the list.
+func (a *accessList) set(room, identity, name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.Rooms == nil {
+		a.Rooms = make(map[string]map[string]string)
+	}
+	entries := a.Rooms[room]
+	if name == "" {
+		delete(entries, identity)
+		if len(entries) == 0 {
+			delete(a.Rooms, room)
+		}
+	} else {
+		if entries == nil {
+			entries = make(map[string]string)
+			a.Rooms[room] = entries
+		}
+		entries[identity] = name
+	}
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.path, data)
+}
+
+// authorize resolves the caller's identity and role in room and reports
+// whether it has at least need, writing a 401 or 403 when it does not.
+func (m *roomManager) authorize(w http.ResponseWriter, r *http.Request, room string, need role) (string, role, bool) {
+	identity, got, _, ok := m.authorizeLimited(w, r, room, need)
+	return identity, got, ok
+}
+
+// authorizeLimited is authorize that also returns the role a token's role
+// claim grants in its room, which stands in for the ACL, or roleNone.
+func (m *roomManager) authorizeLimited(w http.ResponseWriter, r *http.Request, room string, need role) (string, role, role, bool) {
+	if m.jwt == nil {
+		return "", roleAdmin, roleNone, true
+	}
+	identity := ""
+	granted := roleNone
+	if token := requestToken(r); token != "" {
+		claims, err := m.jwt.verify(token, time.Now())
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeErrorResponse(w, http.StatusUnauthorized, errUnauthorized, err.Error())
+			return "", roleNone, roleNone, false
+		}
+		if claims.Room != "" && claims.Room != room {
+			writeErrorResponse(w, http.StatusForbidden, errUnauthorized, fmt.Sprintf("token is only valid in room %s", claims.Room))
+			return claims.Subject, roleNone, roleNone, false
+		}
+		identity = claims.Subject
+		if claims.Role != "" {
+			granted = roleNames[claims.Role]
+		}
+	}
+	var got role
+	switch {
+	case granted != roleNone:
+		got = granted
+	case m.acl != nil:
+		got = m.acl.roleOf(room, identity)
+	case identity != "":
+		got = roleAdmin
+	default:
+		got = m.anonymous
+	}
+	if got < need && identity == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeErrorResponse(w, http.StatusUnauthorized, errUnauthorized, fmt.Sprintf("token required for %s role in room %s", need, room))
+		return identity, got, granted, false
+	}
+	if got < need {
+		writeErrorResponse(w, http.StatusForbidden, errUnauthorized, fmt.Sprintf("%s role required in room %s", need, room))
+		return identity, got, granted, false
+	}
+	return identity, got, granted, true
+}
+
+type aclEntry struct {
+	Identity string `json:"identity"`
+	Role     string `json:"role"`
+}
+
+// aclHandler lets a room's admins list (GET) and change (PUT) its entries.
+// A PUT with an empty role removes the identity's entry. Connected clients
+// pick up their new role immediately.
+func (m *roomManager) aclHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "GET, PUT")
+		writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+		return
+	}
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, _, ok := m.authorize(w, r, name, roleAdmin); !ok {
+		return
+	}
+	if r.Method == http.MethodPut {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+		var e aclEntry
+		if err := m.wire.unmarshal(body, &e); err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+		if e.Role != "" {
+			if err := validateEntries(map[string]string{e.Identity: e.Role}); err != nil {
+				writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+				return
+			}
+		}
+		if e.Identity == "" {
+			writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "identity is required")
+			return
+		}
+		if err := m.acl.set(name, e.Identity, e.Role); err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, errBadRequest, err.Error())
+			return
+		}
+		for _, h := range m.hubs() {
+			if h.room == name {
+				h.refreshRoles(m.acl)
+			}
+		}
+	}
+	entries := m.acl.roomEntries(name)
+	out := make([]aclEntry, 0, len(entries))
+	for identity, roleName := range entries {
+		out = append(out, aclEntry{Identity: identity, Role: roleName})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Identity < out[j].Identity })
+	writeJSONResponse(w, m.wire, http.StatusOK, struct {
+		Room    string     `json:"room"`
+		Entries []aclEntry `json:"entries"`
+	}{name, out})
+}
+
+// refreshRoles re-resolves the role of every connection after an ACL edit.
+func (h *Hub) refreshRoles(a *accessList) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.conns {
+		if c.granted != roleNone {
+			continue
+		}
+		r := a.roleOf(h.room, c.identity)
+		if c.spectator && r > roleViewer {
+			r = roleViewer
+		}
+		c.role.Store(int32(r))
+	}
+}
+
+// permits reports whether c may send a message of type t.
+func (c *client) permits(t string) bool {
+	return role(c.role.Load()) >= requiredRole(t)
+}
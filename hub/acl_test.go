@@ -0,0 +1,100 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRolesGateMessages(t *testing.T) {
+	h := newHub("test")
+	admin, _ := testClient(h)
+	send(t, h, admin, Message{Type: "add", Point: &Point{X: 1}})
+
+	for _, tc := range []struct {
+		role    role
+		allowed []Message
+		denied  []Message
+	}{
+		{roleViewer,
+			[]Message{{Type: "subscribe"}},
+			[]Message{{Type: "add", Point: &Point{X: 2}}, {Type: "select", Point: &Point{X: 1}}, {Type: "signal", Point: &Point{X: 1}}}},
+		{roleEditor,
+			[]Message{{Type: "add", Point: &Point{X: 3}}, {Type: "select", Point: &Point{X: 1}}},
+			[]Message{{Type: "clear"}, {Type: "removePrefix", Path: "a"}}},
+		{roleAdmin,
+			[]Message{{Type: "removePrefix", Path: "a"}, {Type: "clear"}},
+			nil},
+	} {
+		c, fc := testClient(h)
+		c.role.Store(int32(tc.role))
+		for _, msg := range tc.allowed {
+			send(t, h, c, msg)
+			if code := errorCode(t, fc); code != "" {
+				t.Errorf("%s sending %s: error %q", tc.role, msg.Type, code)
+			}
+		}
+		for _, msg := range tc.denied {
+			send(t, h, c, msg)
+			if code := errorCode(t, fc); code != errUnauthorized {
+				t.Errorf("%s sending %s: error %q, want %q", tc.role, msg.Type, code, errUnauthorized)
+			}
+		}
+	}
+}
+
+func TestACLGatesRESTAndIsEditableByAdmins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	err := os.WriteFile(path, []byte(`{"rooms": {"r": {"alice": "viewer", "bob": "editor", "carol": "admin"}}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const secret = "test-secret"
+	s := newTestServer(t, WithJWT(secret, ""), WithACL(path))
+	signer, err := newJWTVerifier(secret, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(who, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if who != "" {
+			token, err := signer.sign(jwtClaims{Subject: who})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for who, want := range map[string]int{"": http.StatusUnauthorized, "alice": http.StatusForbidden, "bob": http.StatusCreated, "mallory": http.StatusForbidden} {
+		if rec := call(who, "POST", "/points?room=r", `{"x":1,"label":"`+who+`"}`); rec.Code != want {
+			t.Errorf("add as %q: %d %s, want %d", who, rec.Code, rec.Body, want)
+		}
+	}
+	if rec := call("alice", "GET", "/points?room=r", ""); rec.Code != http.StatusOK {
+		t.Errorf("viewer listing points: %d", rec.Code)
+	}
+
+	if rec := call("bob", "PUT", "/rooms/acl?room=r", `{"identity":"alice","role":"editor"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("editor changing the ACL: %d", rec.Code)
+	}
+	if rec := call("carol", "PUT", "/rooms/acl?room=r", `{"identity":"alice","role":"editor"}`); rec.Code != http.StatusOK {
+		t.Fatalf("admin changing the ACL: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call("alice", "POST", "/points?room=r", `{"x":2}`); rec.Code != http.StatusCreated {
+		t.Errorf("add after promotion: %d %s", rec.Code, rec.Body)
+	}
+	saved, err := loadAccessList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.roleOf("r", "alice"); got != roleEditor {
+		t.Errorf("saved role of alice = %s, want editor", got)
+	}
+}
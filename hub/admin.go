@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+	return false
+}
+
+// adminStatsHandler reports connection and point totals along with every
+// loaded room and its connections, whose ids /admin/kick takes.
+func (m *roomManager) adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	now := time.Now()
+	out := struct {
+		At     int64       `json:"at"`
+		Conns  int         `json:"conns"`
+		Points int         `json:"points"`
+		Rooms  []debugRoom `json:"rooms"`
+	}{At: now.UnixMilli(), Rooms: []debugRoom{}}
+	for _, h := range m.hubs() {
+		d := h.debugState(now)
+		out.Conns += len(d.Conns)
+		out.Points += d.Points
+		out.Rooms = append(out.Rooms, d)
+	}
+	sort.Slice(out.Rooms, func(i, j int) bool { return out.Rooms[i].Room < out.Rooms[j].Room })
+	writeJSONResponse(w, m.wire, http.StatusOK, out)
+}
+
+// adminClearHandler removes every point of the room named by ?room=, or of
+// every loaded room without one, broadcasting clear like the WebSocket
+// message would.
+func (m *roomManager) adminClearHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var hubs []*Hub
+	if r.URL.Query().Get("room") != "" {
+		name, ok := roomFromRequest(w, r)
+		if !ok {
+			return
+		}
+		h := m.acquire(name)
+		defer m.release(h)
+		hubs = []*Hub{h}
+	} else {
+		hubs = m.hubs()
+	}
+	cleared := map[string]int{}
+	for _, h := range hubs {
+		h.sequenced(func() {
+			removed := h.clearPoints("admin")
+			h.afterRemove(removed)
+			if len(removed) > 0 {
+				h.broadcast(Message{Type: "clear"})
+			}
+			cleared[h.room] = len(removed)
+		})
+	}
+	writeJSONResponse(w, m.wire, http.StatusOK, struct {
+		Cleared map[string]int `json:"cleared"`
+	}{cleared})
+}
+
+// adminKickHandler disconnects the connection named by ?conn= with a
+// policy-violation close frame.
+func (m *roomManager) adminKickHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	id := r.URL.Query().Get("conn")
+	if id == "" {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "conn is required")
+		return
+	}
+	for _, h := range m.hubs() {
+		if c := h.connByID(id); c != nil {
+			h.kick(c)
+			writeJSONResponse(w, m.wire, http.StatusOK, struct {
+				Room string `json:"room"`
+				Conn string `json:"conn"`
+			}{h.room, id})
+			return
+		}
+	}
+	writeErrorResponse(w, http.StatusNotFound, errNotFound, "no such connection")
+}
+
+func (h *Hub) connByID(id string) *client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.conns {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (h *Hub) kick(c *client) {
+	c.log.Info("kicking connection")
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "disconnected by an administrator")
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		c.log.Warn("close write failed", "err", err)
+	}
+	h.removeConn(c)
+}
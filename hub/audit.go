@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+)
+
+type auditEntry struct {
+	Time  int64  `json:"time"`
+	Seq   uint64 `json:"seq"`
+	Room  string `json:"room"`
+	Op    string `json:"op"`
+	Actor string `json:"actor"`
+	Point Point  `json:"point"`
+	From  *Point `json:"from,omitempty"`
+}
+
+// auditLog appends mutations to a file from its own goroutine so that the
+// hub never waits on disk I/O. Mutations recorded after Close are dropped.
+type auditLog struct {
+	file    *os.File
+	entries chan auditEntry
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+func openAuditLog(path string, buffer int) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{
+		file:    f,
+		entries: make(chan auditEntry, buffer),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+func (a *auditLog) record(m Mutation) {
+	if a == nil {
+		return
+	}
+	select {
+	case <-a.quit:
+		return
+	default:
+	}
+	e := auditEntry{Time: m.Time.UnixMilli(), Seq: m.Seq, Room: m.Room, Op: m.Type, Actor: m.Actor, Point: m.Point, From: m.From}
+	select {
+	case a.entries <- e:
+	default:
+		slog.Warn("audit log buffer full, dropping entry", "room", m.Room, "type", m.Type, "actor", m.Actor)
+	}
+}
+
+func (a *auditLog) run() {
+	defer close(a.done)
+	w := bufio.NewWriter(a.file)
+	enc := json.NewEncoder(w)
+	for {
+		var e auditEntry
+		select {
+		case e = <-a.entries:
+		case <-a.quit:
+			if len(a.entries) == 0 {
+				return
+			}
+			e = <-a.entries
+		}
+		if err := enc.Encode(e); err != nil {
+			slog.Error("audit encode failed", "err", err)
+		}
+		// Drain whatever is already queued before paying for the fsync.
+		for pending := len(a.entries); pending > 0; pending-- {
+			if err := enc.Encode(<-a.entries); err != nil {
+				slog.Error("audit encode failed", "err", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			slog.Error("audit write failed", "err", err)
+			continue
+		}
+		if err := a.file.Sync(); err != nil {
+			slog.Error("audit sync failed", "err", err)
+		}
+	}
+}
+
+// Close writes out what is queued and closes the file.
+func (a *auditLog) Close() error {
+	close(a.quit)
+	<-a.done
+	return a.file.Close()
+}
@@ -0,0 +1,57 @@
+package hub
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLogRecordsMutations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := openAuditLog(path, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHub("test")
+	h.audit = a
+	c, _ := testClient(h)
+
+	from, to := Point{X: 1}, Point{X: 2}
+	send(t, h, c, Message{Type: "add", Point: &from})
+	send(t, h, c, Message{Type: "move", From: &from, To: &to})
+	send(t, h, c, Message{Type: "remove", Point: &to})
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Mutations after Close are dropped.
+	a.record(Mutation{Type: "add", Room: "test"})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []auditEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	want := []string{"add", "move", "remove"}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries %+v, want %v", len(entries), entries, want)
+	}
+	for i, e := range entries {
+		if e.Op != want[i] || e.Seq != uint64(i+1) || e.Room != "test" || e.Actor == "" {
+			t.Errorf("entry %d = %+v, want %s at seq %d", i, e, want[i], i+1)
+		}
+	}
+	if entries[1].From == nil || entries[1].From.X != 1 || entries[1].Point.X != 2 {
+		t.Errorf("move entry = %+v, want from x=1 to x=2", entries[1])
+	}
+}
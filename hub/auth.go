@@ -0,0 +1,144 @@
+package hub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtVerifier checks HS256-signed JSON Web Tokens and yields their subject
+// as the caller's identity. A token's kid header selects its key; tokens
+// without one are checked against the "" key.
+type jwtVerifier struct {
+	keys map[string][]byte
+}
+
+// newJWTVerifier combines secret, used for tokens without a kid, with the
+// keys of the JSON object of kid to secret in keysPath, so keys can be
+// rotated by issuing tokens under a new kid.
+func newJWTVerifier(secret, keysPath string) (*jwtVerifier, error) {
+	v := &jwtVerifier{keys: map[string][]byte{}}
+	if secret != "" {
+		v.keys[""] = []byte(secret)
+	}
+	if keysPath != "" {
+		data, err := os.ReadFile(keysPath)
+		if err != nil {
+			return nil, err
+		}
+		var keys map[string]string
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", keysPath, err)
+		}
+		for kid, key := range keys {
+			if key == "" {
+				return nil, fmt.Errorf("%s: empty secret for kid %q", keysPath, kid)
+			}
+			v.keys[kid] = []byte(key)
+		}
+	}
+	return v, nil
+}
+
+// jwtClaims are the claims the server reads. Room restricts a token to one
+// room. Role, which is only accepted together with Room, is the role the
+// token grants there in place of the subject's ACL role, as in view links.
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Room      string `json:"room,omitempty"`
+	Role      string `json:"role,omitempty"`
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+func (v *jwtVerifier) verify(token string, now time.Time) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return jwtClaims{}, errInvalidToken
+	}
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		return jwtClaims{}, errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return jwtClaims{}, errInvalidToken
+	}
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" {
+		return jwtClaims{}, errInvalidToken
+	}
+	if _, ok := roleNames[claims.Role]; claims.Role != "" && !ok {
+		return jwtClaims{}, errInvalidToken
+	}
+	if claims.Role != "" && claims.Room == "" {
+		return jwtClaims{}, errors.New("role claim requires a room claim")
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return jwtClaims{}, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return jwtClaims{}, errors.New("token not yet valid")
+	}
+	return claims, nil
+}
+
+// sign issues an HS256 token for claims with the secret, the key without a
+// kid.
+func (v *jwtVerifier) sign(claims jwtClaims) (string, error) {
+	key, ok := v.keys[""]
+	if !ok {
+		return "", errors.New("signing tokens requires a JWT secret")
+	}
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// requestToken returns the bearer token of r, falling back to the
+// access_token query parameter since browsers cannot set headers on
+// WebSocket upgrades.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("access_token")
+}
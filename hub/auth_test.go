@@ -0,0 +1,97 @@
+package hub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawToken signs header and claims with key, whatever they contain.
+func rawToken(t *testing.T, key string, header, claims interface{}) string {
+	t.Helper()
+	seg := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	unsigned := seg(header) + "." + seg(claims)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTVerification(t *testing.T) {
+	keys := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(keys, []byte(`{"2024": "rotated-secret"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := newJWTVerifier("secret", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	hs256 := map[string]string{"alg": "HS256"}
+	valid := rawToken(t, "secret", hs256, jwtClaims{Subject: "alice"})
+	parts := strings.Split(valid, ".")
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"rotated kid", rawToken(t, "rotated-secret", map[string]string{"alg": "HS256", "kid": "2024"}, jwtClaims{Subject: "alice"}), true},
+		{"bad signature", rawToken(t, "other-secret", hs256, jwtClaims{Subject: "alice"}), false},
+		{"tampered claims", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "." + parts[2], false},
+		{"signature not base64", parts[0] + "." + parts[1] + ".!!", false},
+		{"missing signature", parts[0] + "." + parts[1], false},
+		{"alg none", rawToken(t, "secret", map[string]string{"alg": "none"}, jwtClaims{Subject: "alice"}), false},
+		{"alg none unsigned", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".", false},
+		{"alg HS512", rawToken(t, "secret", map[string]string{"alg": "HS512"}, jwtClaims{Subject: "alice"}), false},
+		{"alg RS256", rawToken(t, "secret", map[string]string{"alg": "RS256"}, jwtClaims{Subject: "alice"}), false},
+		{"unknown kid", rawToken(t, "secret", map[string]string{"alg": "HS256", "kid": "2023"}, jwtClaims{Subject: "alice"}), false},
+		{"kid signed with the default key", rawToken(t, "secret", map[string]string{"alg": "HS256", "kid": "2024"}, jwtClaims{Subject: "alice"}), false},
+		{"expired", rawToken(t, "secret", hs256, jwtClaims{Subject: "alice", ExpiresAt: now.Unix() - 1}), false},
+		{"expiring now", rawToken(t, "secret", hs256, jwtClaims{Subject: "alice", ExpiresAt: now.Unix()}), false},
+		{"not yet expired", rawToken(t, "secret", hs256, jwtClaims{Subject: "alice", ExpiresAt: now.Unix() + 1}), true},
+		{"nbf in the future", rawToken(t, "secret", hs256, jwtClaims{Subject: "alice", NotBefore: now.Unix() + 60}), false},
+		{"nbf reached", rawToken(t, "secret", hs256, jwtClaims{Subject: "alice", NotBefore: now.Unix()}), true},
+		{"role without room", rawToken(t, "secret", hs256, jwtClaims{Subject: "alice", Role: "viewer"}), false},
+		{"role with room", rawToken(t, "secret", hs256, jwtClaims{Subject: "alice", Role: "viewer", Room: "r"}), true},
+		{"unknown role", rawToken(t, "secret", hs256, jwtClaims{Subject: "alice", Role: "owner", Room: "r"}), false},
+		{"no subject", rawToken(t, "secret", hs256, jwtClaims{}), false},
+		{"not a JWT", "token", false},
+	} {
+		_, err := v.verify(tc.token, now)
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestRejectedTokensGetUnauthorized(t *testing.T) {
+	s := newTestServer(t, WithJWT("secret", ""))
+	for name, token := range map[string]string{
+		"bad signature":     rawToken(t, "wrong", map[string]string{"alg": "HS256"}, jwtClaims{Subject: "alice"}),
+		"expired":           rawToken(t, "secret", map[string]string{"alg": "HS256"}, jwtClaims{Subject: "alice", ExpiresAt: 1}),
+		"role without room": rawToken(t, "secret", map[string]string{"alg": "HS256"}, jwtClaims{Subject: "alice", Role: "admin"}),
+	} {
+		req := httptest.NewRequest("GET", "/points?room=r", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: %d %q, want 401 with a Bearer challenge", name, rec.Code, rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
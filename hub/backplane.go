@@ -0,0 +1,200 @@
+package hub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+var (
+	errPointMissing = errors.New("point is not in this room")
+	errPointTaken   = errors.New("another point already has this key")
+)
+
+// backplane carries point mutations between server instances serving the
+// same rooms, so clients connected to different instances see one room.
+type backplane interface {
+	publish(data []byte) error
+	// subscribe calls deliver with every published payload, including this
+	// instance's own, until the process exits.
+	subscribe(deliver func([]byte))
+}
+
+// backplaneEvent is a mutation as published on the backplane.
+type backplaneEvent struct {
+	Origin string `json:"origin"`
+	Room   string `json:"room"`
+	Type   string `json:"type"`
+	Point  Point  `json:"point"`
+	From   *Point `json:"from,omitempty"`
+	Actor  string `json:"actor,omitempty"`
+}
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// joinBackplane publishes every local mutation of every room on bp and
+// applies the ones published by other instances. Only point changes travel;
+// selections, locks, signals and presence stay local to each instance, and a
+// room's existing points are not exchanged, so instances should share a
+// -data-dir snapshot or start out empty.
+func (m *roomManager) joinBackplane(bp backplane) {
+	origin := newInstanceID()
+	out := newBackplaneOutbox()
+	go out.run(bp)
+	publish := func(mu Mutation) {
+		out.push(backplaneEvent{Origin: origin, Room: mu.Room, Type: mu.Type, Point: mu.Point, From: mu.From, Actor: mu.Actor})
+	}
+	m.mu.Lock()
+	m.publish = publish
+	for _, r := range m.rooms {
+		r.hub.setPublisher(publish)
+	}
+	m.mu.Unlock()
+	go bp.subscribe(func(data []byte) {
+		var e backplaneEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			slog.Warn("backplane decode failed", "err", err)
+			return
+		}
+		if e.Origin == origin || !roomNamePattern.MatchString(e.Room) {
+			return
+		}
+		h := m.acquire(e.Room)
+		defer m.release(h)
+		h.applyRemote(Mutation{Type: e.Type, Point: e.Point, From: e.From, Actor: e.Actor})
+	})
+}
+
+// backplaneOutbox queues events for publishing in the order they happened.
+// It is not bounded like the observer channel: an event dropped here would
+// leave the other instances with a different room for good.
+type backplaneOutbox struct {
+	mu      sync.Mutex
+	pending []backplaneEvent
+	wake    chan struct{}
+}
+
+func newBackplaneOutbox() *backplaneOutbox {
+	return &backplaneOutbox{wake: make(chan struct{}, 1)}
+}
+
+// push queues e without blocking.
+func (o *backplaneOutbox) push(e backplaneEvent) {
+	o.mu.Lock()
+	o.pending = append(o.pending, e)
+	o.mu.Unlock()
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for queued events and takes all of them.
+func (o *backplaneOutbox) next() []backplaneEvent {
+	for {
+		o.mu.Lock()
+		batch := o.pending
+		o.pending = nil
+		o.mu.Unlock()
+		if len(batch) > 0 {
+			return batch
+		}
+		<-o.wake
+	}
+}
+
+func (o *backplaneOutbox) run(bp backplane) {
+	for {
+		for _, e := range o.next() {
+			data, err := json.Marshal(e)
+			if err != nil {
+				slog.Error("backplane marshal failed", "err", err)
+				continue
+			}
+			if err := bp.publish(data); err != nil {
+				slog.Warn("backplane publish failed", "room", e.Room, "err", err)
+			}
+		}
+	}
+}
+
+// setPublisher hands the hub's future local mutations to publish.
+func (h *Hub) setPublisher(publish func(Mutation)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publish = publish
+}
+
+// applyRemote applies a mutation made on another instance and broadcasts it
+// to this instance's clients. Mutations that do not apply here, because the
+// point they target is missing or the room would not accept it, are skipped
+// so that they are neither counted nor sent on.
+func (h *Hub) applyRemote(mu Mutation) {
+	h.sequenced(func() {
+		h.mu.Lock()
+		if err := h.applyRemoteLocked(&mu); err != nil {
+			h.mu.Unlock()
+			slog.Debug("backplane mutation skipped", "room", h.room, "type", mu.Type, "err", err)
+			return
+		}
+		mu.Remote = true
+		h.emit(mu)
+		msg := mutationMessage(mu)
+		msg.Seq = h.seq
+		h.mu.Unlock()
+		if mu.Type == "remove" {
+			h.afterRemove([]Point{mu.Point})
+		}
+		h.broadcast(msg)
+	})
+}
+
+// applyRemoteLocked changes h.points for mu, or reports why it cannot. A
+// removal's Point is replaced by the one that was stored. Must be called with
+// h.mu held.
+func (h *Hub) applyRemoteLocked(mu *Mutation) error {
+	key := h.key(mu.Point)
+	switch mu.Type {
+	case "add":
+		if _, err := h.admit(mu.Point, key); err != nil {
+			return err
+		}
+	case "update":
+		if _, ok := h.points[key]; !ok {
+			return errPointMissing
+		}
+	case "remove":
+		stored, ok := h.points[key]
+		if !ok {
+			return errPointMissing
+		}
+		delete(h.points, key)
+		mu.Point = stored
+		return nil
+	case "move":
+		if mu.From == nil {
+			return errPointMissing
+		}
+		from := h.key(*mu.From)
+		if _, ok := h.points[from]; !ok {
+			return errPointMissing
+		}
+		if _, taken := h.points[key]; taken && key != from {
+			return errPointTaken
+		}
+		delete(h.points, from)
+	default:
+		return fmt.Errorf("unknown mutation type %q", mu.Type)
+	}
+	h.points[key] = mu.Point
+	h.trackExpiry(mu.Point)
+	return nil
+}
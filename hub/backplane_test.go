@@ -0,0 +1,162 @@
+package hub
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryBackplane records what is published and lets tests deliver events.
+type memoryBackplane struct {
+	mu        sync.Mutex
+	published []backplaneEvent
+	deliver   chan func([]byte)
+}
+
+func newMemoryBackplane() *memoryBackplane {
+	return &memoryBackplane{deliver: make(chan func([]byte), 1)}
+}
+
+func (b *memoryBackplane) publish(data []byte) error {
+	var e backplaneEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.published = append(b.published, e)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *memoryBackplane) subscribe(deliver func([]byte)) { b.deliver <- deliver }
+
+func (b *memoryBackplane) events() []backplaneEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]backplaneEvent(nil), b.published...)
+}
+
+func TestRemoteMutationsKeepCountsConsistent(t *testing.T) {
+	s := newTestServer(t, WithPointLimits(100, 10))
+	s.Room("r", func(h *Hub) {
+		a, b := Point{X: 1, Owner: "alice"}, Point{X: 2, Owner: "alice"}
+		for _, mu := range []Mutation{
+			{Type: "add", Point: a},
+			{Type: "add", Point: a},
+			{Type: "add", Point: b},
+			{Type: "remove", Point: Point{X: 9, Owner: "alice"}},
+			{Type: "move", From: &Point{X: 8, Owner: "alice"}, Point: Point{X: 7, Owner: "alice"}},
+			{Type: "move", From: &b, Point: a},
+			{Type: "update", Point: Point{X: 6, Owner: "alice"}},
+			{Type: "remove", Point: b},
+			{Type: "remove", Point: b},
+		} {
+			h.applyRemote(mu)
+		}
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if len(h.points) != 1 {
+			t.Fatalf("points = %v, want only the first add", h.points)
+		}
+		if got := h.limits.total.Load(); got != 1 {
+			t.Errorf("server total = %d, want 1", got)
+		}
+		if got := h.owned["alice"]; got != 1 {
+			t.Errorf("alice owns %d, want 1", got)
+		}
+		if h.seq != 3 {
+			t.Errorf("seq = %d, want 3 for the two adds and one remove", h.seq)
+		}
+	})
+}
+
+func TestRemoteAddsRespectLimits(t *testing.T) {
+	s := newTestServer(t, WithPointLimits(0, 2))
+	s.Room("r", func(h *Hub) {
+		for x := 1.0; x <= 3; x++ {
+			h.applyRemote(Mutation{Type: "add", Point: Point{X: x, Owner: "bob"}})
+		}
+		if n := len(h.Points()); n != 2 {
+			t.Fatalf("room holds %d points, want the per-owner limit of 2", n)
+		}
+	})
+}
+
+func TestRemoteMutationsAreNotPublished(t *testing.T) {
+	s := newTestServer(t)
+	bp := newMemoryBackplane()
+	s.rooms.joinBackplane(bp)
+	s.Room("r", func(h *Hub) {
+		h.applyRemote(Mutation{Type: "add", Point: Point{X: 1}})
+		h.Add(Point{X: 2}, "local")
+	})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(bp.events()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	got := bp.events()
+	if len(got) != 1 || got[0].Point.X != 2 {
+		t.Fatalf("published %+v, want only the local add", got)
+	}
+}
+
+func TestPublishingSurvivesSlowObservers(t *testing.T) {
+	s := newTestServer(t)
+	bp := newMemoryBackplane()
+	s.rooms.joinBackplane(bp)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	s.OnMutation(func(Mutation) { <-unblock })
+	const n = 3 * mutationBuffer
+	s.Room("r", func(h *Hub) {
+		for i := 0; i < n; i++ {
+			h.Add(Point{X: float64(i)}, "local")
+		}
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(bp.events()) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	got := bp.events()
+	if len(got) != n {
+		t.Fatalf("published %d mutations, want %d", len(got), n)
+	}
+	for i, e := range got {
+		if e.Point.X != float64(i) {
+			t.Fatalf("event %d is for x=%v, want publishing in order", i, e.Point.X)
+		}
+	}
+}
+
+func TestRemoteEventsFromThisInstanceAreIgnored(t *testing.T) {
+	s := newTestServer(t)
+	bp := newMemoryBackplane()
+	s.rooms.joinBackplane(bp)
+	deliver := <-bp.deliver
+	s.Room("r", func(h *Hub) { h.Add(Point{X: 1}, "local") })
+	deadline := time.Now().Add(2 * time.Second)
+	for len(bp.events()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	own := bp.events()[0]
+	own.Point.X = 5
+	data, _ := json.Marshal(own)
+	deliver(data)
+	foreign := own
+	foreign.Origin = "elsewhere"
+	foreign.Point.X = 6
+	data, _ = json.Marshal(foreign)
+	deliver(data)
+	s.Room("r", func(h *Hub) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.points[h.key(Point{X: 5})]; ok {
+			t.Error("applied an event published by this instance")
+		}
+		if _, ok := h.points[h.key(Point{X: 6})]; !ok {
+			t.Error("did not apply an event from another instance")
+		}
+	})
+}
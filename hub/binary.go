@@ -0,0 +1,151 @@
+package hub
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/gorilla/websocket"
+)
+
+// Binary move frames let a dragging client send compact position updates.
+// All integers are little endian:
+//
+//	byte     op (1 = move)
+//	byte     flags (bit 0: absolute position)
+//	uint16   length of the point reference, followed by its bytes
+//	3×int16  x, y, z deltas in units of the room's quantum, or, with the
+//	         absolute flag, 3×float64 coordinates
+//
+// The reference is the point's id in id mode and its coordinate key
+// ("x,y,z" with the room's keyDecimals, see /capabilities) otherwise. The quantum is announced in init.
+//
+// To keep quantization error from accumulating, a client should compute each
+// delta against the position it has reported so far (the sum of the quantized
+// deltas), not against its previous raw position, and send an absolute frame
+// at the end of a drag and whenever a delta would overflow int16.
+const (
+	binaryOpMove       = 1
+	binaryFlagAbsolute = 1
+)
+
+type binaryMove struct {
+	ref      string
+	absolute bool
+	delta    [3]int16
+	pos      [3]float64
+}
+
+var errShortFrame = errors.New("binary frame truncated")
+
+func encodeBinaryMove(m binaryMove) []byte {
+	size := 4 + len(m.ref) + 6
+	if m.absolute {
+		size = 4 + len(m.ref) + 24
+	}
+	b := make([]byte, 0, size)
+	flags := byte(0)
+	if m.absolute {
+		flags |= binaryFlagAbsolute
+	}
+	b = append(b, binaryOpMove, flags)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(m.ref)))
+	b = append(b, m.ref...)
+	if m.absolute {
+		for _, v := range m.pos {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+		return b
+	}
+	for _, d := range m.delta {
+		b = binary.LittleEndian.AppendUint16(b, uint16(d))
+	}
+	return b
+}
+
+func decodeBinaryMove(b []byte) (binaryMove, error) {
+	var m binaryMove
+	if len(b) < 4 {
+		return m, errShortFrame
+	}
+	if b[0] != binaryOpMove {
+		return m, errors.New("unknown binary op")
+	}
+	m.absolute = b[1]&binaryFlagAbsolute != 0
+	n := int(binary.LittleEndian.Uint16(b[2:4]))
+	b = b[4:]
+	if len(b) < n {
+		return m, errShortFrame
+	}
+	m.ref, b = string(b[:n]), b[n:]
+	if m.absolute {
+		if len(b) != 24 {
+			return m, errShortFrame
+		}
+		for i := range m.pos {
+			m.pos[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+		}
+		return m, nil
+	}
+	if len(b) != 6 {
+		return m, errShortFrame
+	}
+	for i := range m.delta {
+		m.delta[i] = int16(binary.LittleEndian.Uint16(b[2*i:]))
+	}
+	return m, nil
+}
+
+// codecOf classifies an incoming frame. MessagePack clients send their
+// messages in binary frames too, but a message is always a map, whose first
+// byte never equals a move op.
+func (c *client) codecOf(kind int, data []byte) string {
+	switch {
+	case kind != websocket.BinaryMessage:
+		return "json"
+	case c.format == formatMsgpack && (len(data) == 0 || data[0] != binaryOpMove):
+		return "msgpack"
+	}
+	return "binary"
+}
+
+// handleBinary applies a binary move frame and rebroadcasts the result as a
+// regular move message.
+func (h *Hub) handleBinary(c *client, data []byte) error {
+	if h.config.ReadOnly {
+		return c.replyError(invalid(errReadOnly, "room %s is read-only", h.room))
+	}
+	if !c.permits("move") {
+		return c.replyError(invalid(errUnauthorized, "%s role required for move", requiredRole("move")))
+	}
+	m, err := decodeBinaryMove(data)
+	if err != nil {
+		return c.replyError(invalid(errBadRequest, "%v", err))
+	}
+	from, to, verr := h.moveByKey(m.ref, c.id, func(p Point) Point {
+		if m.absolute {
+			abs := Point{X: m.pos[0], Y: m.pos[1], Z: m.pos[2]}
+			if h.transform != nil {
+				abs = h.transform.apply(abs)
+			}
+			p.X, p.Y, p.Z = abs.X, abs.Y, abs.Z
+			return p
+		}
+		d := [3]float64{
+			float64(m.delta[0]) * h.moveQuantum,
+			float64(m.delta[1]) * h.moveQuantum,
+			float64(m.delta[2]) * h.moveQuantum,
+		}
+		if h.transform != nil {
+			d = h.transform.applyDelta(d)
+		}
+		p.X, p.Y, p.Z = p.X+d[0], p.Y+d[1], p.Z+d[2]
+		return p
+	})
+	if verr != nil {
+		return c.replyError(verr)
+	}
+	h.moves.add(h.key(from), h.key(to), from, to)
+	c.undo.record(h, change{removed: []Point{from}, added: []Point{to}, move: true})
+	return nil
+}
@@ -0,0 +1,52 @@
+package hub
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBinaryMoveRoundTrip(t *testing.T) {
+	for _, m := range []binaryMove{
+		{ref: "1,2,3", delta: [3]int16{1, -2, math.MaxInt16}},
+		{ref: "p7", absolute: true, pos: [3]float64{1.5, -2, 1e9}},
+	} {
+		got, err := decodeBinaryMove(encodeBinaryMove(m))
+		if err != nil || got != m {
+			t.Errorf("decode(encode(%+v)) = %+v, %v", m, got, err)
+		}
+	}
+	b := encodeBinaryMove(binaryMove{ref: "1,2,3"})
+	if _, err := decodeBinaryMove(b[:len(b)-1]); err != errShortFrame {
+		t.Errorf("truncated frame: err = %v", err)
+	}
+}
+
+func TestBinaryMoveAppliesQuantizedDelta(t *testing.T) {
+	h := newHub("test")
+	h.moveQuantum = 0.5
+	c, _ := testClient(h)
+	_, watcher := testClient(h)
+	start := Point{X: 1, Y: 1, Z: 1}
+	send(t, h, c, Message{Type: "add", Point: &start})
+	watcher.messages(t)
+
+	frame := encodeBinaryMove(binaryMove{ref: h.key(start), delta: [3]int16{2, 0, -4}})
+	var err error
+	h.sequenced(func() { err = h.handleBinary(c, frame) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves := watcher.ofType(t, "move")
+	if len(moves) != 1 || h.key(*moves[0].To) != h.key(Point{X: 2, Y: 1, Z: -1}) {
+		t.Fatalf("got moves %+v, want one to (2, 1, -1)", moves)
+	}
+
+	frame = encodeBinaryMove(binaryMove{ref: h.key(Point{X: 2, Y: 1, Z: -1}), absolute: true, pos: [3]float64{5, 6, 7}})
+	h.sequenced(func() { err = h.handleBinary(c, frame) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.points[h.key(Point{X: 5, Y: 6, Z: 7})]; !ok || len(h.points) != 1 {
+		t.Errorf("absolute move left %+v", h.points)
+	}
+}
@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// boundsTracker keeps a room's bounding box current and broadcasts it at
+// most once per interval, and only when a corner has moved by more than
+// threshold since the last broadcast. Removing or moving a point on the edge
+// of the box marks it stale; the rescan that fixes it runs on the throttled
+// flush, so a burst of removals costs at most one scan per interval.
+type boundsTracker struct {
+	interval  time.Duration
+	threshold float64
+
+	// Guarded by the hub's mu.
+	min, max [3]float64
+	empty    bool
+	stale    bool
+
+	mu       sync.Mutex
+	armed    bool
+	sent     bool
+	sentMin  [3]float64
+	sentMax  [3]float64
+	sentNone bool
+}
+
+func newBoundsTracker(interval time.Duration, threshold float64) *boundsTracker {
+	return &boundsTracker{interval: interval, threshold: threshold, empty: true, stale: true}
+}
+
+func coords(p Point) [3]float64 { return [3]float64{p.X, p.Y, p.Z} }
+
+func (b *boundsTracker) extend(p Point) {
+	c := coords(p)
+	if b.empty {
+		b.min, b.max, b.empty = c, c, false
+		return
+	}
+	for i := range c {
+		b.min[i] = math.Min(b.min[i], c[i])
+		b.max[i] = math.Max(b.max[i], c[i])
+	}
+}
+
+func (b *boundsTracker) onEdge(p Point) bool {
+	c := coords(p)
+	for i := range c {
+		if c[i] == b.min[i] || c[i] == b.max[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// trackBounds updates the bounding box for a mutation. Must be called with
+// h.mu held.
+func (h *Hub) trackBounds(m Mutation) {
+	b := h.bounds
+	switch m.Type {
+	case "add", "update":
+		b.extend(m.Point)
+	case "move":
+		if !b.stale && b.onEdge(*m.From) {
+			b.stale = true
+		}
+		b.extend(m.Point)
+	case "remove":
+		if !b.stale && b.onEdge(m.Point) {
+			b.stale = true
+		}
+	}
+	b.mu.Lock()
+	if !b.armed {
+		b.armed = true
+		time.AfterFunc(b.interval, h.flushBounds)
+	}
+	b.mu.Unlock()
+}
+
+func (h *Hub) flushBounds() {
+	b := h.bounds
+	h.mu.Lock()
+	if b.stale {
+		b.empty, b.stale = true, false
+		for _, p := range h.points {
+			b.extend(p)
+		}
+	}
+	lo, hi, empty := b.min, b.max, b.empty
+	h.mu.Unlock()
+
+	b.mu.Lock()
+	b.armed = false
+	changed := !b.sent || empty != b.sentNone
+	if !changed && !empty {
+		for i := range lo {
+			if math.Abs(lo[i]-b.sentMin[i]) > b.threshold || math.Abs(hi[i]-b.sentMax[i]) > b.threshold {
+				changed = true
+				break
+			}
+		}
+	}
+	if changed {
+		b.sent, b.sentMin, b.sentMax, b.sentNone = true, lo, hi, empty
+	}
+	b.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	msg := Message{Type: "bounds"}
+	if !empty {
+		msg.Min, msg.Max = &lo, &hi
+	}
+	h.broadcast(msg)
+}
@@ -0,0 +1,41 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoundsShrinkWhenTheExtremeIsRemoved(t *testing.T) {
+	h := newHub("test")
+	// Flushed by hand below rather than by the timer.
+	h.bounds = newBoundsTracker(time.Hour, 0.5)
+	c, _ := testClient(h)
+	_, watcher := testClient(h)
+
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{{X: -1}, {X: 1}, {X: 10, Y: 4}}})
+	h.flushBounds()
+	b := watcher.ofType(t, "bounds")
+	if len(b) != 1 || *b[0].Min != [3]float64{-1, 0, 0} || *b[0].Max != [3]float64{10, 4, 0} {
+		t.Fatalf("got bounds %+v", b)
+	}
+
+	send(t, h, c, Message{Type: "remove", Point: &Point{X: 10, Y: 4}})
+	h.flushBounds()
+	b = watcher.ofType(t, "bounds")
+	if len(b) != 1 || *b[0].Min != [3]float64{-1, 0, 0} || *b[0].Max != [3]float64{1, 0, 0} {
+		t.Fatalf("after removing the extreme point got %+v", b)
+	}
+
+	// A change within the threshold is not broadcast.
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1.2}})
+	h.flushBounds()
+	if b := watcher.ofType(t, "bounds"); len(b) != 0 {
+		t.Errorf("change within the threshold broadcast %+v", b)
+	}
+
+	send(t, h, c, Message{Type: "clear"})
+	h.flushBounds()
+	if b := watcher.ofType(t, "bounds"); len(b) != 1 || b[0].Min != nil {
+		t.Errorf("empty room got bounds %+v", b)
+	}
+}
@@ -0,0 +1,137 @@
+package hub
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// changeLog records when each point was last added or modified and keeps
+// the keys of recently removed points, so pollers can fetch only what changed
+// since their previous request. Tombstones are kept for retention and at
+// most limit of them; a poll reaching further back than the oldest dropped
+// tombstone gets a full listing with reset set.
+type changeLog struct {
+	retention time.Duration
+	limit     int
+
+	modified   map[string]int64
+	tombstones []tombstone
+	horizon    int64
+}
+
+type tombstone struct {
+	key string
+	at  int64
+}
+
+func newChangeLog(retention time.Duration, limit int) *changeLog {
+	return &changeLog{retention: retention, limit: limit, modified: make(map[string]int64)}
+}
+
+func (l *changeLog) touch(key string, at int64) {
+	l.modified[key] = at
+}
+
+func (l *changeLog) remove(key string, at int64) {
+	delete(l.modified, key)
+	l.tombstones = append(l.tombstones, tombstone{key: key, at: at})
+	l.prune(at)
+}
+
+// prune drops tombstones beyond the retention window or the limit.
+func (l *changeLog) prune(now int64) {
+	drop := 0
+	if l.limit > 0 && len(l.tombstones) > l.limit {
+		drop = len(l.tombstones) - l.limit
+	}
+	cutoff := now - l.retention.Milliseconds()
+	for drop < len(l.tombstones) && l.tombstones[drop].at < cutoff {
+		drop++
+	}
+	if drop == 0 {
+		return
+	}
+	l.horizon = l.tombstones[drop-1].at
+	l.tombstones = append(l.tombstones[:0], l.tombstones[drop:]...)
+}
+
+// trackChanges updates the change log for a mutation. Must be called with
+// h.mu held.
+func (h *Hub) trackChanges(m Mutation) {
+	at := m.Time.UnixMilli()
+	switch m.Type {
+	case "add", "update":
+		h.changes.touch(h.key(m.Point), at)
+	case "move":
+		if from := h.key(*m.From); from != h.key(m.Point) {
+			h.changes.remove(from, at)
+		}
+		h.changes.touch(h.key(m.Point), at)
+	case "remove":
+		h.changes.remove(h.key(m.Point), at)
+	}
+}
+
+type changesBody struct {
+	Now     int64    `json:"now"`
+	Reset   bool     `json:"reset,omitempty"`
+	Points  []Point  `json:"points"`
+	Removed []string `json:"removed"`
+}
+
+// changedSince lists the points modified after since and the keys removed
+// after it.
+func (h *Hub) changedSince(since int64) changesBody {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now().UnixMilli()
+	l := h.changes
+	l.prune(now)
+	body := changesBody{Now: now, Points: []Point{}, Removed: []string{}}
+	if since < l.horizon {
+		body.Reset = true
+		for _, p := range h.points {
+			body.Points = append(body.Points, p)
+		}
+		return body
+	}
+	for key, at := range l.modified {
+		if at > since {
+			if p, ok := h.points[key]; ok {
+				body.Points = append(body.Points, p)
+			}
+		}
+	}
+	for _, t := range l.tombstones {
+		if t.at > since {
+			body.Removed = append(body.Removed, t.key)
+		}
+	}
+	return body
+}
+
+// changesHandler serves GET /points/changed?since=<unix ms>. Pass the
+// returned now as since on the next poll.
+func (m *roomManager) changesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+		return
+	}
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "since must be a unix time in milliseconds")
+		return
+	}
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, _, ok := m.authorize(w, r, name, roleViewer); !ok {
+		return
+	}
+	h := m.acquire(name)
+	defer m.release(h)
+	writeJSONResponse(w, m.wire, http.StatusOK, h.changedSince(since))
+}
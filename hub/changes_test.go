@@ -0,0 +1,56 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChangedSinceListsLaterChanges(t *testing.T) {
+	s := newTestServer(t)
+	var key string
+	s.Room("r", func(h *Hub) {
+		h.Add(Point{X: 1}, "test")
+		key = h.key(Point{X: 1})
+	})
+	time.Sleep(2 * time.Millisecond)
+	since := time.Now().UnixMilli()
+	time.Sleep(2 * time.Millisecond)
+	s.Room("r", func(h *Hub) {
+		h.Add(Point{X: 2}, "test")
+		h.Remove(Point{X: 1}, "test")
+	})
+
+	rec := get(s, fmt.Sprintf("/points/changed?room=r&since=%d", since))
+	var body changesBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	if body.Reset || len(body.Points) != 1 || body.Points[0].X != 2 || len(body.Removed) != 1 || body.Removed[0] != key {
+		t.Errorf("changes since %d = %+v", since, body)
+	}
+	if body.Now < since {
+		t.Errorf("now %d is before since %d", body.Now, since)
+	}
+	if rec := get(s, "/points/changed?room=r&since=soon"); rec.Code != 400 {
+		t.Errorf("bad since: %d", rec.Code)
+	}
+}
+
+func TestChangedSinceResetsPastDroppedTombstones(t *testing.T) {
+	h := newHub("test")
+	h.changes = newChangeLog(time.Hour, 1)
+	c, _ := testClient(h)
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{{X: 1}, {X: 2}, {X: 3}}})
+	time.Sleep(2 * time.Millisecond)
+	since := time.Now().UnixMilli()
+	time.Sleep(2 * time.Millisecond)
+	send(t, h, c, Message{Type: "remove", Point: &Point{X: 1}})
+	time.Sleep(2 * time.Millisecond)
+	send(t, h, c, Message{Type: "remove", Point: &Point{X: 2}})
+
+	if body := h.changedSince(since); !body.Reset || len(body.Points) != 1 {
+		t.Errorf("poll older than the kept tombstones got %+v, want a full reset", body)
+	}
+}
@@ -0,0 +1,111 @@
+package hub
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// chatEntry is one chat message. Sender is the connection's peer ID and
+// Name its nickname when it set one.
+type chatEntry struct {
+	ID     string `json:"id"`
+	Sender string `json:"sender"`
+	Name   string `json:"name,omitempty"`
+	Text   string `json:"text"`
+	At     int64  `json:"at"`
+}
+
+// chatLog keeps the last limit chat messages of a room for clients that join
+// later. Messages are never persisted.
+type chatLog struct {
+	limit     int
+	maxLength int
+	rate      floodPolicy
+
+	mu      sync.Mutex
+	next    uint64
+	entries []chatEntry
+}
+
+func newChatLog(limit, maxLength int, rate floodPolicy) *chatLog {
+	return &chatLog{limit: limit, maxLength: maxLength, rate: rate}
+}
+
+func (l *chatLog) add(e chatEntry) chatEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next++
+	e.ID = fmt.Sprintf("m%d", l.next)
+	if l.limit > 0 {
+		l.entries = append(l.entries, e)
+		if len(l.entries) > l.limit {
+			l.entries = append([]chatEntry(nil), l.entries[len(l.entries)-l.limit:]...)
+		}
+	}
+	return e
+}
+
+func (l *chatLog) recent() []chatEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == 0 {
+		return nil
+	}
+	return append([]chatEntry(nil), l.entries...)
+}
+
+func (l *chatLog) validate(text string) *validationError {
+	if strings.TrimSpace(text) == "" {
+		return invalid(errInvalidChat, "chat text must not be empty")
+	}
+	if len(text) > l.maxLength || !utf8.ValidString(text) {
+		return invalid(errInvalidChat, "chat text must be valid UTF-8 of at most %d bytes", l.maxLength)
+	}
+	return nil
+}
+
+// chat broadcasts text from c, named after its presence nickname unless it
+// sends one of its own.
+func (h *Hub) chat(c *client, msg Message) error {
+	if h.chats == nil {
+		return c.replyError(invalid(errInvalidMessage, "chat is disabled"))
+	}
+	if err := h.chats.validate(msg.Text); err != nil {
+		return c.replyError(err)
+	}
+	name := ""
+	if prof := c.profile.Load(); prof != nil {
+		name = prof.Nickname
+	}
+	if msg.Nickname != nil {
+		if len(*msg.Nickname) > maxNicknameLength || !utf8.ValidString(*msg.Nickname) {
+			return c.replyError(invalid(errInvalidNickname, "nickname must be valid UTF-8 of at most %d bytes", maxNicknameLength))
+		}
+		name = *msg.Nickname
+	}
+	if c.chatBucket != nil {
+		if ok, _ := c.chatBucket.take(); !ok {
+			return c.replyError(invalid(errRateLimited, "more than %g chat messages per second", h.chats.rate.rate))
+		}
+	}
+	e := h.chats.add(chatEntry{Sender: c.id, Name: name, Text: msg.Text, At: time.Now().UnixMilli()})
+	h.broadcast(Message{Type: "chat", Chat: &e})
+	return nil
+}
+
+func (h *Hub) chatHistory() []chatEntry {
+	if h.chats == nil {
+		return nil
+	}
+	return h.chats.recent()
+}
+
+func (h *Hub) chatMaxLength() int {
+	if h.chats == nil {
+		return 0
+	}
+	return h.chats.maxLength
+}
@@ -0,0 +1,92 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+type pendingMove struct {
+	key      string
+	from, to Point
+	seq      uint64
+}
+
+// moveCoalescer collapses a run of moves of the same point within a window
+// into a single broadcast from its first to its latest position. The timer
+// started by the first move of a run always fires, so the final position is
+// delivered even when the drag stops.
+type moveCoalescer struct {
+	window time.Duration
+	send   func(Message)
+	// sequence, when set, wraps moves sent from the timer, which have left
+	// the sequenced call that applied them.
+	sequence func(func())
+	// current, when set, returns the room's sequence number, which is that
+	// of the move being added. Moves broadcast later keep it rather than
+	// being stamped with whatever is current by then.
+	current func() uint64
+	mu      sync.Mutex
+	pending map[string]*pendingMove
+}
+
+func newMoveCoalescer(window time.Duration, send func(Message)) *moveCoalescer {
+	return &moveCoalescer{window: window, send: send, pending: make(map[string]*pendingMove)}
+}
+
+// add records a move from the point keyed fromKey to the one keyed toKey.
+func (mc *moveCoalescer) add(fromKey, toKey string, from, to Point) {
+	if mc.window <= 0 {
+		mc.sendMove(from, to, 0)
+		return
+	}
+	var seq uint64
+	if mc.current != nil {
+		seq = mc.current()
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if pm, ok := mc.pending[fromKey]; ok {
+		delete(mc.pending, fromKey)
+		pm.key, pm.to, pm.seq = toKey, to, seq
+		mc.pending[toKey] = pm
+		return
+	}
+	pm := &pendingMove{key: toKey, from: from, to: to, seq: seq}
+	mc.pending[toKey] = pm
+	time.AfterFunc(mc.window, func() { mc.fire(pm) })
+}
+
+func (mc *moveCoalescer) fire(pm *pendingMove) {
+	mc.mu.Lock()
+	if mc.pending[pm.key] != pm {
+		mc.mu.Unlock()
+		return
+	}
+	delete(mc.pending, pm.key)
+	mc.mu.Unlock()
+	if mc.sequence != nil {
+		mc.sequence(func() { mc.sendMove(pm.from, pm.to, pm.seq) })
+		return
+	}
+	mc.sendMove(pm.from, pm.to, pm.seq)
+}
+
+// flush immediately broadcasts any pending move ending at key, so that a
+// following remove or update is not delivered ahead of it.
+func (mc *moveCoalescer) flush(key string) {
+	mc.mu.Lock()
+	pm, ok := mc.pending[key]
+	if ok {
+		delete(mc.pending, key)
+	}
+	mc.mu.Unlock()
+	if ok {
+		mc.sendMove(pm.from, pm.to, pm.seq)
+	}
+}
+
+// sendMove broadcasts a move stamped with seq, or with the current sequence
+// number when seq is 0.
+func (mc *moveCoalescer) sendMove(from, to Point, seq uint64) {
+	mc.send(Message{Type: "move", Seq: seq, From: &from, To: &to})
+}
@@ -0,0 +1,46 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMoveCoalescerCollapsesARun(t *testing.T) {
+	sent := make(chan Message, 8)
+	mc := newMoveCoalescer(30*time.Millisecond, func(m Message) { sent <- m })
+	a, b, c, d := Point{X: 1}, Point{X: 2}, Point{X: 3}, Point{X: 4}
+
+	mc.add("a", "b", a, b)
+	mc.add("b", "c", b, c)
+	select {
+	case m := <-sent:
+		if m.Type != "move" || m.From.X != 1 || m.To.X != 3 {
+			t.Errorf("coalesced move = %+v, want from x=1 to x=3", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("coalesced move never sent")
+	}
+	select {
+	case m := <-sent:
+		t.Errorf("run sent a second move %+v", m)
+	case <-time.After(60 * time.Millisecond):
+	}
+
+	// A flush sends the pending move at once, and its timer then sends
+	// nothing.
+	mc.add("c", "d", c, d)
+	mc.flush("d")
+	select {
+	case m := <-sent:
+		if m.From.X != 3 || m.To.X != 4 {
+			t.Errorf("flushed move = %+v", m)
+		}
+	default:
+		t.Fatal("flush sent nothing")
+	}
+	select {
+	case m := <-sent:
+		t.Errorf("flushed move sent again: %+v", m)
+	case <-time.After(60 * time.Millisecond):
+	}
+}
@@ -0,0 +1,31 @@
+package hub
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// compactionPass reclaims one category of accumulated state and reports how
+// many items it removed.
+type compactionPass struct {
+	name string
+	run  func(now time.Time) int
+}
+
+func runCompaction(interval time.Duration, passes []compactionPass) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var summary []string
+		for _, p := range passes {
+			if n := p.run(now); n > 0 {
+				summary = append(summary, fmt.Sprintf("%s=%d", p.name, n))
+			}
+		}
+		if len(summary) > 0 {
+			slog.Info("compaction reclaimed", "passes", strings.Join(summary, " "))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"flag"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+type debugConn struct {
+	ID          string `json:"id"`
+	ConnectedAt int64  `json:"connectedAt"`
+	IdleMs      int64  `json:"idleMs"`
+	Received    uint64 `json:"received"`
+	SentBytes   uint64 `json:"sentBytes"`
+	Compressed  bool   `json:"compressed,omitempty"`
+	Degraded    bool   `json:"degraded,omitempty"`
+	Dropped     uint64 `json:"dropped,omitempty"`
+}
+
+type debugRoom struct {
+	Room      string      `json:"room"`
+	Seq       uint64      `json:"seq"`
+	Points    int         `json:"points"`
+	Selection int         `json:"selection"`
+	Conns     []debugConn `json:"conns"`
+}
+
+type debugState struct {
+	At         int64             `json:"at"`
+	Goroutines int               `json:"goroutines"`
+	Rooms      []debugRoom       `json:"rooms"`
+	Config     map[string]string `json:"config"`
+}
+
+func (h *Hub) debugState(now time.Time) debugRoom {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d := debugRoom{
+		Room:      h.room,
+		Seq:       h.seq,
+		Points:    len(h.points),
+		Selection: len(h.selection),
+		Conns:     make([]debugConn, 0, len(h.conns)),
+	}
+	for c := range h.conns {
+		d.Conns = append(d.Conns, debugConn{
+			ID:          c.id,
+			ConnectedAt: c.connectedAt.UnixMilli(),
+			IdleMs:      now.UnixMilli() - c.lastRead.Load(),
+			Received:    c.received.Load(),
+			SentBytes:   c.payload.Load(),
+			Compressed:  c.compressed,
+			Degraded:    c.degraded.Load(),
+			Dropped:     c.droppedCount(),
+		})
+	}
+	sort.Slice(d.Conns, func(i, j int) bool { return d.Conns[i].ID < d.Conns[j].ID })
+	return d
+}
+
+// flagConfig returns the value of every command-line flag, with the values
+// of flags whose names suggest a credential replaced.
+func flagConfig() map[string]string {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if strings.Contains(f.Name, "token") || strings.Contains(f.Name, "secret") {
+			if v != "" {
+				v = "[redacted]"
+			}
+		}
+		config[f.Name] = v
+	})
+	return config
+}
+
+// requireToken rejects requests that do not carry token as a bearer token.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeErrorResponse(w, http.StatusUnauthorized, errUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (m *roomManager) debugHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+		return
+	}
+	now := time.Now()
+	state := debugState{
+		At:         now.UnixMilli(),
+		Goroutines: runtime.NumGoroutine(),
+		Rooms:      []debugRoom{},
+		Config:     flagConfig(),
+	}
+	for _, h := range m.hubs() {
+		state.Rooms = append(state.Rooms, h.debugState(now))
+	}
+	sort.Slice(state.Rooms, func(i, j int) bool { return state.Rooms[i].Room < state.Rooms[j].Room })
+	writeJSONResponse(w, m.wire, http.StatusOK, state)
+}
@@ -0,0 +1,39 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugStateRequiresTheAdminToken(t *testing.T) {
+	s := newTestServer(t, WithAdminToken("s3cret", true))
+	s.Room("r", func(h *Hub) { h.Add(Point{X: 1}, "test") })
+
+	if rec := get(s, "/debug/state"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: %d", rec.Code)
+	}
+	req := httptest.NewRequest("GET", "/debug/state", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	var state debugState
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("%d %s: %v", rec.Code, rec.Body, err)
+	}
+	if state.Goroutines == 0 || len(state.Rooms) != 1 || state.Rooms[0].Room != "r" || state.Rooms[0].Points != 1 {
+		t.Errorf("state = %+v", state)
+	}
+}
+
+func TestDebugStateIsOffWithoutTheFlag(t *testing.T) {
+	s := newTestServer(t, WithAdminToken("s3cret", false))
+	req := httptest.NewRequest("GET", "/debug/state", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("debug endpoint without -debug: %d", rec.Code)
+	}
+}
@@ -0,0 +1,293 @@
+package hub
+
+import "math"
+
+// dispatchIndex groups a room's connections by the broadcast types they
+// subscribed to and by the region they watch, so a broadcast only visits the
+// connections that can want it. The per-connection filter still runs on
+// every target; the index only narrows who is asked. Guarded by the hub's mu.
+type dispatchIndex struct {
+	// anywhere holds the connections without a region. regioned holds the
+	// others by type, for broadcasts not about particular points, and grid
+	// by where they watch, for broadcasts that are.
+	anywhere subscriberSet
+	regioned subscriberSet
+	grid     regionGrid
+}
+
+func newDispatchIndex() *dispatchIndex {
+	return &dispatchIndex{anywhere: newSubscriberSet(), regioned: newSubscriberSet(), grid: newRegionGrid()}
+}
+
+func (d *dispatchIndex) add(c *client, types []string, r *region) {
+	if r == nil {
+		d.anywhere.add(c, types)
+		return
+	}
+	d.regioned.add(c, types)
+	d.grid.add(c, r)
+}
+
+func (d *dispatchIndex) remove(c *client, types []string, r *region) {
+	if r == nil {
+		d.anywhere.remove(c, types)
+		return
+	}
+	d.regioned.remove(c, types)
+	d.grid.remove(c, r)
+}
+
+// targets lists the connections that may want msg. Must be called with h.mu
+// held.
+func (d *dispatchIndex) targets(msg Message) []*client {
+	out := d.anywhere.targets(msg)
+	var ps []Point
+	if msg.Type != "delta" {
+		located := false
+		if ps, located = locate(msg); !located {
+			return append(out, d.regioned.targets(msg)...)
+		}
+	} else {
+		for _, m := range msg.Messages {
+			sub, located := locate(m)
+			if !located {
+				return append(out, d.regioned.targets(msg)...)
+			}
+			ps = append(ps, sub...)
+		}
+	}
+	return d.grid.near(ps, out)
+}
+
+// subscriberSet groups connections by the broadcast types they subscribed
+// to.
+type subscriberSet struct {
+	all    map[*client]struct{}
+	byType map[string]map[*client]struct{}
+}
+
+func newSubscriberSet() subscriberSet {
+	return subscriberSet{all: make(map[*client]struct{}), byType: make(map[string]map[*client]struct{})}
+}
+
+func (s subscriberSet) add(c *client, types []string) {
+	if len(types) == 0 {
+		s.all[c] = struct{}{}
+		return
+	}
+	for _, t := range types {
+		set, ok := s.byType[t]
+		if !ok {
+			set = make(map[*client]struct{})
+			s.byType[t] = set
+		}
+		set[c] = struct{}{}
+	}
+}
+
+func (s subscriberSet) remove(c *client, types []string) {
+	if len(types) == 0 {
+		delete(s.all, c)
+		return
+	}
+	for _, t := range types {
+		if set, ok := s.byType[t]; ok {
+			delete(set, c)
+			if len(set) == 0 {
+				delete(s.byType, t)
+			}
+		}
+	}
+}
+
+func (s subscriberSet) targets(msg Message) []*client {
+	out := make([]*client, 0, len(s.all))
+	for c := range s.all {
+		out = append(out, c)
+	}
+	if msg.Type != "delta" {
+		for c := range s.byType[msg.Type] {
+			out = append(out, c)
+		}
+		return out
+	}
+	seen := make(map[*client]struct{})
+	for _, m := range msg.Messages {
+		for c := range s.byType[m.Type] {
+			if _, dup := seen[c]; !dup {
+				seen[c] = struct{}{}
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// Levels of the region grid: cells at level l are 2^l wide. Regions are
+// filed at the level whose cells are at least as wide as their longest side,
+// but no finer than minGridLevel.
+const (
+	minGridLevel = -16
+	maxGridLevel = 60
+)
+
+type gridCell struct {
+	level   int
+	x, y, z int64
+}
+
+// regionGrid files connections under the cells their region overlaps, so
+// the connections watching a point are found without checking every region.
+// A region lands in at most two cells per axis at its level; a lookup checks
+// the point's cell at every level in use. Regions too large or too far out
+// for any level are kept in wide and match everywhere.
+type regionGrid struct {
+	cells  map[gridCell]map[*client]struct{}
+	levels map[int]int
+	wide   map[*client]struct{}
+}
+
+func newRegionGrid() regionGrid {
+	return regionGrid{cells: make(map[gridCell]map[*client]struct{}), levels: make(map[int]int), wide: make(map[*client]struct{})}
+}
+
+// cellRange returns the level of r and the cells it overlaps there, or false
+// when r fits no level.
+func cellRange(r *region) (level int, lo, hi [3]int64, ok bool) {
+	extent := 0.0
+	for i := range r.min {
+		extent = math.Max(extent, r.max[i]-r.min[i])
+	}
+	if !(extent <= math.Ldexp(1, maxGridLevel)) {
+		return 0, lo, hi, false
+	}
+	level = minGridLevel
+	if extent > 0 {
+		level = int(math.Ceil(math.Log2(extent)))
+	}
+	if level < minGridLevel {
+		level = minGridLevel
+	}
+	for i := range r.min {
+		var okLo, okHi bool
+		lo[i], okLo = cellIndex(r.min[i], level)
+		hi[i], okHi = cellIndex(r.max[i], level)
+		if !okLo || !okHi {
+			return 0, lo, hi, false
+		}
+	}
+	return level, lo, hi, true
+}
+
+// cellIndex returns the cell of coordinate v at level, or false when it is
+// too far out to number.
+func cellIndex(v float64, level int) (int64, bool) {
+	i := math.Floor(math.Ldexp(v, -level))
+	if !(math.Abs(i) < 1<<62) {
+		return 0, false
+	}
+	return int64(i), true
+}
+
+func (g regionGrid) add(c *client, r *region) {
+	level, lo, hi, ok := cellRange(r)
+	if !ok {
+		g.wide[c] = struct{}{}
+		return
+	}
+	g.levels[level]++
+	g.each(level, lo, hi, func(cell gridCell) {
+		set, ok := g.cells[cell]
+		if !ok {
+			set = make(map[*client]struct{})
+			g.cells[cell] = set
+		}
+		set[c] = struct{}{}
+	})
+}
+
+func (g regionGrid) remove(c *client, r *region) {
+	level, lo, hi, ok := cellRange(r)
+	if !ok {
+		delete(g.wide, c)
+		return
+	}
+	if g.levels[level]--; g.levels[level] == 0 {
+		delete(g.levels, level)
+	}
+	g.each(level, lo, hi, func(cell gridCell) {
+		if set, ok := g.cells[cell]; ok {
+			delete(set, c)
+			if len(set) == 0 {
+				delete(g.cells, cell)
+			}
+		}
+	})
+}
+
+func (g regionGrid) each(level int, lo, hi [3]int64, fn func(gridCell)) {
+	for x := lo[0]; x <= hi[0]; x++ {
+		for y := lo[1]; y <= hi[1]; y++ {
+			for z := lo[2]; z <= hi[2]; z++ {
+				fn(gridCell{level: level, x: x, y: y, z: z})
+			}
+		}
+	}
+}
+
+// near appends to out the connections whose region may contain one of ps.
+func (g regionGrid) near(ps []Point, out []*client) []*client {
+	for c := range g.wide {
+		out = append(out, c)
+	}
+	if len(ps) == 0 || len(g.levels) == 0 {
+		return out
+	}
+	seen := make(map[*client]struct{})
+	for _, p := range ps {
+		for level := range g.levels {
+			var cell gridCell
+			var okX, okY, okZ bool
+			cell.level = level
+			cell.x, okX = cellIndex(p.X, level)
+			cell.y, okY = cellIndex(p.Y, level)
+			cell.z, okZ = cellIndex(p.Z, level)
+			if !okX || !okY || !okZ {
+				continue
+			}
+			for c := range g.cells[cell] {
+				if _, dup := seen[c]; !dup {
+					seen[c] = struct{}{}
+					out = append(out, c)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// subscribe replaces the broadcast types c receives; none means every type.
+func (h *Hub) subscribe(c *client, types []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[c]; !ok {
+		return
+	}
+	r := c.subscribedRegion()
+	h.dispatch.remove(c, c.subscribedTypes(), r)
+	c.subscribe(types)
+	h.dispatch.add(c, c.subscribedTypes(), r)
+}
+
+// setRegion changes c's region and refiles it in the dispatch index.
+func (h *Hub) setRegion(c *client, r *region) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[c]; !ok {
+		return
+	}
+	types := c.subscribedTypes()
+	h.dispatch.remove(c, types, c.subscribedRegion())
+	c.setRegion(r)
+	h.dispatch.add(c, types, r)
+}
@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// discardConn accepts every write.
+type discardConn struct{}
+
+func (discardConn) WriteMessage(int, []byte) error                        { return nil }
+func (discardConn) WriteControl(int, []byte, time.Time) error             { return nil }
+func (discardConn) SetWriteDeadline(time.Time) error                      { return nil }
+func (discardConn) EnableWriteCompression(bool)                           {}
+func (discardConn) WritePreparedMessage(*websocket.PreparedMessage) error { return nil }
+func (discardConn) Close() error                                          { return nil }
+
+func box(lo, hi float64) (*[3]float64, *[3]float64) {
+	return &[3]float64{lo, lo, lo}, &[3]float64{hi, hi, hi}
+}
+
+func TestBroadcastReachesOnlyRegionsContainingPoint(t *testing.T) {
+	h := newHub("test")
+	editor, _ := testClient(h)
+	nearby, nearConn := testClient(h)
+	far, farConn := testClient(h)
+	lo, hi := box(0, 1)
+	send(t, h, nearby, Message{Type: "subscribe", Min: lo, Max: hi})
+	lo, hi = box(100, 101)
+	send(t, h, far, Message{Type: "subscribe", Min: lo, Max: hi})
+
+	send(t, h, editor, Message{Type: "add", Point: &Point{X: 0.5, Y: 0.5, Z: 0.5}})
+	if got := nearConn.ofType(t, "add"); len(got) != 1 {
+		t.Errorf("region containing the point got %d adds", len(got))
+	}
+	if got := farConn.ofType(t, "add"); len(got) != 0 {
+		t.Errorf("region far away got %+v", got)
+	}
+
+	// A move leaving one region for another reaches both.
+	send(t, h, editor, Message{Type: "move", From: &Point{X: 0.5, Y: 0.5, Z: 0.5}, To: &Point{X: 100.5, Y: 100.5, Z: 100.5}})
+	if len(nearConn.ofType(t, "move")) != 1 || len(farConn.ofType(t, "move")) != 1 {
+		t.Error("move between regions did not reach both")
+	}
+
+	// Messages not about a point reach every region.
+	send(t, h, editor, Message{Type: "clear"})
+	if len(nearConn.ofType(t, "clear")) != 1 || len(farConn.ofType(t, "clear")) != 1 {
+		t.Error("clear did not reach every region")
+	}
+
+	// Leaving the region subscribes to everything again.
+	send(t, h, far, Message{Type: "subscribe"})
+	send(t, h, editor, Message{Type: "add", Point: &Point{X: 0.5, Y: 0.5, Z: 0.5}})
+	if got := farConn.ofType(t, "add"); len(got) != 1 {
+		t.Errorf("after a bare subscribe, got %d adds", len(got))
+	}
+}
+
+// Every connection whose own filter keeps a message must be among its
+// targets, whatever the sizes and places of the regions.
+func TestDispatchTargetsCoverFilter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	h := newHub("test")
+	var conns []*client
+	for i := 0; i < 200; i++ {
+		c := h.addConn(discardConn{}, false, formatJSON)
+		var r *region
+		switch i % 4 {
+		case 0:
+			// Everywhere.
+		case 1:
+			r = &region{min: [3]float64{math.Inf(-1), -1, -1}, max: [3]float64{math.Inf(1), 1, 1}}
+		default:
+			size := math.Pow(10, float64(rng.Intn(8)-4))
+			var lo, hi [3]float64
+			for j := range lo {
+				lo[j] = rng.Float64()*20 - 10
+				hi[j] = lo[j] + size*rng.Float64()
+			}
+			r = &region{min: lo, max: hi}
+		}
+		h.setRegion(c, r)
+		if i%3 == 0 {
+			h.subscribe(c, []string{"add", "move"})
+		}
+		conns = append(conns, c)
+	}
+	point := func() *Point {
+		return &Point{X: rng.Float64()*20 - 10, Y: rng.Float64()*20 - 10, Z: rng.Float64()*20 - 10}
+	}
+	for i := 0; i < 500; i++ {
+		var msg Message
+		switch i % 4 {
+		case 0:
+			msg = Message{Type: "add", Point: point()}
+		case 1:
+			msg = Message{Type: "move", From: point(), To: point()}
+		case 2:
+			msg = Message{Type: "removeBatch", Points: []Point{*point(), *point()}}
+		default:
+			msg = Message{Type: "delta", Messages: []Message{{Type: "add", Point: point()}, {Type: "remove", Point: point()}}}
+		}
+		h.mu.Lock()
+		targets := make(map[*client]bool)
+		for _, c := range h.dispatch.targets(msg) {
+			targets[c] = true
+		}
+		h.mu.Unlock()
+		for _, c := range conns {
+			if _, ok, _ := c.filter(msg); ok && !targets[c] {
+				t.Fatalf("%s not dispatched to a connection watching %+v", msg.Type, c.subscribedRegion())
+			}
+		}
+	}
+}
+
+// BenchmarkDispatchFewMatching broadcasts an add to a room of 5000
+// connections watching small regions, only a few of which contain the point.
+func BenchmarkDispatchFewMatching(b *testing.B) {
+	h := newHub("bench")
+	for i := 0; i < 5000; i++ {
+		c := h.addConn(discardConn{}, false, formatJSON)
+		x := float64(i % 100)
+		y := float64(i / 100)
+		h.setRegion(c, &region{min: [3]float64{x, y, 0}, max: [3]float64{x + 0.5, y + 0.5, 1}})
+	}
+	msg := Message{Type: "add", Seq: 1, Point: &Point{X: 10.25, Y: 20.25, Z: 0.5}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.deliver(msg)
+	}
+}
+
+func TestSubscribedTypesOnly(t *testing.T) {
+	h := newHub("test")
+	editor, _ := testClient(h)
+	watcher, fc := testClient(h)
+	send(t, h, watcher, Message{Type: "subscribe", Types: []string{"remove"}})
+
+	p := Point{X: 1}
+	send(t, h, editor, Message{Type: "add", Point: &p})
+	send(t, h, editor, Message{Type: "remove", Point: &p})
+	got := fc.messages(t)
+	if len(got) != 1 || got[0].Type != "remove" {
+		t.Errorf("subscriber to removes got %+v", got)
+	}
+
+	// An empty list subscribes to everything again.
+	send(t, h, watcher, Message{Type: "subscribe", Types: []string{}})
+	send(t, h, editor, Message{Type: "add", Point: &p})
+	if adds := fc.ofType(t, "add"); len(adds) != 1 {
+		t.Errorf("after resubscribing got %d adds", len(adds))
+	}
+}
@@ -0,0 +1,62 @@
+package hub
+
+import "time"
+
+// expiryActor is the actor of removals made by the expiry sweep.
+const expiryActor = "expiry"
+
+// trackExpiry notes when p expires so the sweep knows when to look. Must be
+// called with h.mu held for every point stored with an expiry.
+func (h *Hub) trackExpiry(p Point) {
+	if p.ExpiresAt > 0 && (h.nextExpiry == 0 || p.ExpiresAt < h.nextExpiry) {
+		h.nextExpiry = p.ExpiresAt
+	}
+}
+
+// removeExpired deletes the points whose expiry has passed at now, locked or
+// not, and returns them. Pinned points outlive their expiry until unpinned.
+func (h *Hub) removeExpired(now time.Time) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ms := now.UnixMilli()
+	if h.nextExpiry == 0 || ms < h.nextExpiry {
+		return nil
+	}
+	h.nextExpiry = 0
+	var removed []Point
+	for key, p := range h.points {
+		if p.ExpiresAt == 0 || p.Pinned {
+			continue
+		}
+		if p.ExpiresAt > ms {
+			h.trackExpiry(p)
+			continue
+		}
+		delete(h.points, key)
+		h.emit(Mutation{Type: "remove", Actor: expiryActor, Point: p})
+		removed = append(removed, p)
+	}
+	return removed
+}
+
+// sweepExpired removes and broadcasts the points expired at now.
+func (h *Hub) sweepExpired(now time.Time) {
+	h.sequenced(func() {
+		removed := h.removeExpired(now)
+		h.afterRemove(removed)
+		if len(removed) > 0 {
+			h.broadcast(Message{Type: "removeBatch", Points: removed})
+		}
+	})
+}
+
+// runExpiry sweeps every loaded room for expired points each interval.
+func (m *roomManager) runExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, h := range m.hubs() {
+			h.sweepExpired(now)
+		}
+	}
+}
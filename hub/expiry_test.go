@@ -0,0 +1,31 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPinnedPointsSurviveClearAndExpiry(t *testing.T) {
+	h := newHub("test")
+	c, _ := testClient(h)
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1, Pinned: true, TTLMs: 1}})
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 2, TTLMs: 1}})
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 3}})
+
+	removed := h.removeExpired(time.Now().Add(time.Second))
+	if len(removed) != 1 || removed[0].X != 2 {
+		t.Errorf("expired %+v, want only the unpinned point with a ttl", removed)
+	}
+	send(t, h, c, Message{Type: "clear"})
+	ps := h.Points()
+	if len(ps) != 1 || ps[0].X != 1 {
+		t.Fatalf("after clear the room holds %+v, want the pinned point", ps)
+	}
+
+	// Unpinned, the overdue point expires.
+	pinned := false
+	send(t, h, c, Message{Type: "update", Point: &Point{X: 1}, Pinned: &pinned})
+	if removed := h.removeExpired(time.Now().Add(time.Second)); len(removed) != 1 {
+		t.Errorf("unpinned overdue point not expired: %+v", h.Points())
+	}
+}
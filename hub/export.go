@@ -0,0 +1,119 @@
+package hub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// writePLY writes ps as an ASCII PLY file. Color properties are included
+// when any point has a color; points without one are written white.
+func writePLY(w io.Writer, _ wireEncoding, room string, ps []Point) error {
+	colored := false
+	for _, p := range ps {
+		if p.Color != "" {
+			colored = true
+			break
+		}
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ply\nformat ascii 1.0\ncomment universe room %s\n", room)
+	fmt.Fprintf(bw, "element vertex %d\n", len(ps))
+	fmt.Fprint(bw, "property double x\nproperty double y\nproperty double z\n")
+	if colored {
+		fmt.Fprint(bw, "property uchar red\nproperty uchar green\nproperty uchar blue\n")
+	}
+	fmt.Fprint(bw, "end_header\n")
+	for _, p := range ps {
+		if colored {
+			r, g, b := rgb(p.Color)
+			fmt.Fprintf(bw, "%g %g %g %d %d %d\n", p.X, p.Y, p.Z, r, g, b)
+			continue
+		}
+		fmt.Fprintf(bw, "%g %g %g\n", p.X, p.Y, p.Z)
+	}
+	return bw.Flush()
+}
+
+// rgb decodes a validated #rgb or #rrggbb color, defaulting to white.
+func rgb(c string) (uint8, uint8, uint8) {
+	if len(c) == 4 {
+		c = string([]byte{'#', c[1], c[1], c[2], c[2], c[3], c[3]})
+	}
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(c, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return 255, 255, 255
+	}
+	return r, g, b
+}
+
+// snapshotHandler serves a room's points with the sequence number they are
+// current as of, for clients sent an initRef.
+func (m *roomManager) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, _, ok := m.authorize(w, r, name, roleViewer); !ok {
+		return
+	}
+	h := m.acquire(name)
+	defer m.release(h)
+	snap := h.snapshot()
+	snap.NextPointID = 0
+	writeJSONResponse(w, m.wire, http.StatusOK, snap)
+}
+
+// exportHandler serves GET /export?room=<name>&format=csv|json|ply|xyz as a
+// file download; json is the default.
+func (m *roomManager) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	ext := r.URL.Query().Get("format")
+	if ext == "" {
+		ext = "json"
+	}
+	format, ok := pointFormats[ext]
+	if !ok {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "format must be csv, json, ply or xyz")
+		return
+	}
+	if _, _, ok := m.authorize(w, r, name, roleViewer); !ok {
+		return
+	}
+	h := m.acquire(name)
+	defer m.release(h)
+	ps := h.snapshotPoints()
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+ext))
+	if err := format.write(w, m.wire, name, ps); err != nil {
+		slog.Warn("export failed", "format", ext, "err", err)
+	}
+}
+
+func (m *roomManager) plyHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, _, ok := m.authorize(w, r, name, roleViewer); !ok {
+		return
+	}
+	h := m.acquire(name)
+	defer m.release(h)
+	ps := h.snapshotPoints()
+
+	w.Header().Set("Content-Type", "application/x-ply")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".ply"))
+	if err := writePLY(w, m.wire, name, ps); err != nil {
+		slog.Warn("ply write failed", "err", err)
+	}
+}
@@ -0,0 +1,44 @@
+package hub
+
+import "time"
+
+// floodPolicy limits how fast one connection may send messages: a token
+// bucket refilled at rate per second holding at most burst tokens. Messages
+// arriving with the bucket empty are rejected, and a client rejected
+// disconnectAfter times in a row is dropped.
+type floodPolicy struct {
+	rate            float64
+	burst           int
+	disconnectAfter int
+}
+
+// tokenBucket is one connection's share of a floodPolicy. Only the
+// connection's read loop uses it, so it needs no lock.
+type tokenBucket struct {
+	policy   floodPolicy
+	tokens   float64
+	last     time.Time
+	rejected int
+}
+
+func newTokenBucket(p floodPolicy) *tokenBucket {
+	return &tokenBucket{policy: p, tokens: float64(p.burst), last: time.Now()}
+}
+
+// take spends a token if one is available. When none is, it reports whether
+// the client has now been rejected often enough to be disconnected.
+func (b *tokenBucket) take() (ok, disconnect bool) {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.policy.rate
+	if full := float64(b.policy.burst); b.tokens > full {
+		b.tokens = full
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.rejected = 0
+		return true, false
+	}
+	b.rejected++
+	return false, b.policy.disconnectAfter > 0 && b.rejected >= b.policy.disconnectAfter
+}
@@ -0,0 +1,188 @@
+package hub
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// generateChunk is how many generated points are stored and broadcast at a
+// time, so that no single frame or hold of the lock grows too large.
+const generateChunk = 1000
+
+// generateSpec asks for Count points in Shape around Center. Radius scales
+// the shape: the shell of a sphere, half the side of a cube, the standard
+// deviation of a Gaussian cluster or the extent of a spiral galaxy's disk.
+// The same seed always gives the same points; without one the server picks
+// a seed and reports it.
+type generateSpec struct {
+	Shape  string     `json:"shape"`
+	Count  int        `json:"count"`
+	Seed   int64      `json:"seed,omitempty"`
+	Center [3]float64 `json:"center"`
+	Radius float64    `json:"radius,omitempty"`
+	Arms   int        `json:"arms,omitempty"`
+	Color  string     `json:"color,omitempty"`
+	Path   string     `json:"path,omitempty"`
+}
+
+// generateResult reports a generate command: how many points were asked
+// for, how many were added, and the seed that reproduces them. Points that
+// fall on existing ones, or too close to them, are skipped.
+type generateResult struct {
+	Seed      int64 `json:"seed"`
+	Requested int   `json:"requested"`
+	Added     int   `json:"added"`
+	Skipped   int   `json:"skipped"`
+}
+
+var generators = map[string]func(rng *rand.Rand, s generateSpec, i int) [3]float64{
+	"sphere": func(rng *rand.Rand, s generateSpec, _ int) [3]float64 {
+		for {
+			v := [3]float64{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}
+			if n := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2]); n > 1e-9 {
+				return [3]float64{v[0] / n * s.Radius, v[1] / n * s.Radius, v[2] / n * s.Radius}
+			}
+		}
+	},
+	"cube": func(rng *rand.Rand, s generateSpec, _ int) [3]float64 {
+		return [3]float64{(rng.Float64()*2 - 1) * s.Radius, (rng.Float64()*2 - 1) * s.Radius, (rng.Float64()*2 - 1) * s.Radius}
+	},
+	"gaussian": func(rng *rand.Rand, s generateSpec, _ int) [3]float64 {
+		return [3]float64{rng.NormFloat64() * s.Radius, rng.NormFloat64() * s.Radius, rng.NormFloat64() * s.Radius}
+	},
+	// galaxy winds the arms as logarithmic spirals in the XZ plane, denser
+	// towards the core, with scatter that shrinks towards the rim and a thin
+	// disk along Y.
+	"galaxy": func(rng *rand.Rand, s generateSpec, i int) [3]float64 {
+		t := math.Pow(rng.Float64(), 1.5)
+		r := t * s.Radius
+		angle := float64(i%s.Arms)*2*math.Pi/float64(s.Arms) + 3*math.Pi*t
+		scatter := 0.15 * s.Radius * (1 - 0.6*t)
+		return [3]float64{
+			r*math.Cos(angle) + rng.NormFloat64()*scatter,
+			rng.NormFloat64() * 0.04 * s.Radius,
+			r*math.Sin(angle) + rng.NormFloat64()*scatter,
+		}
+	},
+}
+
+// normalize fills in defaults and checks spec against the room's cap on
+// generated points.
+func (s *generateSpec) normalize(maxCount int) *validationError {
+	gen := generators[s.Shape]
+	if gen == nil {
+		return invalid(errBadRequest, "shape must be sphere, cube, gaussian or galaxy")
+	}
+	if s.Count < 1 {
+		return invalid(errBadRequest, "count must be at least 1")
+	}
+	if maxCount > 0 && s.Count > maxCount {
+		return invalid(errBatchTooLarge, "count of %d exceeds the limit of %d", s.Count, maxCount)
+	}
+	if s.Radius == 0 {
+		s.Radius = 1
+	}
+	if s.Radius < 0 || math.IsNaN(s.Radius) || math.IsInf(s.Radius, 0) {
+		return invalid(errBadRequest, "radius must be a positive number")
+	}
+	for _, c := range s.Center {
+		if math.IsNaN(c) || math.IsInf(c, 0) {
+			return invalid(errInvalidCoords, "center must be finite numbers")
+		}
+	}
+	if s.Shape == "galaxy" && s.Arms == 0 {
+		s.Arms = 2
+	}
+	if s.Arms < 0 || s.Arms > 16 {
+		return invalid(errBadRequest, "arms must be between 1 and 16")
+	}
+	if s.Seed == 0 {
+		s.Seed = time.Now().UnixNano()
+	}
+	if err := validateColor(s.Color); err != nil {
+		return err
+	}
+	if s.Path != "" {
+		return validatePath(s.Path)
+	}
+	return nil
+}
+
+// points returns the points spec describes. It must be normalized.
+func (s generateSpec) points() []Point {
+	rng := rand.New(rand.NewSource(s.Seed))
+	gen := generators[s.Shape]
+	ps := make([]Point, s.Count)
+	for i := range ps {
+		v := gen(rng, s, i)
+		ps[i] = Point{X: s.Center[0] + v[0], Y: s.Center[1] + v[1], Z: s.Center[2] + v[2], Weight: 1, Color: s.Color, Path: s.Path}
+	}
+	return ps
+}
+
+// generate adds the points spec describes as actor, broadcasting them one
+// chunk at a time, and returns the ones added. Must be called from within
+// h.sequenced.
+func (h *Hub) generate(spec *generateSpec, actor string) ([]Point, generateResult, *validationError) {
+	if err := spec.normalize(h.maxGenerate); err != nil {
+		return nil, generateResult{}, err
+	}
+	ps := spec.points()
+	var added []Point
+	for start := 0; start < len(ps); start += generateChunk {
+		end := start + generateChunk
+		if end > len(ps) {
+			end = len(ps)
+		}
+		chunk := h.addPoints(ps[start:end], actor)
+		if len(chunk) > 0 {
+			h.broadcast(Message{Type: "addBatch", Points: chunk})
+		}
+		added = append(added, chunk...)
+	}
+	return added, generateResult{Seed: spec.Seed, Requested: spec.Count, Added: len(added), Skipped: spec.Count - len(added)}, nil
+}
+
+// generateHandler runs a generate command (POST) whose body is the spec.
+func (m *roomManager) generateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+		return
+	}
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	identity, _, ok := m.authorize(w, r, name, roleEditor)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	var spec generateSpec
+	if err := m.wire.unmarshal(body, &spec); err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	h := m.acquire(name)
+	defer m.release(h)
+	if h.config.ReadOnly {
+		writeErrorResponse(w, http.StatusForbidden, errReadOnly, "room is read-only")
+		return
+	}
+	var res generateResult
+	var verr *validationError
+	h.sequenced(func() { _, res, verr = h.generate(&spec, restActor(identity, r.RemoteAddr)) })
+	if verr != nil {
+		writeErrorResponse(w, http.StatusBadRequest, verr.Code, verr.Reason)
+		return
+	}
+	writeJSONResponse(w, m.wire, http.StatusCreated, res)
+}
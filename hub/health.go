@@ -0,0 +1,115 @@
+package hub
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type checkResult struct {
+	At         int64 `json:"at"`
+	DurationMs int64 `json:"durationMs"`
+	Conns      int   `json:"conns"`
+	Reaped     int   `json:"reaped"`
+	Points     int   `json:"points"`
+	Rekeyed    int   `json:"rekeyed"`
+}
+
+// runChecks probes every connection with a ping on each tick and reaps the
+// ones that have not answered the previous probe, then verifies that every
+// stored point sits under the key the hub would compute for it.
+func (m *roomManager) runChecks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		total := checkResult{At: now.UnixMilli()}
+		for _, h := range m.hubs() {
+			res := h.check(interval)
+			if res.Reaped > 0 || res.Rekeyed > 0 {
+				slog.Info("consistency check", "room", h.room, "reaped", res.Reaped, "rekeyed", res.Rekeyed)
+			}
+			total.Conns += res.Conns
+			total.Reaped += res.Reaped
+			total.Points += res.Points
+			total.Rekeyed += res.Rekeyed
+		}
+		total.DurationMs = time.Since(now).Milliseconds()
+		m.mu.Lock()
+		m.lastCheck = &total
+		m.mu.Unlock()
+	}
+}
+
+func (h *Hub) check(interval time.Duration) checkResult {
+	start := time.Now()
+	res := checkResult{At: start.UnixMilli()}
+
+	h.mu.Lock()
+	conns := make([]*client, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+
+	deadline := start.Add(interval)
+	for _, c := range conns {
+		h.markStale(c, start)
+		if start.Sub(time.UnixMilli(c.lastPong.Load())) > 2*interval {
+			c.log.Info("reaping unresponsive connection")
+			h.removeConn(c)
+			res.Reaped++
+			continue
+		}
+		if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+			c.log.Warn("ping failed", "err", err)
+			h.removeConn(c)
+			res.Reaped++
+		}
+	}
+
+	h.mu.Lock()
+	for key, p := range h.points {
+		if want := h.key(p); want != key {
+			delete(h.points, key)
+			if _, exists := h.points[want]; !exists {
+				h.points[want] = p
+			}
+			res.Rekeyed++
+		}
+	}
+	if res.Rekeyed > 0 && h.grid != nil {
+		h.grid.rebuild(h.points)
+	}
+	res.Conns = len(h.conns)
+	res.Points = len(h.points)
+	res.DurationMs = time.Since(start).Milliseconds()
+	h.mu.Unlock()
+	return res
+}
+
+func (m *roomManager) healthHandler(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Status    string       `json:"status"`
+		Rooms     int          `json:"rooms"`
+		Conns     int          `json:"conns"`
+		Points    int          `json:"points"`
+		LastCheck *checkResult `json:"lastCheck,omitempty"`
+	}{Status: "ok"}
+	for _, h := range m.hubs() {
+		body.Rooms++
+		body.Conns += h.connCount()
+		body.Points += h.pointCount()
+	}
+	m.mu.Lock()
+	body.LastCheck = m.lastCheck
+	m.mu.Unlock()
+
+	if !m.ready.isReady() {
+		body.Status = "not ready"
+		writeJSONResponse(w, m.wire, http.StatusServiceUnavailable, body)
+		return
+	}
+	writeJSONResponse(w, m.wire, http.StatusOK, body)
+}
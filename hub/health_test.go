@@ -0,0 +1,30 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckRekeysPointsAndReapsSilentConnections(t *testing.T) {
+	h := newHub("test")
+	alive, _ := testClient(h)
+	silent, silentConn := testClient(h)
+	silent.lastPong.Store(time.Now().Add(-time.Minute).UnixMilli())
+
+	p := Point{X: 1, Y: 2, Z: 3}
+	h.points["misfiled"] = p
+
+	res := h.check(time.Second)
+	if res.Rekeyed != 1 || res.Reaped != 1 || res.Points != 1 || res.Conns != 1 {
+		t.Errorf("check = %+v, want one rekeyed point and one reaped connection", res)
+	}
+	if _, ok := h.points[h.key(p)]; !ok {
+		t.Errorf("point not moved to its key: %+v", h.points)
+	}
+	if _, ok := h.conns[alive]; !ok {
+		t.Error("responsive connection reaped")
+	}
+	if _, ok := h.conns[silent]; ok || !silentConn.closed {
+		t.Error("silent connection kept")
+	}
+}
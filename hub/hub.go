@@ -0,0 +1,998 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Point is a point of a room as stored and as exchanged with clients.
+type Point struct {
+	ID     string  `json:"id,omitempty"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Z      float64 `json:"z"`
+	Weight float64 `json:"weight"`
+	Color  string  `json:"color,omitempty"`
+	Label  string  `json:"label,omitempty"`
+	Path   string  `json:"path,omitempty"`
+	Pinned bool    `json:"pinned,omitempty"`
+
+	// Owner and CreatedAt are set by the server when the point is added: the
+	// actor that added it and the time as unix milliseconds.
+	Owner     string `json:"owner,omitempty"`
+	CreatedAt int64  `json:"createdAt,omitempty"`
+
+	// Meta holds integration-defined attributes. It is not part of the key.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Ephemeral points are never written to disk.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+
+	// ExpiresAt, in unix milliseconds, is when the point is removed. Clients
+	// may send it or TTLMs, which the server turns into ExpiresAt. Expiring
+	// points, like ephemeral ones, are never written to disk.
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	TTLMs     int64 `json:"ttlMs,omitempty"`
+
+	// Velocity, in units per second, moves the point when the room
+	// simulates.
+	Velocity *[3]float64 `json:"velocity,omitempty"`
+}
+
+// UnmarshalJSON defaults an omitted weight to 1.
+func (p *Point) UnmarshalJSON(data []byte) error {
+	type plain Point
+	v := plain{Weight: 1}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*p = Point(v)
+	return nil
+}
+
+// Message is a frame exchanged with WebSocket clients. Which fields are set
+// depends on Type.
+type Message struct {
+	Type      string            `json:"type"`
+	ID        string            `json:"id,omitempty"`
+	Seq       uint64            `json:"seq,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	Point     *Point            `json:"point,omitempty"`
+	Points    []Point           `json:"points,omitempty"`
+	StartTime int64             `json:"startTime,omitempty"`
+	Mode      string            `json:"mode,omitempty"`
+	Quantum   float64           `json:"quantum,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Created   *bool             `json:"created,omitempty"`
+	Owner     string            `json:"owner,omitempty"`
+	ExpiresAt int64             `json:"expiresAt,omitempty"`
+	Locks     []lockState       `json:"locks,omitempty"`
+	Peer      *peerState        `json:"peer,omitempty"`
+	Peers     []peerState       `json:"peers,omitempty"`
+	Signals   []signalEvent     `json:"signals,omitempty"`
+	Min       *[3]float64       `json:"min,omitempty"`
+	Max       *[3]float64       `json:"max,omitempty"`
+	Weight    *float64          `json:"weight,omitempty"`
+	Color     *string           `json:"color,omitempty"`
+	Nickname  *string           `json:"nickname,omitempty"`
+	Camera    *camera           `json:"camera,omitempty"`
+	Label     *string           `json:"label,omitempty"`
+	Pinned    *bool             `json:"pinned,omitempty"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	MetaMode  string            `json:"metaMode,omitempty"`
+	Updates   []pointUpdate     `json:"updates,omitempty"`
+	Results   []itemResult      `json:"results,omitempty"`
+	At        int64             `json:"at,omitempty"`
+	Types     []string          `json:"types,omitempty"`
+	Done      bool              `json:"done,omitempty"`
+	Atomic    bool              `json:"atomic,omitempty"`
+	Since     *uint64           `json:"since,omitempty"`
+	Selection []Point           `json:"selection,omitempty"`
+	Velocity  *[3]float64       `json:"velocity,omitempty"`
+	Messages  []Message         `json:"messages,omitempty"`
+
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	ReconnectAfter int64  `json:"reconnectAfterMs,omitempty"`
+	From           *Point `json:"from,omitempty"`
+	To             *Point `json:"to,omitempty"`
+	Code           string `json:"code,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+	RequestID      string `json:"requestId,omitempty"`
+
+	// Simulation describes the room's simulation in init and simulation
+	// messages.
+	Simulation *simState `json:"simulation,omitempty"`
+
+	// Generate is a generate command, and Generated its outcome.
+	Generate  *generateSpec   `json:"generate,omitempty"`
+	Generated *generateResult `json:"generated,omitempty"`
+
+	// Text is the text of a chat message a client sends, broadcast back as
+	// Chat. Init and sync carry the room's ChatHistory.
+	Text        string      `json:"text,omitempty"`
+	Chat        *chatEntry  `json:"chat,omitempty"`
+	ChatHistory []chatEntry `json:"chatHistory,omitempty"`
+
+	// KeyDecimals is announced in init and sync by rooms that key points by
+	// their coordinates, see CoordKey.
+	KeyDecimals *int `json:"keyDecimals,omitempty"`
+}
+
+func errorMessage(code, reason string) Message {
+	return Message{Type: "error", Code: code, Reason: reason}
+}
+
+// conn is the part of a WebSocket connection the hub writes to. It is
+// satisfied by *websocket.Conn and lets the hub be driven without a network.
+type conn interface {
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	EnableWriteCompression(enable bool)
+	WritePreparedMessage(pm *websocket.PreparedMessage) error
+	Close() error
+}
+
+type client struct {
+	id          string
+	log         *slog.Logger
+	conn        conn
+	writeMu     sync.Mutex
+	lastPong    atomic.Int64
+	connectedAt time.Time
+	lastRead    atomic.Int64
+	received    atomic.Uint64
+	stale       atomic.Bool
+	compressed  bool
+	compressMin int
+	timeout     time.Duration
+	bucket      *tokenBucket
+	chatBucket  *tokenBucket
+	format      wireFormat
+	wire        wireEncoding
+	totals      *byteTotals
+	undo        *undoHistory
+	profile     atomic.Pointer[peerProfile]
+	presence    presenceThrottle
+	// requestID is that of the message being handled, echoed in its ack
+	// or error reply. Only the read loop touches it.
+	requestID string
+	identity  string
+	role      atomic.Int32
+	// granted is the role the client's token claims, which ACL edits leave
+	// alone; spectators stay viewers whatever the ACL says.
+	granted   role
+	spectator bool
+	queue     *sendQueue
+	payload   atomic.Uint64
+
+	// Slow-write escalation state, see slowWritePolicy.
+	degraded   atomic.Bool
+	strikes    atomic.Int32
+	fastWrites atomic.Int32
+
+	// subs is the set of broadcast types the client asked for; nil means
+	// every type.
+	subMu  sync.RWMutex
+	subs   map[string]struct{}
+	region *region
+}
+
+func (c *client) subscribe(types []string) {
+	var subs map[string]struct{}
+	if len(types) > 0 {
+		subs = make(map[string]struct{}, len(types))
+		for _, t := range types {
+			subs[t] = struct{}{}
+		}
+	}
+	c.subMu.Lock()
+	c.subs = subs
+	c.subMu.Unlock()
+}
+
+// filter returns the part of msg the client subscribed to, by type and by
+// region, and whether that differs from msg so it must be encoded again.
+// Delta frames are trimmed to the wanted messages they contain.
+func (c *client) filter(msg Message) (out Message, ok, rewritten bool) {
+	r := c.subscribedRegion()
+	if msg.Type != "delta" {
+		if !c.wants(msg.Type) {
+			return msg, false, false
+		}
+		return r.clip(msg)
+	}
+	c.subMu.RLock()
+	all := c.subs == nil
+	c.subMu.RUnlock()
+	if all && r == nil && !c.degraded.Load() {
+		return msg, true, false
+	}
+	var kept []Message
+	for _, m := range msg.Messages {
+		if !c.wants(m.Type) {
+			continue
+		}
+		if m, ok, _ := r.clip(m); ok {
+			kept = append(kept, m)
+		}
+	}
+	if len(kept) == 0 {
+		return Message{}, false, false
+	}
+	return Message{Type: "delta", Seq: msg.Seq, Messages: kept}, true, true
+}
+
+func (c *client) subscribedTypes() []string {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+	types := make([]string, 0, len(c.subs))
+	for t := range c.subs {
+		types = append(types, t)
+	}
+	return types
+}
+
+func (c *client) wants(msgType string) bool {
+	if c.degraded.Load() && messagePriority(msgType) < priorityHigh {
+		return false
+	}
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+	if c.subs == nil {
+		return true
+	}
+	_, ok := c.subs[msgType]
+	return ok
+}
+
+func (c *client) write(f frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if f.prepared == nil {
+		return c.writeFrame(f.data)
+	}
+	c.prepareWrite(len(f.data))
+	return c.conn.WritePreparedMessage(f.prepared)
+}
+
+// writeFrame writes a frame in the client's format and counts its payload. The caller must hold
+// writeMu.
+func (c *client) writeFrame(payload []byte) error {
+	c.prepareWrite(len(payload))
+	return c.conn.WriteMessage(c.format.frameType(), payload)
+}
+
+// prepareWrite sets the deadline and compression for a payload of n bytes
+// and counts it. On connections that negotiated compression only payloads of
+// at least compressMin bytes are deflated.
+func (c *client) prepareWrite(n int) {
+	if c.timeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	}
+	if c.compressed {
+		// Deflating small frames costs more CPU than the bytes it saves.
+		c.conn.EnableWriteCompression(n >= c.compressMin)
+	}
+	c.payload.Add(uint64(n))
+	if c.totals != nil {
+		c.totals.payload.Add(uint64(n))
+	}
+}
+
+// encode encodes v for the client's wire format.
+func (c *client) encode(v interface{}) ([]byte, error) {
+	data, err := c.wire.marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.format.encode(data)
+}
+
+func (c *client) writeJSON(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	data, err := c.encode(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(data)
+}
+
+// reply sends v to the client behind any broadcasts already queued for it.
+func (c *client) reply(v interface{}) error {
+	if c.queue == nil {
+		return c.writeJSON(v)
+	}
+	data, err := c.encode(v)
+	if err != nil {
+		return err
+	}
+	if !c.queue.push(frame{data: data}, priorityHigh) {
+		return errSendQueueFull
+	}
+	return nil
+}
+
+// replyError sends a validation failure back to the client, tagged with the
+// request ID of the message that caused it. The returned error is non-nil
+// only if the write failed.
+func (c *client) replyError(err *validationError) error {
+	return c.fail(errorMessage(err.Code, err.Reason))
+}
+
+// fail sends an error reply tagged with the current request ID, which is
+// then spent so the request is not acknowledged as well.
+func (c *client) fail(reply Message) error {
+	c.log.Debug("rejected message", "code", reply.Code, "reason", reply.Reason, "requestId", c.requestID)
+	reply.RequestID = c.requestID
+	c.requestID = ""
+	return c.reply(reply)
+}
+
+// ack confirms to c that the mutation it tagged with a request ID was applied
+// at or before seq.
+func (h *Hub) ack(c *client) error {
+	if c.requestID == "" {
+		return nil
+	}
+	h.mu.Lock()
+	seq := h.seq
+	h.mu.Unlock()
+	return c.reply(Message{Type: "ack", RequestID: c.requestID, Seq: seq})
+}
+
+// connSeq numbers connections across all rooms.
+var connSeq atomic.Uint64
+
+// Hub holds one room: its points and the clients connected to it.
+type Hub struct {
+	room      string
+	mu        sync.Mutex
+	points    map[string]Point
+	selection map[string]struct{}
+	conns     map[*client]struct{}
+	dispatch  *dispatchIndex
+	startTime int64
+	seq       uint64
+	audit     *auditLog
+	observers []func(Mutation)
+	mutations chan Mutation
+	// publish, when set, is handed every local mutation from emit. Unlike
+	// observers it must not block and is never skipped.
+	publish func(Mutation)
+
+	// order is held from a mutation until its broadcast has been queued,
+	// see sequenced.
+	order sync.Mutex
+
+	// In id mode points are keyed by their ID rather than their
+	// coordinates, so several points may share a position. A hub is in
+	// exactly one mode for its whole lifetime.
+	idMode      bool
+	nextPointID uint64
+	keyDecimals int
+
+	// pointTTL is the lifetime of points added without an expiry; the sweep
+	// looks for expired points once nextExpiry has passed.
+	pointTTL   time.Duration
+	nextExpiry int64
+
+	// sim is nil unless the room simulates.
+	sim *simulation
+
+	// maxGenerate caps the points of one generate command (0 for no cap).
+	maxGenerate int
+
+	// limits caps the points of the server and of each owner; owned counts
+	// each owner's points when there is a per-owner cap, and staging the
+	// points of an atomic batch not yet emitted.
+	limits  pointLimits
+	owned   map[string]int
+	staging int
+
+	moves   *moveCoalescer
+	limiter *broadcastLimiter
+	idem    *idempotencyCache
+
+	// transform, when set, maps incoming points into the hub's coordinate
+	// space before they are keyed and stored.
+	transform *transform
+
+	maxBatch         int
+	maxMessageBytes  int64
+	initChunkSize    int
+	maxInitBytes     int64
+	moveQuantum      float64
+	bounds           *boundsTracker
+	locks            map[string]*pointLock
+	minDistance      float64
+	staleAfter       time.Duration
+	accept           *acceptQueue
+	changes          *changeLog
+	config           roomConfig
+	slowWrites       slowWritePolicy
+	sendQueue        int
+	grid             *spatialGrid
+	lockTimeout      time.Duration
+	writeTimeout     time.Duration
+	readTimeout      time.Duration
+	flood            floodPolicy
+	undoDepth        int
+	presenceInterval time.Duration
+	// ownerRemoves limits removals to the points a caller owns; with
+	// aclAdmins, -acl admins may still remove any point.
+	ownerRemoves bool
+	aclAdmins    bool
+	compressMin  int
+	signals      *signalBuffer
+	chats        *chatLog
+	storage      *persister
+	history      *opHistory
+
+	// Connections are recycled after maxLifetime plus a random share of
+	// lifetimeJitter so that a fleet of clients does not reconnect at once.
+	maxLifetime    time.Duration
+	lifetimeJitter time.Duration
+
+	// Shared by every room of a server. hotLog guards the log sites that
+	// misbehaving clients can trigger at will.
+	wire     wireEncoding
+	meta     metaLimits
+	strict   bool
+	upgrader *websocket.Upgrader
+	hotLog   *logSampler
+	metrics  *serverMetrics
+}
+
+func newHub(room string) *Hub {
+	h := &Hub{
+		room:            room,
+		points:          make(map[string]Point),
+		selection:       make(map[string]struct{}),
+		locks:           make(map[string]*pointLock),
+		changes:         newChangeLog(10*time.Minute, 10000),
+		lockTimeout:     30 * time.Second,
+		conns:           make(map[*client]struct{}),
+		dispatch:        newDispatchIndex(),
+		startTime:       time.Now().UnixMilli(),
+		maxBatch:        10000,
+		maxMessageBytes: 4 << 20,
+		initChunkSize:   5000,
+		moveQuantum:     0.001,
+		keyDecimals:     defaultKeyDecimals,
+		writeTimeout:    10 * time.Second,
+		meta:            metaLimits{keys: 32, bytes: 4096},
+		strict:          true,
+		upgrader:        newUpgrader(false, nil),
+		hotLog:          newLogSampler(10 * time.Second),
+		metrics:         new(serverMetrics),
+	}
+	h.moves = newMoveCoalescer(0, h.broadcast)
+	h.moves.sequence = h.sequenced
+	h.moves.current = func() uint64 {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.seq
+	}
+	h.idem = newIdempotencyCache(10*time.Minute, 10000)
+	return h
+}
+
+func (h *Hub) key(p Point) string {
+	if h.idMode {
+		return p.ID
+	}
+	return CoordKey(p, h.keyDecimals)
+}
+
+const defaultKeyDecimals = 6
+
+// coordKey identifies p by its coordinates rounded to decimals places, so
+// points closer than that are duplicates. Coordinates that round to zero
+// share one key whatever their sign. Rooms keyed by coordinates announce
+// their decimals in init and sync.
+func CoordKey(p Point, decimals int) string {
+	b := make([]byte, 0, 3*(decimals+4))
+	for i, v := range [...]float64{p.X, p.Y, p.Z} {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		start := len(b)
+		b = strconv.AppendFloat(b, v, 'f', decimals, 64)
+		if b[start] == '-' && strings.Trim(string(b[start+1:]), "0.") == "" {
+			b = append(b[:start], b[start+1:]...)
+		}
+	}
+	return string(b)
+}
+
+// announcedKeyDecimals is the KeyDecimals of init and sync messages.
+func (h *Hub) announcedKeyDecimals() *int {
+	if h.idMode {
+		return nil
+	}
+	n := h.keyDecimals
+	return &n
+}
+
+func (h *Hub) mode() string {
+	if h.idMode {
+		return "id"
+	}
+	return "coords"
+}
+
+// prepare readies an incoming point for storage: it records actor as its
+// owner and the current time, applies the ingest transform, then coordinate
+// mode discards any client id and id mode generates one when the client did
+// not supply it.
+// Must be called with h.mu held.
+func (h *Hub) prepare(p Point, actor string) Point {
+	p.Owner, p.CreatedAt = actor, time.Now().UnixMilli()
+	switch {
+	case p.TTLMs > 0:
+		p.ExpiresAt = p.CreatedAt + p.TTLMs
+	case p.ExpiresAt == 0 && h.pointTTL > 0:
+		p.ExpiresAt = p.CreatedAt + h.pointTTL.Milliseconds()
+	}
+	p.TTLMs = 0
+	if h.transform != nil {
+		p = h.transform.apply(p)
+	}
+	p = h.config.place(p)
+	if !h.idMode {
+		p.ID = ""
+		return p
+	}
+	for p.ID == "" {
+		h.nextPointID++
+		id := fmt.Sprintf("p%d", h.nextPointID)
+		if _, exists := h.points[id]; !exists {
+			p.ID = id
+		}
+	}
+	return p
+}
+
+func (h *Hub) addPoint(p Point, actor string) (Point, *validationError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.insert(p, actor)
+}
+
+// insert stores p unless a point with the same key exists or, with a
+// minimum distance set, one lies too close; either way it returns the point
+// in the way. Must be called with h.mu held.
+func (h *Hub) insert(p Point, actor string) (Point, *validationError) {
+	p = h.prepare(p, actor)
+	key := h.key(p)
+	if conflict, err := h.admit(p, key); err != nil {
+		return conflict, err
+	}
+	h.points[key] = p
+	h.trackExpiry(p)
+	h.emit(Mutation{Type: "add", Actor: actor, Point: p})
+	return p, nil
+}
+
+// admit reports why p cannot be stored under key, along with the point it
+// conflicts with, if any.
+func (h *Hub) admit(p Point, key string) (Point, *validationError) {
+	if existing, exists := h.points[key]; exists {
+		return existing, invalid(errDuplicate, "a point with the same key already exists")
+	}
+	if h.config.MaxPoints > 0 && len(h.points) >= h.config.MaxPoints {
+		return Point{}, invalid(errRoomFull, "room holds its maximum of %d points", h.config.MaxPoints)
+	}
+	if err := h.limitErr(p); err != nil {
+		return Point{}, err
+	}
+	return h.spacingErr(p, "")
+}
+
+// addPoints adds every point that is not already present under a single lock
+// acquisition and returns the ones that were added.
+func (h *Hub) addPoints(ps []Point, actor string) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	added := make([]Point, 0, len(ps))
+	for _, p := range ps {
+		if p, err := h.insert(p, actor); err == nil {
+			added = append(added, p)
+		}
+	}
+	return added
+}
+
+// addPointsEach is addPoints reporting, for every input, the stored point, or
+// the one that prevented it from being added along with the reason.
+func (h *Hub) addPointsEach(ps []Point, actor string) ([]Point, []*validationError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stored := make([]Point, len(ps))
+	errs := make([]*validationError, len(ps))
+	for i, p := range ps {
+		stored[i], errs[i] = h.insert(p, actor)
+	}
+	return stored, errs
+}
+
+// addPointsAtomic adds all of ps or none of them. Points are staged into the
+// room one by one so later entries are checked against earlier ones; if any
+// is rejected the staged points are taken back out before anything is
+// emitted, and the rejected entries are returned instead.
+func (h *Hub) addPointsAtomic(ps []Point, actor string) ([]Point, []itemResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	nextID := h.nextPointID
+	staged := make([]Point, 0, len(ps))
+	var rejected []itemResult
+	for i, p := range ps {
+		p = h.prepare(p, actor)
+		key := h.key(p)
+		if conflict, err := h.admit(p, key); err != nil {
+			res := itemResult{Index: i, Status: "rejected", Code: err.Code, Reason: err.Reason}
+			if err.Code != errRoomFull && err.Code != errLimitExceeded {
+				res.Point = &conflict
+			}
+			rejected = append(rejected, res)
+			continue
+		}
+		h.points[key] = p
+		h.trackExpiry(p)
+		if h.grid != nil {
+			h.grid.insert(key, p)
+		}
+		staged = append(staged, p)
+		h.staging++
+	}
+	h.staging = 0
+	if len(rejected) > 0 {
+		for _, p := range staged {
+			key := h.key(p)
+			delete(h.points, key)
+			if h.grid != nil {
+				h.grid.remove(key, p)
+			}
+		}
+		h.nextPointID = nextID
+		return nil, rejected
+	}
+	for _, p := range staged {
+		h.emit(Mutation{Type: "add", Actor: actor, Point: p})
+	}
+	return staged, nil
+}
+
+// removePoint deletes the point with the same key as p and returns the
+// stored point, which in id mode carries the coordinates the caller may not
+// have sent.
+func (h *Hub) removePoint(p Point, actor string) (Point, *validationError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(p)
+	stored, exists := h.points[key]
+	if !exists {
+		return Point{}, invalid(errNotFound, "no such point")
+	}
+	if err := h.lockErr(key, actor); err != nil {
+		return Point{}, err
+	}
+	delete(h.points, key)
+	h.emit(Mutation{Type: "remove", Actor: actor, Point: stored})
+	return stored, nil
+}
+
+// removePoints deletes every listed point that is present under a single lock
+// acquisition and returns the stored points that were removed, along with how
+// many were skipped because another connection holds their lock.
+func (h *Hub) removePoints(ps []Point, actor string) ([]Point, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	removed := make([]Point, 0, len(ps))
+	locked := 0
+	for _, p := range ps {
+		key := h.key(p)
+		stored, exists := h.points[key]
+		if !exists {
+			continue
+		}
+		if h.lockErr(key, actor) != nil {
+			locked++
+			continue
+		}
+		delete(h.points, key)
+		h.emit(Mutation{Type: "remove", Actor: actor, Point: stored})
+		removed = append(removed, stored)
+	}
+	return removed, locked
+}
+
+// updatePoint applies fn to the stored point with the same key as target.
+// fn must not change the fields the key is derived from.
+func (h *Hub) updatePoint(target Point, actor string, fn func(p *Point) *validationError) (Point, *validationError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(target)
+	stored, exists := h.points[key]
+	if !exists {
+		return Point{}, invalid(errNotFound, "no such point")
+	}
+	if err := h.lockErr(key, actor); err != nil {
+		return Point{}, err
+	}
+	if err := fn(&stored); err != nil {
+		return Point{}, err
+	}
+	h.points[key] = stored
+	// An unpinned point may be overdue.
+	h.trackExpiry(stored)
+	h.emit(Mutation{Type: "update", Actor: actor, Point: stored})
+	return stored, nil
+}
+
+func (h *Hub) snapshotPoints() []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]Point, 0, len(h.points))
+	for _, p := range h.points {
+		out = append(out, p)
+	}
+	return out
+}
+
+// removePrefix removes every point whose path is prefix or lies beneath it,
+// like a clear of that subtree: pinned points and points locked by others
+// are left in place.
+func (h *Hub) removePrefix(prefix, actor string) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var removed []Point
+	for key, p := range h.points {
+		if !pathHasPrefix(p.Path, prefix) || p.Pinned || h.lockErr(key, actor) != nil {
+			continue
+		}
+		delete(h.points, key)
+		h.emit(Mutation{Type: "remove", Actor: actor, Point: p})
+		removed = append(removed, p)
+	}
+	return removed
+}
+
+// clearPoints removes every point that is not pinned and returns the
+// removed points. Pinned points only go away through a targeted remove.
+func (h *Hub) clearPoints(actor string) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var removed []Point
+	for key, p := range h.points {
+		if p.Pinned {
+			continue
+		}
+		delete(h.points, key)
+		h.emit(Mutation{Type: "remove", Actor: actor, Point: p})
+		removed = append(removed, p)
+	}
+	return removed
+}
+
+// checkBatch rejects batches longer than the configured maximum.
+func (h *Hub) checkBatch(n int) *validationError {
+	if h.maxBatch > 0 && n > h.maxBatch {
+		return invalid(errBatchTooLarge, "batch of %d points exceeds the limit of %d", n, h.maxBatch)
+	}
+	return nil
+}
+
+// movePoint relocates the point identified by from to the coordinates of to
+// and returns the stored point before and after the move.
+func (h *Hub) movePoint(from, to Point, actor string) (Point, Point, *validationError) {
+	return h.moveByKey(h.key(from), actor, func(p Point) Point {
+		if h.transform != nil {
+			to = h.transform.apply(to)
+		}
+		p.X, p.Y, p.Z = to.X, to.Y, to.Z
+		return p
+	})
+}
+
+// moveByKey relocates the point stored under key to the position returned by
+// fn, which receives the stored point.
+func (h *Hub) moveByKey(key, actor string, fn func(Point) Point) (Point, Point, *validationError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	old, exists := h.points[key]
+	if !exists {
+		return Point{}, Point{}, invalid(errNotFound, "no such point")
+	}
+	if err := h.lockErr(key, actor); err != nil {
+		return Point{}, Point{}, err
+	}
+	moved := h.config.place(fn(old))
+	if err := validatePoint(moved, h.meta); err != nil {
+		return Point{}, Point{}, err
+	}
+	if _, err := h.spacingErr(moved, key); err != nil {
+		return Point{}, Point{}, err
+	}
+	newKey := h.key(moved)
+	if newKey != key {
+		if _, taken := h.points[newKey]; taken {
+			return Point{}, Point{}, invalid(errDuplicate, "a point already exists at the destination")
+		}
+		delete(h.points, key)
+		if _, selected := h.selection[key]; selected {
+			delete(h.selection, key)
+			h.selection[newKey] = struct{}{}
+		}
+		if l, locked := h.locks[key]; locked {
+			delete(h.locks, key)
+			l.key = newKey
+			h.locks[newKey] = l
+		}
+	}
+	h.points[newKey] = moved
+	h.emit(Mutation{Type: "move", Actor: actor, Point: moved, From: &old})
+	return old, moved, nil
+}
+
+func (h *Hub) pointCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.points)
+}
+
+func (h *Hub) connCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+func (h *Hub) addConn(conn conn, compressed bool, format wireFormat) *client {
+	now := time.Now()
+	c := &client{id: fmt.Sprintf("c%d", connSeq.Add(1)), conn: conn, connectedAt: now, compressed: compressed, format: format, compressMin: h.compressMin, timeout: h.writeTimeout, wire: h.wire, totals: h.metrics.totalsFor(compressed)}
+	c.log = slog.With("conn", c.id, "room", h.room)
+	c.totals.conns.Add(1)
+	if h.sendQueue > 0 {
+		c.queue = newSendQueue(h.sendQueue)
+		go h.writeLoop(c)
+	}
+	if h.flood.rate > 0 {
+		c.bucket = newTokenBucket(h.flood)
+	}
+	if h.chats != nil && h.chats.rate.rate > 0 {
+		c.chatBucket = newTokenBucket(h.chats.rate)
+	}
+	if h.undoDepth > 0 {
+		c.undo = newUndoHistory(h.undoDepth)
+	}
+	c.lastPong.Store(now.UnixMilli())
+	c.lastRead.Store(now.UnixMilli())
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[c] = struct{}{}
+	h.dispatch.add(c, nil, nil)
+	return c
+}
+
+func (h *Hub) removeConn(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[c]; ok {
+		delete(h.conns, c)
+		h.dispatch.remove(c, c.subscribedTypes(), c.subscribedRegion())
+		c.totals.conns.Add(-1)
+	}
+	if c.queue != nil {
+		c.queue.close()
+	}
+	c.conn.Close()
+}
+
+func (h *Hub) lifetime() time.Duration {
+	d := h.maxLifetime
+	if h.lifetimeJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(h.lifetimeJitter)))
+	}
+	return d
+}
+
+// expire sends a going-away close frame and drops the connection so that the
+// client reconnects, possibly to another instance.
+func (h *Hub) expire(c *client) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "connection lifetime reached")
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		c.log.Warn("close write failed", "err", err)
+	}
+	h.removeConn(c)
+}
+
+// sequenced runs fn, which applies mutations and broadcasts them, holding
+// h.order throughout. No other mutation can take a sequence number between
+// fn's and its broadcast, so each frame carries the sequence number of the
+// last mutation it reports and every client receives those frames in strictly
+// increasing order. Frames that report no mutation, such as presence or
+// bounds, are sent outside it and carry the current number.
+func (h *Hub) sequenced(fn func()) {
+	h.order.Lock()
+	defer h.order.Unlock()
+	fn()
+}
+
+// broadcast stamps msg with the room's current sequence number, which is at
+// least that of the mutation it reports, and sends it to every subscriber.
+func (h *Hub) broadcast(msg Message) {
+	if msg.Seq == 0 {
+		h.mu.Lock()
+		msg.Seq = h.seq
+		h.mu.Unlock()
+	}
+	if h.limiter != nil {
+		h.limiter.submit(msg)
+		return
+	}
+	h.deliver(msg)
+}
+
+// deliver writes msg to every connection subscribed to it.
+func (h *Hub) deliver(msg Message) {
+	h.metrics.broadcasts.Add(1)
+	defer func(start time.Time) { h.metrics.broadcastLatency.observe(time.Since(start)) }(time.Now())
+	payload, err := h.wire.marshal(msg)
+	if err != nil {
+		slog.Error("broadcast marshal failed", "room", h.room, "type", msg.Type, "err", err)
+		return
+	}
+	// Each format is encoded once, for the first recipient that needs it,
+	// and framed once per compression setting by the prepared message.
+	var frames [formatCount]*frame
+
+	h.mu.Lock()
+	conns := h.dispatch.targets(msg)
+	h.mu.Unlock()
+
+	for _, c := range conns {
+		out, ok, rewritten := c.filter(msg)
+		if !ok {
+			continue
+		}
+		var f frame
+		if rewritten {
+			f.data, err = c.encode(out)
+		} else if frames[c.format] != nil {
+			f = *frames[c.format]
+		} else if f, err = prepareFrame(c.format, payload); err == nil {
+			frames[c.format] = &f
+		}
+		if err != nil {
+			slog.Error("broadcast marshal failed", "room", h.room, "type", msg.Type, "err", err)
+			continue
+		}
+		if c.queue != nil {
+			if !c.queue.push(f, messagePriority(msg.Type)) {
+				c.log.Warn("send queue full, dropping connection")
+				h.metrics.droppedClients.Add(1)
+				h.removeConn(c)
+			}
+			continue
+		}
+		start := time.Now()
+		if err := c.write(f); err != nil {
+			h.hotLog.Warn(c.log, "write failed", "err", err)
+			h.metrics.droppedClients.Add(1)
+			h.removeConn(c)
+			continue
+		}
+		h.noteWrite(c, time.Since(start))
+	}
+}
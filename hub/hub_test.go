@@ -0,0 +1,481 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeConn records the frames the hub writes to a connection.
+type fakeConn struct {
+	mu     sync.Mutex
+	frames [][]byte
+	// closeFrame is the payload of the last close frame written.
+	closeFrame []byte
+	closed     bool
+	// compression records every EnableWriteCompression call.
+	compression []bool
+}
+
+func (f *fakeConn) WriteMessage(_ int, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.frames = append(f.frames, append([]byte(nil), data...))
+	return nil
+}
+
+func (f *fakeConn) WriteControl(messageType int, data []byte, _ time.Time) error {
+	if messageType == websocket.CloseMessage {
+		f.mu.Lock()
+		f.closeFrame = append([]byte(nil), data...)
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+func (f *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (f *fakeConn) EnableWriteCompression(enable bool) {
+	f.mu.Lock()
+	f.compression = append(f.compression, enable)
+	f.mu.Unlock()
+}
+
+func (f *fakeConn) WritePreparedMessage(pm *websocket.PreparedMessage) error {
+	data, err := preparedPayload(pm)
+	if err != nil {
+		return err
+	}
+	return f.WriteMessage(websocket.TextMessage, data)
+}
+
+func (f *fakeConn) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	return nil
+}
+
+// messages decodes and forgets the frames written so far.
+func (f *fakeConn) messages(t *testing.T) []Message {
+	t.Helper()
+	f.mu.Lock()
+	frames := f.frames
+	f.frames = nil
+	f.mu.Unlock()
+	msgs := make([]Message, len(frames))
+	for i, data := range frames {
+		if err := json.Unmarshal(data, &msgs[i]); err != nil {
+			t.Fatalf("frame %s: %v", data, err)
+		}
+	}
+	return msgs
+}
+
+// ofType decodes and forgets the frames written so far, returning those of
+// type msgType.
+func (f *fakeConn) ofType(t *testing.T, msgType string) []Message {
+	t.Helper()
+	var out []Message
+	for _, m := range f.messages(t) {
+		if m.Type == msgType {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// unwrapper is a WebSocket connection pair that prepared messages are sent
+// through to read their payload back, which a PreparedMessage keeps to
+// itself.
+var unwrapper struct {
+	once    sync.Once
+	err     error
+	mu      sync.Mutex
+	out, in *websocket.Conn
+}
+
+func preparedPayload(pm *websocket.PreparedMessage) ([]byte, error) {
+	unwrapper.once.Do(func() {
+		conns := make(chan *websocket.Conn, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil); err == nil {
+				conns <- c
+			}
+		}))
+		in, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			unwrapper.err = err
+			return
+		}
+		unwrapper.in, unwrapper.out = in, <-conns
+	})
+	if unwrapper.err != nil {
+		return nil, unwrapper.err
+	}
+	unwrapper.mu.Lock()
+	defer unwrapper.mu.Unlock()
+	sent := make(chan error, 1)
+	go func() { sent <- unwrapper.out.WritePreparedMessage(pm) }()
+	_, data, err := unwrapper.in.ReadMessage()
+	if werr := <-sent; err == nil {
+		err = werr
+	}
+	return data, err
+}
+
+// testClient registers a fake connection with h. It holds the admin role, as
+// callers do without authentication.
+func testClient(h *Hub) (*client, *fakeConn) {
+	fc := &fakeConn{}
+	c := h.addConn(fc, false, formatJSON)
+	c.role.Store(int32(roleAdmin))
+	return c, fc
+}
+
+// send handles msg from c the way the read loop does.
+func send(t *testing.T, h *Hub, c *client, msg Message) {
+	t.Helper()
+	var err error
+	c.requestID = msg.RequestID
+	if mutates(msg.Type) {
+		h.sequenced(func() {
+			if err = h.handleMessage(c, msg); err == nil {
+				err = h.ack(c)
+			}
+		})
+	} else {
+		err = h.handleMessage(c, msg)
+	}
+	c.requestID = ""
+	if err != nil {
+		t.Fatalf("%s: %v", msg.Type, err)
+	}
+}
+
+func TestAddIsBroadcastToEveryConnection(t *testing.T) {
+	h := newHub("test")
+	alice, aliceConn := testClient(h)
+	_, bobConn := testClient(h)
+
+	send(t, h, alice, Message{Type: "add", Point: &Point{X: 1, Y: 2, Z: 3}})
+	for name, fc := range map[string]*fakeConn{"alice": aliceConn, "bob": bobConn} {
+		adds := fc.ofType(t, "add")
+		if len(adds) != 1 || adds[0].Point == nil || adds[0].Point.X != 1 || adds[0].Seq != 1 {
+			t.Errorf("%s got adds %+v", name, adds)
+		}
+	}
+	if len(h.points) != 1 {
+		t.Errorf("room holds %d points, want 1", len(h.points))
+	}
+}
+
+func TestRemoveIsBroadcast(t *testing.T) {
+	h := newHub("test")
+	alice, _ := testClient(h)
+	_, bobConn := testClient(h)
+
+	p := Point{X: 1, Y: 2, Z: 3}
+	send(t, h, alice, Message{Type: "add", Point: &p})
+	send(t, h, alice, Message{Type: "remove", Point: &p})
+	removes := bobConn.ofType(t, "remove")
+	if len(removes) != 1 || removes[0].Point == nil || removes[0].Point.X != 1 || removes[0].Seq != 2 {
+		t.Errorf("got removes %+v", removes)
+	}
+	if len(h.points) != 0 {
+		t.Errorf("room holds %d points after remove", len(h.points))
+	}
+}
+
+func TestDuplicateAddIsIgnored(t *testing.T) {
+	h := newHub("test")
+	alice, aliceConn := testClient(h)
+	_, bobConn := testClient(h)
+
+	p := Point{X: 1, Y: 2, Z: 3}
+	send(t, h, alice, Message{Type: "add", Point: &p})
+	bobConn.messages(t)
+	send(t, h, alice, Message{Type: "add", Point: &p, RequestID: "r2"})
+	if got := bobConn.messages(t); len(got) != 0 {
+		t.Errorf("duplicate add broadcast %+v", got)
+	}
+	acks := aliceConn.ofType(t, "ack")
+	if len(acks) != 1 || acks[0].RequestID != "r2" {
+		t.Errorf("adder got acks %+v, want one for r2", acks)
+	}
+	if len(h.points) != 1 {
+		t.Errorf("room holds %d points, want 1", len(h.points))
+	}
+}
+
+// errorCode decodes and forgets the frames written so far, returning the code
+// of the last error among them or "" when there is none.
+func errorCode(t *testing.T, fc *fakeConn) string {
+	t.Helper()
+	code := ""
+	for _, m := range fc.messages(t) {
+		if m.Type == "error" {
+			code = m.Code
+		}
+	}
+	return code
+}
+
+func TestBatchLargerThanMaxIsRejected(t *testing.T) {
+	h := newHub("test")
+	h.maxBatch = 2
+	c, fc := testClient(h)
+
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{{X: 1}, {X: 2}, {X: 3}}})
+	if code := errorCode(t, fc); code != errBatchTooLarge {
+		t.Errorf("oversized batch got error %q, want %q", code, errBatchTooLarge)
+	}
+	if len(h.points) != 0 {
+		t.Errorf("oversized batch stored %d points", len(h.points))
+	}
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{{X: 1}, {X: 2}}})
+	if code := errorCode(t, fc); code != "" {
+		t.Errorf("batch at the limit got error %q", code)
+	}
+	if len(h.points) != 2 {
+		t.Errorf("batch at the limit stored %d points, want 2", len(h.points))
+	}
+}
+
+func TestIDModeKeepsPointsAtTheSamePosition(t *testing.T) {
+	h := newHub("test")
+	h.idMode = true
+	c, fc := testClient(h)
+
+	p := Point{X: 1, Y: 2, Z: 3}
+	send(t, h, c, Message{Type: "add", Point: &p})
+	send(t, h, c, Message{Type: "add", Point: &p})
+	added := fc.ofType(t, "added")
+	if len(added) != 2 || added[0].ID == "" || added[0].ID == added[1].ID {
+		t.Fatalf("got added replies %+v, want two distinct ids", added)
+	}
+	if len(h.points) != 2 {
+		t.Fatalf("room holds %d points, want 2", len(h.points))
+	}
+
+	send(t, h, c, Message{Type: "remove", Point: &Point{ID: added[0].ID}})
+	if _, ok := h.points[added[0].ID]; ok || len(h.points) != 1 {
+		t.Errorf("remove by id left %+v", h.points)
+	}
+
+	// A client-chosen id is kept, and reusing it is a duplicate.
+	send(t, h, c, Message{Type: "add", Point: &Point{ID: "mine", X: 1, Y: 2, Z: 3}})
+	send(t, h, c, Message{Type: "add", Point: &Point{ID: "mine", X: 5}})
+	if got, ok := h.points["mine"]; !ok || got.X != 1 {
+		t.Errorf("point mine = %+v, %v; want the first add", got, ok)
+	}
+}
+
+func TestRemoveBatchBroadcastsWhatWasRemoved(t *testing.T) {
+	h := newHub("test")
+	c, _ := testClient(h)
+	_, watcher := testClient(h)
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{{X: 1}, {X: 2}, {X: 3}}})
+	watcher.messages(t)
+
+	send(t, h, c, Message{Type: "removeBatch", Points: []Point{{X: 1}, {X: 3}, {X: 9}}})
+	batches := watcher.ofType(t, "removeBatch")
+	if len(batches) != 1 || len(batches[0].Points) != 2 {
+		t.Fatalf("got %+v, want one batch of the two stored points", batches)
+	}
+	if len(h.points) != 1 {
+		t.Errorf("room holds %d points, want 1", len(h.points))
+	}
+
+	// Nothing removed, nothing broadcast.
+	send(t, h, c, Message{Type: "removeBatch", Points: []Point{{X: 9}}})
+	if got := watcher.messages(t); len(got) != 0 {
+		t.Errorf("removing absent points broadcast %+v", got)
+	}
+}
+
+func TestExpiredConnectionIsClosedGoingAway(t *testing.T) {
+	h := newHub("test")
+	h.maxLifetime, h.lifetimeJitter = time.Minute, 10*time.Second
+	for i := 0; i < 20; i++ {
+		if d := h.lifetime(); d < time.Minute || d >= time.Minute+10*time.Second {
+			t.Fatalf("lifetime %v outside [1m, 1m10s)", d)
+		}
+	}
+
+	c, fc := testClient(h)
+	h.expire(c)
+	if len(fc.closeFrame) < 2 || int(fc.closeFrame[0])<<8|int(fc.closeFrame[1]) != websocket.CloseGoingAway {
+		t.Errorf("close frame %q, want going away", fc.closeFrame)
+	}
+	if _, ok := h.conns[c]; ok || !fc.closed {
+		t.Error("expired connection kept")
+	}
+}
+
+func TestRemovePrefixRemovesTheSubtree(t *testing.T) {
+	h := newHub("test")
+	c, _ := testClient(h)
+	_, watcher := testClient(h)
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{
+		{X: 1, Path: "sol/earth"},
+		{X: 2, Path: "sol/earth/moon"},
+		{X: 3, Path: "sol/earthling"},
+		{X: 4, Path: "sol/earth/iss", Pinned: true},
+	}})
+	watcher.messages(t)
+
+	send(t, h, c, Message{Type: "removePrefix", Path: "sol/earth"})
+	batches := watcher.ofType(t, "removeBatch")
+	if len(batches) != 1 || len(batches[0].Points) != 2 {
+		t.Fatalf("got %+v, want one batch of earth and its moon", batches)
+	}
+	left := map[string]bool{}
+	for _, p := range h.points {
+		left[p.Path] = true
+	}
+	if len(left) != 2 || !left["sol/earthling"] || !left["sol/earth/iss"] {
+		t.Errorf("left %v, want the sibling and the pinned point", left)
+	}
+}
+
+func TestServerIDSurvivesMoveAndRemove(t *testing.T) {
+	h := newHub("test")
+	h.idMode = true
+	c, fc := testClient(h)
+	_, watcher := testClient(h)
+
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1}})
+	added := fc.ofType(t, "added")
+	if len(added) != 1 || added[0].ID == "" || added[0].Point == nil || added[0].Point.ID != added[0].ID {
+		t.Fatalf("adder got %+v, want its point's id", added)
+	}
+	id := added[0].ID
+	if adds := watcher.ofType(t, "add"); len(adds) != 1 || adds[0].Point.ID != id {
+		t.Fatalf("broadcast adds %+v, want id %s", adds, id)
+	}
+
+	send(t, h, c, Message{Type: "move", From: &Point{ID: id}, To: &Point{X: 5}})
+	if moves := watcher.ofType(t, "move"); len(moves) != 1 || moves[0].To.ID != id || moves[0].To.X != 5 {
+		t.Fatalf("broadcast moves %+v, want id %s at x 5", moves, id)
+	}
+	send(t, h, c, Message{Type: "remove", Point: &Point{ID: id}})
+	if removes := watcher.ofType(t, "remove"); len(removes) != 1 || removes[0].Point.ID != id {
+		t.Errorf("broadcast removes %+v", removes)
+	}
+	if len(h.points) != 0 {
+		t.Errorf("room holds %+v after remove", h.points)
+	}
+}
+
+func TestAddIfAbsentReportsWhetherItCreated(t *testing.T) {
+	h := newHub("test")
+	c, fc := testClient(h)
+	_, watcher := testClient(h)
+
+	send(t, h, c, Message{Type: "addIfAbsent", Point: &Point{X: 1, Label: "first"}})
+	send(t, h, c, Message{Type: "addIfAbsent", Point: &Point{X: 1, Label: "second"}})
+	results := fc.ofType(t, "addResult")
+	if len(results) != 2 || !*results[0].Created || *results[1].Created {
+		t.Fatalf("got results %+v, want created then not", results)
+	}
+	if p := results[1].Point; p == nil || p.Label != "first" {
+		t.Errorf("existing point reported as %+v, want the stored one", p)
+	}
+	if adds := watcher.ofType(t, "add"); len(adds) != 1 {
+		t.Errorf("got %d add broadcasts, want 1", len(adds))
+	}
+}
+
+func TestAtomicBatchCommitsOrRollsBack(t *testing.T) {
+	h := newHub("test")
+	c, fc := testClient(h)
+	_, watcher := testClient(h)
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 5}})
+	watcher.messages(t)
+	fc.messages(t)
+
+	// An invalid entry and one colliding with a stored point each fail the
+	// whole batch.
+	for _, ps := range [][]Point{
+		{{X: 1}, {X: 2, Color: "nope"}},
+		{{X: 1}, {X: 2}, {X: 5}},
+	} {
+		send(t, h, c, Message{Type: "addBatch", Atomic: true, Points: ps})
+		results := fc.ofType(t, "addBatchResult")
+		if len(results) != 1 || len(results[0].Results) != 1 || results[0].Results[0].Index != len(ps)-1 {
+			t.Errorf("batch %+v: sender got %+v, want the last entry rejected", ps, results)
+		}
+		if got := watcher.messages(t); len(got) != 0 {
+			t.Errorf("rolled back batch broadcast %+v", got)
+		}
+		if len(h.points) != 1 {
+			t.Errorf("rolled back batch left %d points", len(h.points))
+		}
+	}
+
+	send(t, h, c, Message{Type: "addBatch", Atomic: true, Points: []Point{{X: 1}, {X: 2}}})
+	if batches := watcher.ofType(t, "addBatch"); len(batches) != 1 || len(batches[0].Points) != 2 {
+		t.Errorf("committed batch broadcast %+v", batches)
+	}
+	if len(h.points) != 3 {
+		t.Errorf("committed batch left %d points, want 3", len(h.points))
+	}
+}
+
+// Every connection must see broadcasts in sequence order with no gaps, however
+// many connections mutate the room at once.
+func TestConcurrentWritersKeepSequenceOrder(t *testing.T) {
+	const writers, adds = 32, 100
+	h := newHub("test")
+	h.sendQueue = writers * adds
+	var watchers []*fakeConn
+	for i := 0; i < 2; i++ {
+		_, fc := testClient(h)
+		watchers = append(watchers, fc)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		c, _ := testClient(h)
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				p := Point{X: float64(w), Y: float64(i)}
+				var err error
+				h.sequenced(func() { err = h.handleMessage(c, Message{Type: "add", Point: &p}) })
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for i, fc := range watchers {
+		var seqs []uint64
+		deadline := time.Now().Add(20 * time.Second)
+		for len(seqs) < writers*adds && time.Now().Before(deadline) {
+			for _, m := range fc.ofType(t, "add") {
+				seqs = append(seqs, m.Seq)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if len(seqs) != writers*adds {
+			t.Fatalf("watcher %d got %d adds, want %d", i, len(seqs), writers*adds)
+		}
+		for j, seq := range seqs {
+			if seq != uint64(j+1) {
+				t.Fatalf("watcher %d: add %d has seq %d, want %d", i, j, seq, j+1)
+			}
+		}
+	}
+}
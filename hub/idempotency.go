@@ -0,0 +1,82 @@
+package hub
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// idempotencyCache remembers recently seen client idempotency keys so that a
+// retried add is not applied or broadcast twice. It is independent of the
+// coordinate/id dedup: a retry is ignored even if its point differs from the
+// original, and two different keys may still collide on the same point.
+//
+// Entries expire after ttl and the oldest are evicted beyond size.
+type idempotencyCache struct {
+	ttl   time.Duration
+	size  int
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+type idempotencyEntry struct {
+	key     string
+	expires time.Time
+	done    bool
+	status  int
+	body    []byte
+}
+
+func newIdempotencyCache(ttl time.Duration, size int) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, size: size, items: make(map[string]*list.Element), order: list.New()}
+}
+
+// begin reserves key. When the key was already seen it returns the earlier
+// entry, whose done field tells whether its result has been recorded yet.
+func (c *idempotencyCache) begin(key string) (idempotencyEntry, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		entry := e.Value.(*idempotencyEntry)
+		if now.Before(entry.expires) && c.order.Len() <= c.size {
+			break
+		}
+		c.order.Remove(e)
+		delete(c.items, entry.key)
+	}
+	if e, ok := c.items[key]; ok {
+		return *e.Value.(*idempotencyEntry), true
+	}
+	c.items[key] = c.order.PushBack(&idempotencyEntry{key: key, expires: now.Add(c.ttl)})
+	return idempotencyEntry{}, false
+}
+
+// finish records the result returned to the request that reserved key.
+// WebSocket messages are not answered again and record none.
+func (c *idempotencyCache) finish(key string, status int, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*idempotencyEntry)
+		entry.done, entry.status, entry.body = true, status, body
+	}
+}
+
+func (c *idempotencyCache) isDone(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	return ok && e.Value.(*idempotencyEntry).done
+}
+
+// forget drops a reservation whose request failed before being applied.
+func (c *idempotencyCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+}
@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRetriedAddIsAppliedOnce(t *testing.T) {
+	h := newHub("test")
+	c, _ := testClient(h)
+	_, watcher := testClient(h)
+
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1}, IdempotencyKey: "k"})
+	// A retry is ignored even when its point differs.
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 2}, IdempotencyKey: "k"})
+	if adds := watcher.ofType(t, "add"); len(adds) != 1 || adds[0].Point.X != 1 {
+		t.Errorf("watcher got adds %+v, want only the first", adds)
+	}
+	if len(h.points) != 1 {
+		t.Errorf("room holds %d points, want 1", len(h.points))
+	}
+}
+
+func TestRejectedAddMayBeRetriedWithItsKey(t *testing.T) {
+	h := newHub("test")
+	h.minDistance = 1
+	h.grid = newSpatialGrid(h.minDistance)
+	c, fc := testClient(h)
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 10}})
+
+	for i, bad := range []Point{{X: 1, Color: "nope"}, {X: 10.5}} {
+		key := fmt.Sprintf("k%d", i)
+		send(t, h, c, Message{Type: "add", Point: &bad, IdempotencyKey: key})
+		if code := errorCode(t, fc); code == "" {
+			t.Fatalf("add of %+v was not rejected", bad)
+		}
+		send(t, h, c, Message{Type: "add", Point: &Point{X: float64(i)}, IdempotencyKey: key})
+		if _, ok := h.points[h.key(Point{X: float64(i)})]; !ok {
+			t.Errorf("corrected retry with key %s was dropped", key)
+		}
+	}
+
+	// A batch with nothing new does not spend its key either.
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{{X: 10}}, IdempotencyKey: "b"})
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{{X: 20}}, IdempotencyKey: "b"})
+	if _, ok := h.points[h.key(Point{X: 20})]; !ok {
+		t.Error("retry of a batch that added nothing was dropped")
+	}
+}
+
+func TestRESTRetryRepeatsTheOriginalResponse(t *testing.T) {
+	s := newTestServer(t)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/points?room=r", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "k")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post(`{"x":1}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first add: %d %s", first.Code, first.Body)
+	}
+	retry := post(`{"x":2}`)
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry got %d %s, want the replayed %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if body := get(s, "/points?room=r").Body.String(); !strings.Contains(body, `"x":1`) || strings.Contains(body, `"x":2`) {
+		t.Errorf("retry was applied: %s", body)
+	}
+
+	// A request rejected before anything was applied may be retried.
+	req := httptest.NewRequest("POST", "/points?room=r", strings.NewReader(`not json`))
+	req.Header.Set("Idempotency-Key", "bad")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest("POST", "/points?room=r", strings.NewReader(`{"x":3}`))
+	req.Header.Set("Idempotency-Key", "bad")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry of a rejected request: %d %s", rec.Code, rec.Body)
+	}
+}
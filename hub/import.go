@@ -0,0 +1,167 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// importer fetches /snapshot.json from another instance into a local room.
+type importer struct {
+	client   *http.Client
+	maxBytes int64
+	wire     wireEncoding
+}
+
+func newImporter(timeout time.Duration, maxBytes int64, wire wireEncoding) *importer {
+	return &importer{client: &http.Client{Timeout: timeout}, maxBytes: maxBytes, wire: wire}
+}
+
+func (im *importer) fetch(from string) (RoomSnapshot, error) {
+	var snap RoomSnapshot
+	resp, err := im.client.Get(from)
+	if err != nil {
+		return snap, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return snap, fmt.Errorf("%s answered %s", from, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, im.maxBytes+1))
+	if err != nil {
+		return snap, err
+	}
+	if int64(len(data)) > im.maxBytes {
+		return snap, fmt.Errorf("snapshot exceeds %d bytes", im.maxBytes)
+	}
+	if err := im.wire.unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("decode snapshot: %v", err)
+	}
+	return snap, nil
+}
+
+type importSummary struct {
+	From     string `json:"from"`
+	Room     string `json:"room"`
+	Imported int    `json:"imported"`
+	Skipped  int    `json:"skipped"`
+	Invalid  int    `json:"invalid"`
+}
+
+// importHandler serves POST /admin/import?from=<url>&room=<name>. The
+// fetched points go through the local room's rules like any other add, so
+// duplicates are skipped and the room's transform is applied.
+func (m *roomManager) importHandler(im *importer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+			return
+		}
+		from := r.URL.Query().Get("from")
+		if u, err := url.Parse(from); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "from must be an http or https URL")
+			return
+		}
+		name, ok := roomFromRequest(w, r)
+		if !ok {
+			return
+		}
+		snap, err := im.fetch(from)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadGateway, errUpstream, err.Error())
+			return
+		}
+
+		h := m.acquire(name)
+		defer m.release(h)
+		writeJSONResponse(w, m.wire, http.StatusOK, h.importPoints(snap.Points, from, "import:"+from))
+	}
+}
+
+// importPoints adds ps as actor and broadcasts the ones added, counting the
+// invalid ones and those the room's rules skipped.
+func (h *Hub) importPoints(ps []Point, from, actor string) importSummary {
+	sum := importSummary{From: from, Room: h.room}
+	valid := make([]Point, 0, len(ps))
+	for _, p := range ps {
+		if validatePoint(p, h.meta) != nil {
+			sum.Invalid++
+			continue
+		}
+		valid = append(valid, p)
+	}
+	h.sequenced(func() {
+		stored, errs := h.addPointsEach(valid, actor)
+		added := make([]Point, 0, len(stored))
+		for i, p := range stored {
+			if errs[i] == nil {
+				added = append(added, p)
+			} else {
+				sum.Skipped++
+			}
+		}
+		sum.Imported = len(added)
+		h.broadcastAdded(added)
+	})
+	return sum
+}
+
+// uploadHandler serves POST /import?room=<name>&format=csv|json|ply|xyz,
+// adding the points of the file in the body like /admin/import does.
+func (m *roomManager) uploadHandler(maxBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		name, ok := roomFromRequest(w, r)
+		if !ok {
+			return
+		}
+		identity, _, ok := m.authorize(w, r, name, roleEditor)
+		if !ok {
+			return
+		}
+		format, ok := pointFormats[r.URL.Query().Get("format")]
+		if !ok {
+			writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "format must be csv, json, ply or xyz")
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			writeErrorResponse(w, http.StatusRequestEntityTooLarge, errBadRequest, err.Error())
+			return
+		}
+		ps, err := format.read(m.wire, data)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+			return
+		}
+		h := m.acquire(name)
+		defer m.release(h)
+		if h.config.ReadOnly {
+			writeErrorResponse(w, http.StatusForbidden, errReadOnly, "room is read-only")
+			return
+		}
+		actor := restActor(identity, r.RemoteAddr)
+		writeJSONResponse(w, m.wire, http.StatusOK, h.importPoints(ps, "upload", actor))
+	}
+}
+
+// broadcastAdded announces added points in addBatch frames no larger than
+// the room's batch limit.
+func (h *Hub) broadcastAdded(added []Point) {
+	size := len(added)
+	if h.maxBatch > 0 && h.maxBatch < size {
+		size = h.maxBatch
+	}
+	for start := 0; start < len(added); start += size {
+		end := start + size
+		if end > len(added) {
+			end = len(added)
+		}
+		h.broadcast(Message{Type: "addBatch", Points: added[start:end]})
+	}
+}
@@ -0,0 +1,66 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestImportFromAnotherInstance(t *testing.T) {
+	source := newTestServer(t)
+	source.Room("src", func(h *Hub) {
+		for _, x := range []float64{1, 2, 3} {
+			h.Add(Point{X: x}, "test")
+		}
+	})
+	ts := httptest.NewServer(source)
+	defer ts.Close()
+
+	target := newTestServer(t, WithAdminToken("s3cret", false), WithImport(5*time.Second, 1<<20))
+	target.Room("dst", func(h *Hub) { h.Add(Point{X: 2}, "test") })
+	post := func(from string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/import?room=dst&from="+url.QueryEscape(from), nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		target.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(ts.URL + "/snapshot.json?room=src")
+	var sum importSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &sum); err != nil {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	if rec.Code != http.StatusOK || sum.Imported != 2 || sum.Skipped != 1 || sum.Invalid != 0 {
+		t.Errorf("import: %d %+v, want 2 imported and the duplicate skipped", rec.Code, sum)
+	}
+	target.Room("dst", func(h *Hub) {
+		if n := h.pointCount(); n != 3 {
+			t.Errorf("room holds %d points after the import, want 3", n)
+		}
+	})
+
+	if rec := post("file:///etc/passwd"); rec.Code != http.StatusBadRequest {
+		t.Errorf("non-http source: %d", rec.Code)
+	}
+	if rec := post(ts.URL + "/nowhere"); rec.Code != http.StatusBadGateway {
+		t.Errorf("failing source: %d", rec.Code)
+	}
+}
+
+func TestImportRejectsOversizedSnapshots(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"room":"src","points":[{"x":1},{"x":2},{"x":3}]}`))
+	}))
+	defer ts.Close()
+	if _, err := newImporter(time.Second, 16, wireEncoding{}).fetch(ts.URL); err == nil {
+		t.Error("fetched a snapshot larger than the limit")
+	}
+	snap, err := newImporter(time.Second, 1<<10, wireEncoding{}).fetch(ts.URL)
+	if err != nil || len(snap.Points) != 3 {
+		t.Errorf("fetch = %+v, %v", snap, err)
+	}
+}
@@ -0,0 +1,40 @@
+package hub
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+)
+
+// ingest reads newline-delimited JSON points from r, adding and broadcasting
+// each valid one. Malformed lines are logged and skipped.
+func (h *Hub) ingest(r io.Reader, actor string) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	line, added := 0, 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var p Point
+		if err := h.wire.unmarshal(sc.Bytes(), &p); err != nil {
+			slog.Warn("skipping ingested line", "source", actor, "line", line, "err", err)
+			continue
+		}
+		if err := validatePoint(p, h.meta); err != nil {
+			slog.Warn("skipping ingested line", "source", actor, "line", line, "err", err)
+			continue
+		}
+		h.sequenced(func() {
+			if p, err := h.addPoint(p, actor); err == nil {
+				added++
+				h.broadcast(Message{Type: "add", Point: &p})
+			}
+		})
+	}
+	if err := sc.Err(); err != nil {
+		slog.Error("ingest read failed", "source", actor, "err", err)
+	}
+	slog.Info("ingest finished", "source", actor, "added", added, "lines", line)
+}
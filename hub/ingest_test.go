@@ -0,0 +1,30 @@
+package hub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIngestAddsValidLinesAndSkipsTheRest(t *testing.T) {
+	s := newTestServer(t)
+	input := strings.Join([]string{
+		`{"x":1,"y":2,"z":3}`,
+		``,
+		`not json`,
+		`{"x":4,"y":5,"z":6,"color":"red"}`,
+		`{"x":7,"y":8,"z":9,"weight":2}`,
+	}, "\n")
+	s.Ingest(strings.NewReader(input), "stdin")
+
+	s.Room(defaultRoom, func(h *Hub) {
+		ps := h.Points()
+		if len(ps) != 2 {
+			t.Fatalf("ingested %+v, want the two valid points", ps)
+		}
+		for _, p := range ps {
+			if p.Owner != "stdin" {
+				t.Errorf("point %+v not attributed to stdin", p)
+			}
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package hub
+
+import "sync/atomic"
+
+// pointLimits caps the points held across every room of the server and by
+// each owner within a room. The server-wide count is shared by all rooms and
+// checked without a global lock, so concurrent adds in different rooms may
+// overshoot it slightly.
+type pointLimits struct {
+	total    *atomic.Int64
+	maxTotal int64
+	perOwner int
+}
+
+// limitErr reports why p cannot be added under the limits, counting the
+// points staged by an atomic batch, which are all owned by p's owner. Must be
+// called with h.mu held.
+func (h *Hub) limitErr(p Point) *validationError {
+	l := h.limits
+	if l.maxTotal > 0 && l.total.Load()+int64(h.staging) >= l.maxTotal {
+		return invalid(errLimitExceeded, "server holds its maximum of %d points", l.maxTotal)
+	}
+	if l.perOwner > 0 && h.owned[p.Owner]+h.staging >= l.perOwner {
+		return invalid(errLimitExceeded, "owner already has the maximum of %d points in this room", l.perOwner)
+	}
+	return nil
+}
+
+// countPoint adjusts the counts behind the limits by delta for p. Must be
+// called with h.mu held.
+func (h *Hub) countPoint(p Point, delta int) {
+	if h.limits.total != nil {
+		h.limits.total.Add(int64(delta))
+	}
+	if h.owned != nil {
+		if h.owned[p.Owner] += delta; h.owned[p.Owner] <= 0 {
+			delete(h.owned, p.Owner)
+		}
+	}
+}
+
+// countAll recounts the room after its points were replaced, given how many
+// it held before. Must be called with h.mu held.
+func (h *Hub) countAll(before int) {
+	if h.limits.total != nil {
+		h.limits.total.Add(int64(len(h.points) - before))
+	}
+	if h.owned != nil {
+		h.owned = make(map[string]int)
+		for _, p := range h.points {
+			h.owned[p.Owner]++
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package hub
+
+import "time"
+
+// pointLock reserves a point for editing by one connection. key follows the
+// point when a move changes it.
+type pointLock struct {
+	key     string
+	owner   string
+	expires time.Time
+	timer   *time.Timer
+}
+
+type lockState struct {
+	Point     Point  `json:"point"`
+	Owner     string `json:"owner"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+// lockErr rejects a mutation by actor of the point under key while another
+// connection holds its lock. Must be called with h.mu held.
+func (h *Hub) lockErr(key, actor string) *validationError {
+	if l, ok := h.locks[key]; ok && l.owner != actor {
+		return invalid(errLocked, "point is locked by %s", l.owner)
+	}
+	return nil
+}
+
+// lockPoint reserves the point with the same key as p for owner, or renews
+// the reservation owner already holds.
+func (h *Hub) lockPoint(p Point, owner string) (lockState, *validationError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(p)
+	stored, exists := h.points[key]
+	if !exists {
+		return lockState{}, invalid(errNotFound, "no such point")
+	}
+	if err := h.lockErr(key, owner); err != nil {
+		return lockState{}, err
+	}
+	l, ok := h.locks[key]
+	if !ok {
+		l = &pointLock{key: key, owner: owner}
+		h.locks[key] = l
+	}
+	if h.lockTimeout > 0 {
+		l.expires = time.Now().Add(h.lockTimeout)
+		if l.timer != nil {
+			l.timer.Stop()
+		}
+		l.timer = time.AfterFunc(h.lockTimeout, func() { h.expireLock(l) })
+	}
+	return h.lockStateOf(l, stored), nil
+}
+
+func (h *Hub) lockStateOf(l *pointLock, p Point) lockState {
+	s := lockState{Point: p, Owner: l.owner}
+	if !l.expires.IsZero() {
+		s.ExpiresAt = l.expires.UnixMilli()
+	}
+	return s
+}
+
+// unlockPoint releases owner's lock on the point with the same key as p.
+func (h *Hub) unlockPoint(p Point, owner string) (Point, *validationError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(p)
+	l, ok := h.locks[key]
+	if !ok {
+		return Point{}, invalid(errNotFound, "point is not locked")
+	}
+	if l.owner != owner {
+		return Point{}, invalid(errLocked, "point is locked by %s", l.owner)
+	}
+	h.dropLock(l)
+	return h.points[key], nil
+}
+
+// releaseLocks drops every lock held by owner and returns the points they
+// were on.
+func (h *Hub) releaseLocks(owner string) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []Point
+	for key, l := range h.locks {
+		if l.owner != owner {
+			continue
+		}
+		h.dropLock(l)
+		if p, ok := h.points[key]; ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// dropLock must be called with h.mu held.
+func (h *Hub) dropLock(l *pointLock) {
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	delete(h.locks, l.key)
+}
+
+func (h *Hub) expireLock(l *pointLock) {
+	h.mu.Lock()
+	if h.locks[l.key] != l || time.Now().Before(l.expires) {
+		h.mu.Unlock()
+		return
+	}
+	h.dropLock(l)
+	p, ok := h.points[l.key]
+	h.mu.Unlock()
+	if ok {
+		h.broadcast(Message{Type: "unlock", Point: &p})
+	}
+}
+
+// dropLocksOf releases the locks on removed points. Clients drop them along
+// with the points, so nothing is broadcast.
+func (h *Hub) dropLocksOf(removed []Point) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range removed {
+		if l, ok := h.locks[h.key(p)]; ok {
+			h.dropLock(l)
+		}
+	}
+}
+
+func (h *Hub) lockStates() []lockState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]lockState, 0, len(h.locks))
+	for key, l := range h.locks {
+		if p, ok := h.points[key]; ok {
+			out = append(out, h.lockStateOf(l, p))
+		}
+	}
+	return out
+}
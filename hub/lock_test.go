@@ -0,0 +1,61 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockReservesAPointForItsOwner(t *testing.T) {
+	h := newHub("test")
+	alice, _ := testClient(h)
+	bob, bobConn := testClient(h)
+	p := Point{X: 1}
+	send(t, h, alice, Message{Type: "add", Point: &p})
+	send(t, h, alice, Message{Type: "lock", Point: &p})
+	if locks := bobConn.ofType(t, "lock"); len(locks) != 1 || locks[0].Owner != alice.id || locks[0].ExpiresAt == 0 {
+		t.Fatalf("bob saw locks %+v", locks)
+	}
+
+	send(t, h, bob, Message{Type: "move", From: &p, To: &Point{X: 2}})
+	if code := errorCode(t, bobConn); code != errLocked {
+		t.Errorf("move of a locked point: error %q, want %q", code, errLocked)
+	}
+	send(t, h, bob, Message{Type: "lock", Point: &p})
+	if code := errorCode(t, bobConn); code != errLocked {
+		t.Errorf("locking a locked point: error %q, want %q", code, errLocked)
+	}
+
+	// The owner may still edit it, and the lock follows the move.
+	send(t, h, alice, Message{Type: "move", From: &p, To: &Point{X: 2}})
+	send(t, h, bob, Message{Type: "remove", Point: &Point{X: 2}})
+	if code := errorCode(t, bobConn); code != errLocked {
+		t.Errorf("remove after the owner moved it: error %q, want %q", code, errLocked)
+	}
+
+	send(t, h, alice, Message{Type: "unlock", Point: &Point{X: 2}})
+	send(t, h, bob, Message{Type: "remove", Point: &Point{X: 2}})
+	if code := errorCode(t, bobConn); code != "" || len(h.points) != 0 {
+		t.Errorf("remove after unlock: error %q, points %+v", code, h.points)
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	h := newHub("test")
+	h.lockTimeout = 20 * time.Millisecond
+	alice, _ := testClient(h)
+	_, bobConn := testClient(h)
+	p := Point{X: 1}
+	send(t, h, alice, Message{Type: "add", Point: &p})
+	send(t, h, alice, Message{Type: "lock", Point: &p})
+
+	time.Sleep(60 * time.Millisecond)
+	if unlocks := bobConn.ofType(t, "unlock"); len(unlocks) != 1 {
+		t.Errorf("got %d unlock broadcasts after the timeout, want 1", len(unlocks))
+	}
+	h.mu.Lock()
+	n := len(h.locks)
+	h.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d locks left after the timeout", n)
+	}
+}
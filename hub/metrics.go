@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the broadcast latency
+// histogram.
+var latencyBuckets = [...]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+type histogram struct {
+	counts [len(latencyBuckets) + 1]atomic.Uint64
+	sumNs  atomic.Uint64
+}
+
+func (hg *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := 0
+	for i < len(latencyBuckets) && s > latencyBuckets[i] {
+		i++
+	}
+	hg.counts[i].Add(1)
+	hg.sumNs.Add(uint64(d))
+}
+
+// serverMetrics holds the counters behind /metrics and /stats, summed over
+// every room of a server.
+type serverMetrics struct {
+	received         atomic.Uint64
+	broadcasts       atomic.Uint64
+	droppedClients   atomic.Uint64
+	broadcastLatency histogram
+
+	// Bytes written since start, split by whether compression was
+	// negotiated.
+	compressed, plain byteTotals
+}
+
+func (sm *serverMetrics) totalsFor(compressed bool) *byteTotals {
+	if compressed {
+		return &sm.compressed
+	}
+	return &sm.plain
+}
+
+// metricsHandler serves the counters and the current connection and point
+// totals in the Prometheus text exposition format.
+func (m *roomManager) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var conns, points int
+	hubs := m.hubs()
+	for _, h := range hubs {
+		h.mu.Lock()
+		conns += len(h.conns)
+		points += len(h.points)
+		h.mu.Unlock()
+	}
+
+	var b strings.Builder
+	metric := func(name, kind, help string, v interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, v)
+	}
+	metric("universe_rooms", "gauge", "Rooms currently loaded.", len(hubs))
+	metric("universe_connections", "gauge", "Open WebSocket connections.", conns)
+	metric("universe_points", "gauge", "Points stored across all rooms.", points)
+	metrics := m.metrics
+	metric("universe_messages_received_total", "counter", "WebSocket messages received from clients.", metrics.received.Load())
+	metric("universe_messages_broadcast_total", "counter", "Messages broadcast to rooms.", metrics.broadcasts.Load())
+	metric("universe_dropped_clients_total", "counter", "Connections dropped for a full send queue, slow writes or a failed broadcast write.", metrics.droppedClients.Load())
+
+	const latency = "universe_broadcast_latency_seconds"
+	fmt.Fprintf(&b, "# HELP %s Time to encode a broadcast and hand it to every recipient.\n# TYPE %s histogram\n", latency, latency)
+	var cumulative uint64
+	for i, le := range latencyBuckets {
+		cumulative += metrics.broadcastLatency.counts[i].Load()
+		fmt.Fprintf(&b, "%s_bucket{le=\"%g\"} %d\n", latency, le, cumulative)
+	}
+	cumulative += metrics.broadcastLatency.counts[len(latencyBuckets)].Load()
+	fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %d\n", latency, cumulative)
+	fmt.Fprintf(&b, "%s_sum %g\n%s_count %d\n", latency, time.Duration(metrics.broadcastLatency.sumNs.Load()).Seconds(), latency, cumulative)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
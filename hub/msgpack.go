@@ -0,0 +1,295 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// wireFormat is the encoding of the frames a client exchanges. JSON clients
+// use text frames; MessagePack clients use binary frames carrying the same
+// documents, with keys cased as for JSON.
+type wireFormat int
+
+const (
+	formatJSON wireFormat = iota
+	formatMsgpack
+	formatCount
+)
+
+func (f wireFormat) frameType() int {
+	if f == formatMsgpack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// encode converts a document already encoded by marshalWire.
+func (f wireFormat) encode(data []byte) ([]byte, error) {
+	if f == formatMsgpack {
+		return jsonToMsgpack(data)
+	}
+	return data, nil
+}
+
+// requestFormat picks the format a WebSocket request asks for with
+// ?format=msgpack or the msgpack subprotocol, which the upgrader then
+// confirms.
+func requestFormat(r *http.Request) (wireFormat, error) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "msgpack":
+		return formatMsgpack, nil
+	default:
+		return formatJSON, errors.New(`format must be "json" or "msgpack"`)
+	}
+	for _, p := range websocket.Subprotocols(r) {
+		if p == "msgpack" {
+			return formatMsgpack, nil
+		}
+	}
+	return formatJSON, nil
+}
+
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(make([]byte, 0, len(data)), v)
+}
+
+func appendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), u), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f)), nil
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			b = append(b, 0xa0|byte(n))
+		case n <= math.MaxUint8:
+			b = append(b, 0xd9, byte(n))
+		case n <= math.MaxUint16:
+			b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+		default:
+			b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+		}
+		return append(b, v...), nil
+	case []interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x90, 0xdc)
+		var err error
+		for _, item := range v {
+			if b, err = appendMsgpack(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x80, 0xde)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// Sorted so that equal documents encode identically.
+		sort.Strings(keys)
+		var err error
+		for _, k := range keys {
+			if b, err = appendMsgpack(b, k); err != nil {
+				return nil, err
+			}
+			if b, err = appendMsgpack(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cannot encode %T as msgpack", v)
+}
+
+// appendMsgpackHeader writes an array or map header: fix is the fixarray or
+// fixmap prefix and wide the 16-bit form, followed by the 32-bit one.
+func appendMsgpackHeader(b []byte, n int, fix, wide byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, wide), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, wide+1), uint32(n))
+	}
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128, i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+var errMsgpackTruncated = errors.New("msgpack frame truncated")
+
+// msgpackToJSON converts a MessagePack document to JSON so that it can be
+// read with unmarshalWire. Map keys must be strings.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	v, rest, err := readMsgpack(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing bytes after msgpack document")
+	}
+	return json.Marshal(v)
+}
+
+// maxMsgpackDepth bounds nesting so a hostile frame cannot exhaust the stack.
+const maxMsgpackDepth = 32
+
+// msgpackSizes is the width of the value or length that follows each
+// supported type byte outside the fix ranges.
+var msgpackSizes = map[byte]int{
+	0xca: 4, 0xcb: 8,
+	0xcc: 1, 0xcd: 2, 0xce: 4, 0xcf: 8,
+	0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8,
+	0xd9: 1, 0xda: 2, 0xdb: 4,
+	0xdc: 2, 0xdd: 4, 0xde: 2, 0xdf: 4,
+}
+
+func readMsgpack(b []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxMsgpackDepth {
+		return nil, nil, errors.New("msgpack nested too deeply")
+	}
+	if len(b) == 0 {
+		return nil, nil, errMsgpackTruncated
+	}
+	c, b := b[0], b[1:]
+	switch {
+	case c <= 0x7f:
+		return int64(c), b, nil
+	case c >= 0xe0:
+		return int64(int8(c)), b, nil
+	case c&0xf0 == 0x80:
+		return readMsgpackMap(b, int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return readMsgpackArray(b, int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return readMsgpackString(b, int(c&0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	}
+	n, ok := msgpackSizes[c]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported msgpack type 0x%02x", c)
+	}
+	if len(b) < n {
+		return nil, nil, errMsgpackTruncated
+	}
+	var u uint64
+	for _, x := range b[:n] {
+		u = u<<8 | uint64(x)
+	}
+	b = b[n:]
+	switch c {
+	case 0xca:
+		return float64(math.Float32frombits(uint32(u))), b, nil
+	case 0xcb:
+		return math.Float64frombits(u), b, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return u, b, nil
+	case 0xd0:
+		return int64(int8(u)), b, nil
+	case 0xd1:
+		return int64(int16(u)), b, nil
+	case 0xd2:
+		return int64(int32(u)), b, nil
+	case 0xd3:
+		return int64(u), b, nil
+	case 0xd9, 0xda, 0xdb:
+		return readMsgpackString(b, int(u))
+	case 0xdc, 0xdd:
+		return readMsgpackArray(b, int(u), depth)
+	}
+	return readMsgpackMap(b, int(u), depth)
+}
+
+func readMsgpackString(b []byte, n int) (interface{}, []byte, error) {
+	if n < 0 || len(b) < n {
+		return nil, nil, errMsgpackTruncated
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func readMsgpackArray(b []byte, n, depth int) (interface{}, []byte, error) {
+	if n < 0 || n > len(b) {
+		return nil, nil, errMsgpackTruncated
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		var err error
+		if items[i], b, err = readMsgpack(b, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return items, b, nil
+}
+
+func readMsgpackMap(b []byte, n, depth int) (interface{}, []byte, error) {
+	if n < 0 || 2*n > len(b) {
+		return nil, nil, errMsgpackTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, rest, err := readMsgpack(b, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, nil, errors.New("msgpack map keys must be strings")
+		}
+		if m[key], b, err = readMsgpack(rest, depth+1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return m, b, nil
+}
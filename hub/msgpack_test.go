@@ -0,0 +1,306 @@
+package hub
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// specDecode is a reference MessagePack decoder written from the
+// specification's format table, independent of readMsgpack. Integers decode
+// as int64, or uint64 above math.MaxInt64.
+func specDecode(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("empty input")
+	}
+	take := func(n int) ([]byte, error) {
+		if len(b) < 1+n {
+			return nil, fmt.Errorf("need %d bytes after 0x%02x, have %d", n, b[0], len(b)-1)
+		}
+		return b[1 : 1+n], nil
+	}
+	be := func(p []byte) uint64 {
+		var u uint64
+		for _, x := range p {
+			u = u<<8 | uint64(x)
+		}
+		return u
+	}
+	t := b[0]
+	var (
+		size, length int
+		kind         string
+	)
+	switch {
+	case t <= 0x7f:
+		return int64(t), b[1:], nil
+	case t >= 0xe0:
+		return int64(int8(t)), b[1:], nil
+	case t >= 0x80 && t <= 0x8f:
+		kind, length = "map", int(t&0x0f)
+	case t >= 0x90 && t <= 0x9f:
+		kind, length = "array", int(t&0x0f)
+	case t >= 0xa0 && t <= 0xbf:
+		kind, length = "str", int(t&0x1f)
+	case t == 0xc0:
+		return nil, b[1:], nil
+	case t == 0xc2:
+		return false, b[1:], nil
+	case t == 0xc3:
+		return true, b[1:], nil
+	case t == 0xcb:
+		p, err := take(8)
+		if err != nil {
+			return nil, nil, err
+		}
+		return math.Float64frombits(be(p)), b[9:], nil
+	case t >= 0xcc && t <= 0xcf:
+		n := 1 << (t - 0xcc)
+		p, err := take(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		u := be(p)
+		if u > math.MaxInt64 {
+			return u, b[1+n:], nil
+		}
+		return int64(u), b[1+n:], nil
+	case t >= 0xd0 && t <= 0xd3:
+		n := 1 << (t - 0xd0)
+		p, err := take(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		shift := 64 - 8*n
+		return int64(be(p)<<shift) >> shift, b[1+n:], nil
+	case t >= 0xd9 && t <= 0xdb:
+		kind, size = "str", 1<<(t-0xd9)
+	case t == 0xdc || t == 0xdd:
+		kind, size = "array", 2<<(t-0xdc)
+	case t == 0xde || t == 0xdf:
+		kind, size = "map", 2<<(t-0xde)
+	default:
+		return nil, nil, fmt.Errorf("type 0x%02x not expected from the encoder", t)
+	}
+	rest := b[1:]
+	if size > 0 {
+		p, err := take(size)
+		if err != nil {
+			return nil, nil, err
+		}
+		length, rest = int(be(p)), b[1+size:]
+	}
+	switch kind {
+	case "str":
+		if len(rest) < length {
+			return nil, nil, fmt.Errorf("string of %d bytes truncated", length)
+		}
+		return string(rest[:length]), rest[length:], nil
+	case "array":
+		items := make([]interface{}, length)
+		for i := range items {
+			var err error
+			if items[i], rest, err = specDecode(rest); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, rest, nil
+	}
+	m := make(map[string]interface{}, length)
+	for i := 0; i < length; i++ {
+		k, after, err := specDecode(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("map key %v is not a string", k)
+		}
+		if m[key], rest, err = specDecode(after); err != nil {
+			return nil, nil, err
+		}
+	}
+	return m, rest, nil
+}
+
+func mustSpecDecode(t *testing.T, b []byte) interface{} {
+	t.Helper()
+	v, rest, err := specDecode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) > 0 {
+		t.Fatalf("%d bytes left over", len(rest))
+	}
+	return v
+}
+
+func stringMap(n int) map[string]interface{} {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		m["k"+strconv.Itoa(i)] = int64(i)
+	}
+	return m
+}
+
+func trues(n int) []interface{} {
+	items := make([]interface{}, n)
+	for i := range items {
+		items[i] = true
+	}
+	return items
+}
+
+func TestMsgpackMatchesReferenceDecoder(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		value  interface{}
+		header []byte
+	}{
+		{"zero", int64(0), []byte{0x00}},
+		{"positive fixint max", int64(127), []byte{0x7f}},
+		{"past positive fixint", int64(128), []byte{0xd1, 0x00, 0x80}},
+		{"negative fixint min", int64(-32), []byte{0xe0}},
+		{"past negative fixint", int64(-33), []byte{0xd0, 0xdf}},
+		{"int8 min", int64(math.MinInt8), []byte{0xd0, 0x80}},
+		{"int16", int64(math.MinInt8 - 1), []byte{0xd1}},
+		{"int16 max", int64(math.MaxInt16), []byte{0xd1, 0x7f, 0xff}},
+		{"int32", int64(math.MaxInt16 + 1), []byte{0xd2}},
+		{"int32 min", int64(math.MinInt32), []byte{0xd2}},
+		{"int64", int64(math.MaxInt32 + 1), []byte{0xd3}},
+		{"int64 min", int64(math.MinInt64), []byte{0xd3}},
+		{"uint64", uint64(math.MaxUint64), []byte{0xcf}},
+		{"float", 0.5, []byte{0xcb}},
+		{"negative float", -1.25e300, []byte{0xcb}},
+		{"smallest float", math.SmallestNonzeroFloat64, []byte{0xcb}},
+		{"empty string", "", []byte{0xa0}},
+		{"fixstr max", strings.Repeat("a", 31), []byte{0xbf}},
+		{"str8", strings.Repeat("a", 32), []byte{0xd9, 32}},
+		{"str8 max", strings.Repeat("a", math.MaxUint8), []byte{0xd9, 0xff}},
+		{"str16", strings.Repeat("a", math.MaxUint8+1), []byte{0xda, 0x01, 0x00}},
+		{"str16 max", strings.Repeat("a", math.MaxUint16), []byte{0xda, 0xff, 0xff}},
+		{"str32", strings.Repeat("a", math.MaxUint16+1), []byte{0xdb, 0x00, 0x01, 0x00, 0x00}},
+		{"multibyte string", strings.Repeat("é", 16), []byte{0xd9, 32}},
+		{"empty map", map[string]interface{}{}, []byte{0x80}},
+		{"fixmap max", stringMap(15), []byte{0x8f}},
+		{"map16", stringMap(16), []byte{0xde, 0x00, 0x10}},
+		{"empty array", []interface{}{}, []byte{0x90}},
+		{"fixarray max", trues(15), []byte{0x9f}},
+		{"array16", trues(16), []byte{0xdc, 0x00, 0x10}},
+		{"array32", trues(math.MaxUint16 + 1), []byte{0xdd, 0x00, 0x01, 0x00, 0x00}},
+		{"nil", nil, []byte{0xc0}},
+		{"nested", map[string]interface{}{"a": []interface{}{nil, false, map[string]interface{}{"b": "c"}}}, []byte{0x81}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := json.Marshal(tc.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := jsonToMsgpack(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) < len(tc.header) || !reflect.DeepEqual(b[:len(tc.header)], tc.header) {
+				t.Fatalf("encoded as % x..., want header % x", b[:min(len(b), 8)], tc.header)
+			}
+			if got := mustSpecDecode(t, b); !reflect.DeepEqual(got, tc.value) {
+				t.Fatalf("reference decoder read %#v", got)
+			}
+			back, err := msgpackToJSON(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(back) != string(doc) {
+				t.Fatalf("round trip gave %.80s, want %.80s", back, doc)
+			}
+		})
+	}
+}
+
+func TestMsgpackNilPointerFields(t *testing.T) {
+	doc, err := json.Marshal(struct {
+		Point    *Point    `json:"point"`
+		From     *Point    `json:"from,omitempty"`
+		Velocity *[3]int64 `json:"velocity"`
+		Points   []Point   `json:"points"`
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := jsonToMsgpack(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"point": nil, "velocity": nil, "points": nil}
+	if got := mustSpecDecode(t, b); !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded %#v, want %#v", got, want)
+	}
+
+	msg := Message{Type: "move", From: &Point{X: 1}}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = jsonToMsgpack(data); err != nil {
+		t.Fatal(err)
+	}
+	decoded := mustSpecDecode(t, b).(map[string]interface{})
+	if _, ok := decoded["point"]; ok {
+		t.Errorf("nil Point was encoded: %#v", decoded)
+	}
+	from, ok := decoded["from"].(map[string]interface{})
+	if !ok || from["x"] != int64(1) {
+		t.Errorf("from = %#v", decoded["from"])
+	}
+	if _, ok := from["velocity"]; ok {
+		t.Errorf("nil velocity was encoded: %#v", from)
+	}
+}
+
+func TestMsgpackDecodesWiderForms(t *testing.T) {
+	// Other encoders may pick wider headers or float32; all must be read.
+	for _, tc := range []struct {
+		in   []byte
+		want interface{}
+	}{
+		{[]byte{0xcc, 0xff}, uint64(255)},
+		{[]byte{0xcd, 0x01, 0x00}, uint64(256)},
+		{[]byte{0xca, 0x3f, 0x00, 0x00, 0x00}, 0.5},
+		{[]byte{0xd9, 0x01, 'x'}, "x"},
+		{[]byte{0xdc, 0x00, 0x01, 0xc3}, []interface{}{true}},
+		{[]byte{0xdf, 0x00, 0x00, 0x00, 0x01, 0xa1, 'k', 0xc0}, map[string]interface{}{"k": nil}},
+	} {
+		got, rest, err := readMsgpack(tc.in, 0)
+		if err != nil || len(rest) > 0 || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("% x = %#v, %v, rest % x; want %#v", tc.in, got, err, rest, tc.want)
+		}
+	}
+}
+
+func TestMsgpackRejectsMalformedInput(t *testing.T) {
+	long := binary.BigEndian.AppendUint32([]byte{0xdb}, 1<<31)
+	for _, in := range [][]byte{
+		{},
+		{0xd9},
+		{0xd9, 0x05, 'a'},
+		{0xa3, 'a'},
+		{0x92, 0xc0},
+		{0x81, 0x01, 0xc0},
+		{0xc1},
+		long,
+		append(make([]byte, 0), 0xc0, 0xc0),
+	} {
+		if _, err := msgpackToJSON(in); err == nil {
+			t.Errorf("% x was accepted", in)
+		}
+	}
+	deep := []byte(strings.Repeat("\x91", maxMsgpackDepth+2) + "\xc0")
+	if _, err := msgpackToJSON(deep); err == nil {
+		t.Error("accepted nesting deeper than maxMsgpackDepth")
+	}
+}
@@ -0,0 +1,104 @@
+package hub
+
+import (
+	"log/slog"
+	"time"
+)
+
+const mutationBuffer = 256
+
+// Mutation describes one successful change to a room's points. For moves,
+// From holds the point as it was before and Point its new state.
+type Mutation struct {
+	Type  string
+	Room  string
+	Point Point
+	From  *Point
+	Actor string
+	Seq   uint64
+	Time  time.Time
+	// Remote marks mutations made on another instance and applied here
+	// from the backplane.
+	Remote bool
+}
+
+// OnMutation registers fn to be called after every successful add, remove or
+// update or move. Callbacks run on a dedicated goroutine, outside the hub lock and in
+// sequence order; if they fall more than mutationBuffer changes behind, the
+// excess mutations are dropped rather than stalling the hub.
+func (h *Hub) OnMutation(fn func(Mutation)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.observers = append(h.observers, fn)
+	if h.mutations == nil {
+		h.mutations = make(chan Mutation, mutationBuffer)
+		go h.dispatchMutations(h.mutations)
+	}
+}
+
+func (h *Hub) dispatchMutations(mutations <-chan Mutation) {
+	for m := range mutations {
+		h.mu.Lock()
+		observers := h.observers
+		h.mu.Unlock()
+		for _, fn := range observers {
+			fn(m)
+		}
+	}
+}
+
+// emit assigns the next sequence number to a mutation and hands it to the
+// audit log and observers. Must be called with h.mu held so that sequence
+// numbers follow the order in which changes were applied.
+func (h *Hub) emit(m Mutation) {
+	h.seq++
+	m.Room, m.Seq, m.Time = h.room, h.seq, time.Now()
+	slog.Debug("mutation", "conn", m.Actor, "room", m.Room, "type", m.Type, "seq", m.Seq)
+	h.audit.record(m)
+	if h.bounds != nil {
+		h.trackBounds(m)
+	}
+	if h.grid != nil {
+		h.trackGrid(m)
+	}
+	h.trackChanges(m)
+	switch m.Type {
+	case "add":
+		h.countPoint(m.Point, 1)
+	case "remove":
+		h.countPoint(m.Point, -1)
+	}
+	if h.history != nil {
+		h.history.record(m)
+	}
+	if h.storage != nil {
+		h.storage.markDirty(h)
+	}
+	if h.publish != nil && !m.Remote {
+		h.publish(m)
+	}
+	if h.mutations != nil {
+		select {
+		case h.mutations <- m:
+		default:
+			slog.Warn("mutation observers are behind, dropping mutation", "room", h.room, "seq", m.Seq)
+		}
+	}
+}
+
+// close saves a hub that is being discarded and stops its observer
+// goroutine.
+func (h *Hub) close() {
+	if h.storage != nil {
+		h.storage.stop(h)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.limits.total != nil {
+		h.limits.total.Add(-int64(len(h.points)))
+	}
+	if h.mutations != nil {
+		close(h.mutations)
+		h.mutations = nil
+	}
+}
@@ -0,0 +1,31 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnMutationSeesChangesInOrder(t *testing.T) {
+	h := newHub("test")
+	got := make(chan Mutation, 8)
+	h.OnMutation(func(m Mutation) { got <- m })
+	c, _ := testClient(h)
+
+	from, to := Point{X: 1}, Point{X: 2}
+	send(t, h, c, Message{Type: "add", Point: &from})
+	send(t, h, c, Message{Type: "move", From: &from, To: &to})
+	send(t, h, c, Message{Type: "remove", Point: &to})
+	for i, want := range []string{"add", "move", "remove"} {
+		select {
+		case m := <-got:
+			if m.Type != want || m.Seq != uint64(i+1) || m.Room != "test" {
+				t.Errorf("mutation %d = %+v, want %s at seq %d", i, m, want, i+1)
+			}
+			if m.Type == "move" && (m.From == nil || m.From.X != 1 || m.Point.X != 2) {
+				t.Errorf("move mutation = %+v, want from x=1 to x=2", m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s mutation observed", want)
+		}
+	}
+}
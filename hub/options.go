@@ -0,0 +1,466 @@
+package hub
+
+import "time"
+
+// settings is everything a Server is configured with. Its zero-argument
+// defaults are those of the universe command's flags.
+type settings struct {
+	staticDir       string
+	allowedOrigins  string
+	auditPath       string
+	auditBuffer     int
+	webhookURLs     string
+	webhookSecret   string
+	webhookBatch    int
+	webhookInterval time.Duration
+	maxBatch        int
+	maxGenerate     int
+	maxMessageBytes int64
+	idMode          bool
+	keyDecimals     int
+	checkInterval   time.Duration
+	compactInterval time.Duration
+	maxLifetime     time.Duration
+	lifetimeJitter  time.Duration
+	initChunkSize   int
+	maxInitBytes    int64
+	acceptDepth     int
+	compress        bool
+	compressMin     int
+	slowWrites      slowWritePolicy
+	flood           floodPolicy
+	sendQueue       int
+	reconnect       reconnectWindow
+	writeTimeout    time.Duration
+	readTimeout     time.Duration
+	idemTTL         time.Duration
+	idemSize        int
+	broadcastRate   float64
+	broadcastTick   time.Duration
+	moveQuantum     float64
+	moveWindow      time.Duration
+	boundsInterval  time.Duration
+	boundsThreshold float64
+	minDistance     float64
+	roomMinDistance map[string]float64
+	staleAfter      time.Duration
+	lockTimeout     time.Duration
+	pointTTL        time.Duration
+	maxTotalPoints  int64
+	maxOwnerPoints  int
+	expiryInterval  time.Duration
+	simStep         time.Duration
+	recordDir       string
+	recordInterval  time.Duration
+	recordKeep      int
+	recordMaxAge    time.Duration
+	simPublish      time.Duration
+	tombstoneTTL    time.Duration
+	tombstoneLimit  int
+	roomConfigPath  string
+	dataDir         string
+	storage         func(room string) Storage
+	persistInterval time.Duration
+	roomTTL         time.Duration
+	roomDir         string
+	preloadRooms    bool
+	startupDelay    time.Duration
+	queueUntilReady bool
+	unloadAfter     time.Duration
+	transform       string
+	roomTransforms  map[string]string
+	logSample       time.Duration
+	debug           bool
+	adminToken      string
+	coordDecimals   int
+	metaKeys        int
+	metaBytes       int
+	strict          bool
+	signalRetention time.Duration
+	signalLimit     int
+	aclPath         string
+	jwtSecret       string
+	jwtKeys         string
+	anonymous       string
+	syncHistory     int
+	ownerRemoves    bool
+	presenceEvery   time.Duration
+	undoDepth       int
+	backplaneURL    string
+	backplaneTopic  string
+	jsonCase        string
+	importTimeout   time.Duration
+	maxImportBytes  int64
+	chatHistory     int
+	chatLength      int
+	chatRate        floodPolicy
+}
+
+func defaultSettings() settings {
+	return settings{
+		auditBuffer:     1024,
+		webhookBatch:    100,
+		webhookInterval: time.Second,
+		maxBatch:        10000,
+		maxGenerate:     100000,
+		keyDecimals:     defaultKeyDecimals,
+		maxMessageBytes: 4 << 20,
+		checkInterval:   30 * time.Second,
+		compactInterval: time.Minute,
+		lifetimeJitter:  time.Minute,
+		initChunkSize:   5000,
+		acceptDepth:     1024,
+		compress:        true,
+		compressMin:     512,
+		slowWrites:      slowWritePolicy{strikes: 5, recoverAfter: 20},
+		flood:           floodPolicy{burst: 50, disconnectAfter: 100},
+		sendQueue:       256,
+		reconnect:       reconnectWindow{min: time.Second, max: 10 * time.Second},
+		writeTimeout:    10 * time.Second,
+		readTimeout:     75 * time.Second,
+		idemTTL:         10 * time.Minute,
+		idemSize:        10000,
+		moveQuantum:     0.001,
+		boundsThreshold: 0.01,
+		roomMinDistance: map[string]float64{},
+		staleAfter:      45 * time.Second,
+		lockTimeout:     30 * time.Second,
+		expiryInterval:  time.Second,
+		tombstoneTTL:    10 * time.Minute,
+		tombstoneLimit:  10000,
+		persistInterval: time.Second,
+		roomTTL:         10 * time.Minute,
+		unloadAfter:     30 * time.Minute,
+		roomTransforms:  map[string]string{},
+		logSample:       10 * time.Second,
+		coordDecimals:   -1,
+		metaKeys:        32,
+		metaBytes:       4096,
+		strict:          true,
+		signalLimit:     100,
+		anonymous:       "full",
+		syncHistory:     10000,
+		presenceEvery:   100 * time.Millisecond,
+		undoDepth:       50,
+		backplaneTopic:  "universe",
+		jsonCase:        "camel",
+		importTimeout:   30 * time.Second,
+		maxImportBytes:  64 << 20,
+		chatHistory:     50,
+		chatLength:      500,
+		chatRate:        floodPolicy{rate: 1, burst: 5},
+	}
+}
+
+// Option configures a Server.
+type Option func(*settings)
+
+// WithStaticDir serves the files of dir at /. Nothing is served there by
+// default.
+func WithStaticDir(dir string) Option { return func(s *settings) { s.staticDir = dir } }
+
+// WithAllowedOrigins limits WebSocket handshakes and cross-origin REST calls
+// to a comma-separated list of origins such as https://*.example.com, host
+// names such as example.com, which pass on any scheme and port, or * for
+// any; by default only pages from the server's own host name pass.
+func WithAllowedOrigins(list string) Option { return func(s *settings) { s.allowedOrigins = list } }
+
+// WithAuditLog appends a JSON line per mutation to path, buffering up to
+// buffer entries before dropping new ones.
+func WithAuditLog(path string, buffer int) Option {
+	return func(s *settings) { s.auditPath, s.auditBuffer = path, buffer }
+}
+
+// WithWebhooks POSTs mutations as JSON to each of a comma-separated list of
+// URLs, in batches of up to batch sent at most interval after their first
+// mutation.
+func WithWebhooks(urls string, batch int, interval time.Duration) Option {
+	return func(s *settings) { s.webhookURLs, s.webhookBatch, s.webhookInterval = urls, batch, interval }
+}
+
+// WithWebhookSecret signs webhook bodies with an HMAC-SHA256 of secret, sent
+// in the X-Universe-Signature header.
+func WithWebhookSecret(secret string) Option { return func(s *settings) { s.webhookSecret = secret } }
+
+// WithMaxBatch limits the points in one batch message (0 for no limit).
+func WithMaxBatch(n int) Option { return func(s *settings) { s.maxBatch = n } }
+
+// WithMaxGenerate caps the points of one generate command (0 for no cap).
+func WithMaxGenerate(n int) Option { return func(s *settings) { s.maxGenerate = n } }
+
+// WithMaxMessageBytes limits the size of an incoming message (0 for no
+// limit).
+func WithMaxMessageBytes(n int64) Option { return func(s *settings) { s.maxMessageBytes = n } }
+
+// WithIDMode keys points by id instead of coordinates.
+func WithIDMode(on bool) Option { return func(s *settings) { s.idMode = on } }
+
+// WithKeyDecimals sets the decimal places of the coordinates identifying a
+// point outside id mode; points that round alike are duplicates. For a
+// tolerance in distance use WithMinDistance.
+func WithKeyDecimals(n int) Option { return func(s *settings) { s.keyDecimals = n } }
+
+// WithCheckInterval sets the interval between connection probes and
+// consistency checks (0 to disable).
+func WithCheckInterval(d time.Duration) Option { return func(s *settings) { s.checkInterval = d } }
+
+// WithCompactInterval sets the interval between compaction passes (0 to
+// disable).
+func WithCompactInterval(d time.Duration) Option { return func(s *settings) { s.compactInterval = d } }
+
+// WithConnLifetime closes connections after lifetime plus a random share of
+// jitter (lifetime 0 to disable).
+func WithConnLifetime(lifetime, jitter time.Duration) Option {
+	return func(s *settings) { s.maxLifetime, s.lifetimeJitter = lifetime, jitter }
+}
+
+// WithInitChunkSize splits init snapshots larger than n points into
+// initChunk frames (0 to disable).
+func WithInitChunkSize(n int) Option { return func(s *settings) { s.initChunkSize = n } }
+
+// WithMaxInitBytes sends an initRef instead of init points encoding to more
+// than n bytes (0 for no limit).
+func WithMaxInitBytes(n int64) Option { return func(s *settings) { s.maxInitBytes = n } }
+
+// WithAcceptQueue bounds the connections waiting to be registered (0 to
+// register directly).
+func WithAcceptQueue(n int) Option { return func(s *settings) { s.acceptDepth = n } }
+
+// WithCompression negotiates permessage-deflate, leaving frames smaller than
+// threshold bytes uncompressed.
+func WithCompression(on bool, threshold int) Option {
+	return func(s *settings) { s.compress, s.compressMin = on, threshold }
+}
+
+// WithSlowWrites degrades clients whose writes take longer than threshold,
+// drops them after strikes slow writes and restores them after recoverAfter
+// timely ones (threshold 0 to disable).
+func WithSlowWrites(threshold time.Duration, strikes, recoverAfter int) Option {
+	return func(s *settings) {
+		s.slowWrites = slowWritePolicy{threshold: threshold, strikes: int32(strikes), recoverAfter: int32(recoverAfter)}
+	}
+}
+
+// WithClientRate limits each connection to rate messages per second with
+// bursts of burst, dropping it after disconnectAfter consecutive limited
+// messages (rate 0 for no limit).
+func WithClientRate(rate float64, burst, disconnectAfter int) Option {
+	return func(s *settings) { s.flood = floodPolicy{rate: rate, burst: burst, disconnectAfter: disconnectAfter} }
+}
+
+// WithSendQueue sets the frames buffered per client (0 to write
+// synchronously).
+func WithSendQueue(n int) Option { return func(s *settings) { s.sendQueue = n } }
+
+// WithShutdownReconnect sets the range of reconnect delays suggested to
+// clients by Shutdown.
+func WithShutdownReconnect(shortest, longest time.Duration) Option {
+	return func(s *settings) { s.reconnect = reconnectWindow{min: shortest, max: longest} }
+}
+
+// WithWriteTimeout sets the deadline for writing each frame.
+func WithWriteTimeout(d time.Duration) Option { return func(s *settings) { s.writeTimeout = d } }
+
+// WithReadTimeout drops connections silent for d; it must exceed the check
+// interval (0 to disable).
+func WithReadTimeout(d time.Duration) Option { return func(s *settings) { s.readTimeout = d } }
+
+// WithIdempotency remembers up to size idempotency keys per room for ttl.
+func WithIdempotency(ttl time.Duration, size int) Option {
+	return func(s *settings) { s.idemTTL, s.idemSize = ttl, size }
+}
+
+// WithMaxBroadcastRate caps broadcast frames per second per room (0 for no
+// cap).
+func WithMaxBroadcastRate(rate float64) Option { return func(s *settings) { s.broadcastRate = rate } }
+
+// WithBroadcastTick holds each room's broadcasts for d and sends them as one
+// delta frame per tick (0 to send them as they happen).
+func WithBroadcastTick(d time.Duration) Option { return func(s *settings) { s.broadcastTick = d } }
+
+// WithMoveQuantum sets the coordinate unit of binary move deltas.
+func WithMoveQuantum(q float64) Option { return func(s *settings) { s.moveQuantum = q } }
+
+// WithMoveCoalesce broadcasts only the latest of repeated moves within
+// window (0 to disable).
+func WithMoveCoalesce(window time.Duration) Option {
+	return func(s *settings) { s.moveWindow = window }
+}
+
+// WithBounds broadcasts a room's bounding box at most every interval when a
+// corner moves by threshold (interval 0 to disable).
+func WithBounds(interval time.Duration, threshold float64) Option {
+	return func(s *settings) { s.boundsInterval, s.boundsThreshold = interval, threshold }
+}
+
+// WithMinDistance rejects points closer than d to another (0 to disable).
+func WithMinDistance(d float64) Option { return func(s *settings) { s.minDistance = d } }
+
+// WithRoomMinDistance overrides the minimum distance in one room.
+func WithRoomMinDistance(room string, d float64) Option {
+	return func(s *settings) { s.roomMinDistance[room] = d }
+}
+
+// WithPeerStaleAfter announces peers as stale after d without a pong (0 to
+// disable).
+func WithPeerStaleAfter(d time.Duration) Option { return func(s *settings) { s.staleAfter = d } }
+
+// WithLockTimeout releases point locks not renewed within d (0 for no
+// expiry).
+func WithLockTimeout(d time.Duration) Option { return func(s *settings) { s.lockTimeout = d } }
+
+// WithPointLimits caps the points held across all rooms and by each owner
+// in a room, rejecting adds beyond them with limit_exceeded (0 for no cap).
+func WithPointLimits(total int64, perOwner int) Option {
+	return func(s *settings) { s.maxTotalPoints, s.maxOwnerPoints = total, perOwner }
+}
+
+// WithPointExpiry gives points added without an expiry a lifetime of ttl (0
+// for none) and sweeps expired points every interval (0 to never expire
+// points).
+func WithPointExpiry(ttl, interval time.Duration) Option {
+	return func(s *settings) { s.pointTTL, s.expiryInterval = ttl, interval }
+}
+
+// WithSimulation moves points that have a velocity every step (0 to never
+// move them) and broadcasts their positions at most every publish. It
+// requires id mode.
+func WithSimulation(step, publish time.Duration) Option {
+	return func(s *settings) { s.simStep, s.simPublish = step, publish }
+}
+
+// WithRecorder writes a recording of every loaded room to dir every interval
+// (0 for only on shutdown and on request), keeping the newest keep of them
+// (0 for all) and none older than maxAge (0 for no limit).
+func WithRecorder(dir string, interval time.Duration, keep int, maxAge time.Duration) Option {
+	return func(s *settings) {
+		s.recordDir, s.recordInterval, s.recordKeep, s.recordMaxAge = dir, interval, keep, maxAge
+	}
+}
+
+// WithTombstones remembers up to limit removals per room for retention.
+func WithTombstones(retention time.Duration, limit int) Option {
+	return func(s *settings) { s.tombstoneTTL, s.tombstoneLimit = retention, limit }
+}
+
+// WithRoomConfig loads default and per-room rules from a JSON file.
+func WithRoomConfig(path string) Option { return func(s *settings) { s.roomConfigPath = path } }
+
+// WithDataDir saves each room to dir, interval after it changes, and loads
+// it from there.
+func WithDataDir(dir string, interval time.Duration) Option {
+	return func(s *settings) { s.dataDir, s.persistInterval = dir, interval }
+}
+
+// WithStorage saves each room to the Storage newStorage returns for it,
+// interval after it changes, and loads it from there. It takes precedence
+// over WithDataDir.
+func WithStorage(newStorage func(room string) Storage, interval time.Duration) Option {
+	return func(s *settings) { s.storage, s.persistInterval = newStorage, interval }
+}
+
+// WithRoomTTL reclaims rooms empty of connections and points for d.
+func WithRoomTTL(d time.Duration) Option { return func(s *settings) { s.roomTTL = d } }
+
+// WithRoomDir unloads rooms without connections for unloadAfter to dir;
+// with preload, every room saved there is loaded at startup.
+func WithRoomDir(dir string, unloadAfter time.Duration, preload bool) Option {
+	return func(s *settings) { s.roomDir, s.unloadAfter, s.preloadRooms = dir, unloadAfter, preload }
+}
+
+// WithStartupDelay withholds readiness for d after startup; with queue,
+// WebSocket connections wait until ready instead of getting 503.
+func WithStartupDelay(d time.Duration, queue bool) Option {
+	return func(s *settings) { s.startupDelay, s.queueUntilReady = d, queue }
+}
+
+// WithTransform applies a coordinate transform spec to added points.
+func WithTransform(spec string) Option { return func(s *settings) { s.transform = spec } }
+
+// WithRoomTransform overrides the transform in one room.
+func WithRoomTransform(room, spec string) Option {
+	return func(s *settings) { s.roomTransforms[room] = spec }
+}
+
+// WithLogSampleWindow collapses identical client-triggered log lines within
+// d.
+func WithLogSampleWindow(d time.Duration) Option { return func(s *settings) { s.logSample = d } }
+
+// WithAdminToken enables the administrative endpoints behind a bearer
+// token; with debug, /debug/state is served as well.
+func WithAdminToken(token string, debug bool) Option {
+	return func(s *settings) { s.adminToken, s.debug = token, debug }
+}
+
+// WithCoordDecimals rounds coordinates sent to clients to n decimals; it
+// requires id mode (-1 to disable).
+func WithCoordDecimals(n int) Option { return func(s *settings) { s.coordDecimals = n } }
+
+// WithMetaLimits bounds the keys and total bytes of a point's meta.
+func WithMetaLimits(keys, bytes int) Option {
+	return func(s *settings) { s.metaKeys, s.metaBytes = keys, bytes }
+}
+
+// WithStrictMessages rejects messages of unknown types or with unexpected
+// fields.
+func WithStrictMessages(on bool) Option { return func(s *settings) { s.strict = on } }
+
+// WithSignalRetention replays up to limit signals younger than d to joining
+// clients (0 to disable).
+func WithSignalRetention(d time.Duration, limit int) Option {
+	return func(s *settings) { s.signalRetention, s.signalLimit = d, limit }
+}
+
+// WithChat lets editors send chat messages of at most maxLength bytes (0 to
+// disable chat), replaying the last history of them to joining clients.
+func WithChat(maxLength, history int) Option {
+	return func(s *settings) { s.chatLength, s.chatHistory = maxLength, history }
+}
+
+// WithChatRate limits each connection to rate chat messages per second on
+// average, burst at once (rate 0 for no limit).
+func WithChatRate(rate float64, burst int) Option {
+	return func(s *settings) { s.chatRate = floodPolicy{rate: rate, burst: burst} }
+}
+
+// WithACL loads per-room roles from a JSON file; it requires WithJWT.
+func WithACL(path string) Option { return func(s *settings) { s.aclPath = path } }
+
+// WithJWT verifies bearer tokens with an HS256 secret and, by kid, the
+// secrets of a JSON key file.
+func WithJWT(secret, keysPath string) Option {
+	return func(s *settings) { s.jwtSecret, s.jwtKeys = secret, keysPath }
+}
+
+// WithAnonymous sets what callers without a token may do when
+// authentication is on without an ACL: "full", "read-only" or "reject".
+func WithAnonymous(mode string) Option { return func(s *settings) { s.anonymous = mode } }
+
+// WithSyncHistory remembers n mutations per room for resuming clients (0 to
+// always send init).
+func WithSyncHistory(n int) Option { return func(s *settings) { s.syncHistory = n } }
+
+// WithOwnerRemoves lets only a point's owner, or an ACL admin, remove it.
+func WithOwnerRemoves(on bool) Option { return func(s *settings) { s.ownerRemoves = on } }
+
+// WithPresenceInterval broadcasts a client's presence at most every d.
+func WithPresenceInterval(d time.Duration) Option { return func(s *settings) { s.presenceEvery = d } }
+
+// WithUndoDepth sets the changes each connection can undo (0 to disable).
+func WithUndoDepth(n int) Option { return func(s *settings) { s.undoDepth = n } }
+
+// WithBackplane relays point changes between instances over the pub/sub
+// channel of a redis:// URL.
+func WithBackplane(url, channel string) Option {
+	return func(s *settings) { s.backplaneURL, s.backplaneTopic = url, channel }
+}
+
+// WithJSONCase sets the key casing of JSON exchanged with clients: "camel"
+// or "snake".
+func WithJSONCase(c string) Option { return func(s *settings) { s.jsonCase = c } }
+
+// WithImport bounds snapshot imports to maxBytes and remote fetches to
+// timeout.
+func WithImport(timeout time.Duration, maxBytes int64) Option {
+	return func(s *settings) { s.importTimeout, s.maxImportBytes = timeout, maxBytes }
+}
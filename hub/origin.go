@@ -0,0 +1,112 @@
+package hub
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// originPolicy decides which browser origins may open WebSocket connections
+// and call the REST endpoints. Patterns are origins such as
+// https://example.com in which * matches any run of characters other than
+// a slash, as in https://*.example.com or http://localhost:*; a lone *
+// allows every origin. Patterns without a scheme, such as example.com or
+// *.example.com, match the host name alone, on any scheme and port. Without
+// patterns only pages served from the same host name, on any port, are
+// allowed.
+type originPolicy struct {
+	any      bool
+	patterns []string
+	hosts    []string
+}
+
+func newOriginPolicy(list string) (originPolicy, error) {
+	var p originPolicy
+	for _, o := range strings.Split(list, ",") {
+		o = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(o), "/"))
+		switch {
+		case o == "":
+		case o == "*":
+			p.any = true
+		default:
+			if _, err := path.Match(o, ""); err != nil {
+				return originPolicy{}, err
+			}
+			if strings.Contains(o, "://") {
+				p.patterns = append(p.patterns, o)
+			} else {
+				p.hosts = append(p.hosts, o)
+			}
+		}
+	}
+	return p, nil
+}
+
+// allows reports whether r may proceed. Requests without an Origin header do
+// not come from browsers and are always allowed.
+func (p originPolicy) allows(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.any {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if p.patterns == nil && p.hosts == nil {
+		return strings.EqualFold(hostname(u.Host), hostname(r.Host))
+	}
+	normalized := strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, normalized); ok {
+			return true
+		}
+	}
+	host := strings.ToLower(hostname(u.Host))
+	for _, pattern := range p.hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hostname(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
+
+// cors sets the CORS headers for an allowed cross-origin request and
+// answers preflight requests, reporting whether r has been handled.
+func (p originPolicy) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if !p.allows(r) {
+		if preflight {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Credentials", "true")
+	if !preflight {
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	h.Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
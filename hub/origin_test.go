@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		list   string
+		origin string
+		host   string
+		want   bool
+	}{
+		// Without a list, the page must come from the server's host name.
+		{"", "", "app.example.com", true},
+		{"", "https://app.example.com", "app.example.com:8080", true},
+		{"", "http://app.example.com:3000", "app.example.com", true},
+		{"", "https://APP.example.com", "app.example.com", true},
+		{"", "https://evil.com", "app.example.com", false},
+		{"", "https://app.example.com.evil.com", "app.example.com", false},
+		{"", "null", "app.example.com", false},
+
+		{"*", "https://anything.test", "app.example.com", true},
+
+		// Exact origins match scheme, host and port.
+		{"https://example.com", "https://example.com", "", true},
+		{"https://example.com/", "https://example.com", "", true},
+		{"https://example.com", "http://example.com", "", false},
+		{"https://example.com", "https://example.com:8443", "", false},
+		{"https://example.com", "https://example.com.evil.com", "", false},
+		{"https://example.com", "https://evil-example.com", "", false},
+
+		// Wildcard subdomains.
+		{"https://*.example.com", "https://app.example.com", "", true},
+		{"https://*.example.com", "https://a.b.example.com", "", true},
+		{"https://*.example.com", "https://App.Example.com", "", true},
+		{"https://*.example.com", "https://example.com", "", false},
+		{"https://*.example.com", "https://evil-example.com", "", false},
+		{"https://*.example.com", "https://example.com.evil.com", "", false},
+		{"https://*.example.com", "https://app.example.com:8443", "", false},
+		{"https://*.example.com", "http://app.example.com", "", false},
+
+		// Wildcard ports.
+		{"http://localhost:*", "http://localhost:3000", "", true},
+		{"http://localhost:*", "http://localhost.evil.com:3000", "", false},
+
+		// Host-only entries match on any scheme and port.
+		{"example.com", "https://example.com", "", true},
+		{"example.com", "http://example.com:8080", "", true},
+		{"example.com", "https://app.example.com", "", false},
+		{"example.com", "https://evil-example.com", "", false},
+		{"*.example.com", "https://app.example.com:8443", "", true},
+		{"*.example.com", "http://a.b.example.com", "", true},
+		{"*.example.com", "https://example.com", "", false},
+		{"*.example.com", "https://evil-example.com", "", false},
+		{"*.example.com", "https://app.example.com.evil.com", "", false},
+
+		// A list replaces the same-host default, and any entry may match.
+		{"https://other.com", "https://app.example.com", "app.example.com", false},
+		{"https://other.com, example.com", "https://example.com:9000", "", true},
+		{"https://other.com, example.com", "https://another.com", "", false},
+	} {
+		p, err := newOriginPolicy(tc.list)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.list, err)
+		}
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Host = tc.host
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if got := p.allows(r); got != tc.want {
+			t.Errorf("list %q, origin %q on host %q: allowed = %v, want %v", tc.list, tc.origin, tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestOriginPolicyRejectsBadPatterns(t *testing.T) {
+	if _, err := newOriginPolicy("https://[example.com"); err == nil {
+		t.Error("accepted a malformed pattern")
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	p, err := newOriginPolicy("https://*.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/points", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		r.Header.Set("Access-Control-Request-Headers", "Authorization")
+		rec := httptest.NewRecorder()
+		if !p.cors(rec, r) {
+			t.Fatalf("%s: preflight not handled", origin)
+		}
+		return rec
+	}
+	rec := preflight("https://app.example.com")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || rec.Header().Get("Access-Control-Allow-Headers") != "Authorization" {
+		t.Errorf("allowed preflight: %d %v", rec.Code, rec.Header())
+	}
+	rec = preflight("https://evil-example.com")
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("rejected preflight: %d %v", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,42 @@
+package hub
+
+// principal is who owns the points c adds: its verified identity, or the
+// connection id for anonymous clients.
+func (c *client) principal() string {
+	if c.identity != "" {
+		return c.identity
+	}
+	return c.id
+}
+
+// restActor names the caller of a REST mutation in the same way.
+func restActor(identity, remoteAddr string) string {
+	if identity != "" {
+		return identity
+	}
+	return "rest:" + remoteAddr
+}
+
+// removesAny reports whether a caller with the given role may remove points
+// owned by others.
+func (h *Hub) removesAny(access role) bool {
+	return !h.ownerRemoves || h.aclAdmins && access >= roleAdmin
+}
+
+// ownedOnly returns the points of ps not owned by someone other than owner,
+// and how many were left out. Points not in the room are kept so that the
+// removal treats them as usual.
+func (h *Hub) ownedOnly(ps []Point, owner string) ([]Point, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	kept := make([]Point, 0, len(ps))
+	foreign := 0
+	for _, p := range ps {
+		if stored, ok := h.points[h.key(p)]; ok && stored.Owner != owner {
+			foreign++
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept, foreign
+}
@@ -0,0 +1,122 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RoomSnapshot is a room's points as saved by a Storage, along with the
+// counters needed to carry on numbering where the room left off.
+type RoomSnapshot struct {
+	Room        string  `json:"room"`
+	Seq         uint64  `json:"seq"`
+	NextPointID uint64  `json:"nextPointId,omitempty"`
+	Points      []Point `json:"points"`
+}
+
+func (h *Hub) snapshot() RoomSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snap := RoomSnapshot{Room: h.room, Seq: h.seq, NextPointID: h.nextPointID, Points: make([]Point, 0, len(h.points))}
+	for _, p := range h.points {
+		snap.Points = append(snap.Points, p)
+	}
+	return snap
+}
+
+// restore replaces the hub's state with snap without emitting mutations.
+func (h *Hub) restore(snap RoomSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	before := len(h.points)
+	h.points = make(map[string]Point, len(snap.Points))
+	h.nextExpiry = 0
+	for _, p := range snap.Points {
+		h.points[h.key(p)] = p
+		h.trackExpiry(p)
+	}
+	h.countAll(before)
+	h.seq = snap.Seq
+	h.nextPointID = snap.NextPointID
+	if h.history != nil {
+		h.history = newOpHistory(h.history.limit)
+	}
+	if h.bounds != nil {
+		h.bounds.stale = true
+	}
+	if h.grid != nil {
+		h.grid.rebuild(h.points)
+	}
+	now := time.Now().UnixMilli()
+	// Removals before the restore are unknown, so older polls must reset.
+	h.changes = newChangeLog(h.changes.retention, h.changes.limit)
+	h.changes.horizon = now
+	for key := range h.points {
+		h.changes.touch(key, now)
+	}
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory,
+// syncs it and renames it over path so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// durable reports whether p belongs on disk. Ephemeral and expiring points
+// are meant to vanish with the process or the room, so they are never
+// persisted, even when pinned.
+func durable(p Point) bool { return !p.Ephemeral && p.ExpiresAt == 0 }
+
+// saveSnapshotFile writes snap to path, leaving out points that are not
+// durable.
+func saveSnapshotFile(path string, snap RoomSnapshot) error {
+	kept := make([]Point, 0, len(snap.Points))
+	for _, p := range snap.Points {
+		if durable(p) {
+			kept = append(kept, p)
+		}
+	}
+	snap.Points = kept
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// loadSnapshotFile reads a snapshot, reporting ok=false without an error
+// when the file does not exist.
+func loadSnapshotFile(path string) (RoomSnapshot, bool, error) {
+	var snap RoomSnapshot
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return snap, false, nil
+	}
+	if err != nil {
+		return snap, false, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, false, err
+	}
+	return snap, true, nil
+}
@@ -0,0 +1,39 @@
+package hub
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOnlyDurablePointsAreSaved(t *testing.T) {
+	h := newHub("test")
+	c, _ := testClient(h)
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{
+		{X: 1},
+		{X: 2, Pinned: true},
+		{X: 3, Ephemeral: true},
+		{X: 4, TTLMs: time.Hour.Milliseconds()},
+		{X: 5, Ephemeral: true, Pinned: true},
+	}})
+	h.signal(Point{X: 6})
+
+	path := filepath.Join(t.TempDir(), "room.json")
+	if err := saveSnapshotFile(path, h.snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	snap, ok, err := loadSnapshotFile(path)
+	if err != nil || !ok {
+		t.Fatalf("load: %v, %v", ok, err)
+	}
+	xs := map[float64]bool{}
+	for _, p := range snap.Points {
+		xs[p.X] = true
+	}
+	if len(xs) != 2 || !xs[1] || !xs[2] {
+		t.Errorf("saved points at x %v, want the plain and the pinned one", xs)
+	}
+	if snap.Seq != h.seq {
+		t.Errorf("saved seq %d, want %d", snap.Seq, h.seq)
+	}
+}
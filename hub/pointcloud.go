@@ -0,0 +1,263 @@
+package hub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Point-cloud formats for /export and /import. CSV carries every attribute
+// but meta; XYZ and PLY carry coordinates and, when present, color. Only JSON
+// follows the server's wire encoding.
+var pointFormats = map[string]struct {
+	contentType string
+	write       func(w io.Writer, wire wireEncoding, room string, ps []Point) error
+	read        func(wire wireEncoding, data []byte) ([]Point, error)
+}{
+	"json": {"application/json", writeSnapshotJSON, readPointsJSON},
+	"csv":  {"text/csv", writeCSV, readCSV},
+	"xyz":  {"text/plain", writeXYZ, readXYZ},
+	"ply":  {"application/x-ply", writePLY, readPLY},
+}
+
+func writeSnapshotJSON(w io.Writer, wire wireEncoding, room string, ps []Point) error {
+	data, err := wire.marshal(RoomSnapshot{Room: room, Points: ps})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readPointsJSON accepts a snapshot as served by /export or /snapshot.json,
+// an array of points or a single point.
+func readPointsJSON(wire wireEncoding, data []byte) ([]Point, error) {
+	var snap struct {
+		Points *[]Point `json:"points"`
+	}
+	if err := wire.unmarshal(data, &snap); err == nil && snap.Points != nil {
+		return *snap.Points, nil
+	}
+	ps, _, err := decodePoints(wire, data)
+	return ps, err
+}
+
+var csvHeader = []string{"x", "y", "z", "weight", "color", "label", "id", "path"}
+
+func writeCSV(w io.Writer, _ wireEncoding, room string, ps []Point) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, p := range ps {
+		cw.Write([]string{formatCoord(p.X), formatCoord(p.Y), formatCoord(p.Z), formatCoord(p.Weight), p.Color, p.Label, p.ID, p.Path})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatCoord(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+
+// readCSV reads rows under a header naming their columns; x, y and z are
+// required and the other columns of writeCSV are optional.
+func readCSV(_ wireEncoding, data []byte) ([]Point, error) {
+	cr := csv.NewReader(bytes.NewReader(data))
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %v", err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range [...]string{"x", "y", "z"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("csv header lacks a %q column", name)
+		}
+	}
+	var ps []Point
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return ps, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		p := Point{Weight: 1, Color: field("color"), Label: field("label"), ID: field("id"), Path: field("path")}
+		for _, c := range [...]struct {
+			name string
+			dst  *float64
+		}{{"x", &p.X}, {"y", &p.Y}, {"z", &p.Z}, {"weight", &p.Weight}} {
+			s := field(c.name)
+			if s == "" && c.name == "weight" {
+				continue
+			}
+			if *c.dst, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q", line, c.name, s)
+			}
+		}
+		ps = append(ps, p)
+	}
+}
+
+// writeXYZ writes one "x y z" line per point, followed by "r g b" when any
+// point has a color.
+func writeXYZ(w io.Writer, _ wireEncoding, room string, ps []Point) error {
+	colored := false
+	for _, p := range ps {
+		if p.Color != "" {
+			colored = true
+			break
+		}
+	}
+	bw := bufio.NewWriter(w)
+	for _, p := range ps {
+		if colored {
+			r, g, b := rgb(p.Color)
+			fmt.Fprintf(bw, "%g %g %g %d %d %d\n", p.X, p.Y, p.Z, r, g, b)
+			continue
+		}
+		fmt.Fprintf(bw, "%g %g %g\n", p.X, p.Y, p.Z)
+	}
+	return bw.Flush()
+}
+
+// readXYZ reads whitespace-separated "x y z" lines with optional 0-255
+// "r g b" values, skipping blank lines and # comments.
+func readXYZ(_ wireEncoding, data []byte) ([]Point, error) {
+	var ps []Point
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		p, err := parseVertex(strings.Fields(text), 0, 3)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		ps = append(ps, p)
+	}
+	return ps, sc.Err()
+}
+
+// parseVertex reads x, y and z from fields, and a color from the three
+// fields starting at colorAt when they are present; colorAt < 0 means none.
+func parseVertex(fields []string, xAt, colorAt int) (Point, error) {
+	p := Point{Weight: 1}
+	if len(fields) < xAt+3 {
+		return p, errors.New("expected x y z")
+	}
+	for i, dst := range [...]*float64{&p.X, &p.Y, &p.Z} {
+		v, err := strconv.ParseFloat(fields[xAt+i], 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid coordinate %q", fields[xAt+i])
+		}
+		*dst = v
+	}
+	if colorAt >= 0 && len(fields) >= colorAt+3 {
+		var c [3]uint64
+		for i := range c {
+			v, err := strconv.ParseUint(fields[colorAt+i], 10, 8)
+			if err != nil {
+				return p, fmt.Errorf("invalid color component %q", fields[colorAt+i])
+			}
+			c[i] = v
+		}
+		p.Color = fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+	}
+	return p, nil
+}
+
+// readPLY reads the vertices of an ASCII PLY file, taking x, y, z and, when
+// declared, red, green and blue; other properties and elements, such as
+// faces, are ignored.
+func readPLY(_ wireEncoding, data []byte) ([]Point, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) != "ply" {
+		return nil, errors.New("not a PLY file")
+	}
+	type element struct {
+		name  string
+		count int
+		props []string
+	}
+	var elements []element
+	ascii := false
+	for {
+		if !sc.Scan() {
+			return nil, errors.New("PLY header lacks end_header")
+		}
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 {
+			continue
+		}
+		if f[0] == "end_header" {
+			break
+		}
+		switch f[0] {
+		case "format":
+			ascii = len(f) > 1 && f[1] == "ascii"
+		case "element":
+			if len(f) != 3 {
+				return nil, fmt.Errorf("invalid PLY line %q", sc.Text())
+			}
+			n, err := strconv.Atoi(f[2])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid PLY element count %q", f[2])
+			}
+			elements = append(elements, element{name: f[1], count: n})
+		case "property":
+			if len(elements) == 0 {
+				return nil, errors.New("PLY property before any element")
+			}
+			e := &elements[len(elements)-1]
+			e.props = append(e.props, f[len(f)-1])
+		}
+	}
+	if !ascii {
+		return nil, errors.New("only ASCII PLY files are supported")
+	}
+	var ps []Point
+	for _, e := range elements {
+		index := map[string]int{}
+		for i, name := range e.props {
+			index[name] = i
+		}
+		colorAt := -1
+		if r, ok := index["red"]; ok && index["green"] == r+1 && index["blue"] == r+2 {
+			colorAt = r
+		}
+		x, hasX := index["x"]
+		vertex := e.name == "vertex" && hasX && index["y"] == x+1 && index["z"] == x+2
+		if e.name == "vertex" && !vertex {
+			return nil, errors.New("PLY vertices need consecutive x, y and z properties")
+		}
+		for i := 0; i < e.count; i++ {
+			if !sc.Scan() {
+				return nil, errors.New("PLY file ends before its elements")
+			}
+			if !vertex {
+				continue
+			}
+			p, err := parseVertex(strings.Fields(sc.Text()), x, colorAt)
+			if err != nil {
+				return nil, fmt.Errorf("vertex %d: %v", i, err)
+			}
+			ps = append(ps, p)
+		}
+	}
+	return ps, sc.Err()
+}
@@ -0,0 +1,159 @@
+package hub
+
+import (
+	"math"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const maxNicknameLength = 64
+
+// camera is where a peer is looking from: a position and an orientation
+// quaternion (x, y, z, w).
+type camera struct {
+	Position    [3]float64 `json:"position"`
+	Orientation [4]float64 `json:"orientation"`
+}
+
+// peerProfile is what a client tells others about itself with presence
+// messages.
+type peerProfile struct {
+	Nickname string  `json:"nickname,omitempty"`
+	Color    string  `json:"color,omitempty"`
+	Camera   *camera `json:"camera,omitempty"`
+}
+
+// peerState describes a connection to the other clients in its room. A peer
+// turns stale when it has not answered a ping for staleAfter, well before
+// it would be reaped, so collaborative UIs can grey it out.
+type peerState struct {
+	ID       string `json:"id"`
+	LastSeen int64  `json:"lastSeen"`
+	State    string `json:"state"`
+	peerProfile
+}
+
+func (c *client) peerState() peerState {
+	state := "alive"
+	if c.stale.Load() {
+		state = "stale"
+	}
+	p := peerState{ID: c.id, LastSeen: c.lastPong.Load(), State: state}
+	if prof := c.profile.Load(); prof != nil {
+		p.peerProfile = *prof
+	}
+	return p
+}
+
+func (h *Hub) peers() []peerState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]peerState, 0, len(h.conns))
+	for c := range h.conns {
+		out = append(out, c.peerState())
+	}
+	return out
+}
+
+func (h *Hub) announce(c *client, state string) {
+	p := c.peerState()
+	p.State = state
+	h.broadcast(Message{Type: "presence", Peer: &p})
+}
+
+// pong records a heartbeat answer and announces a stale peer as alive again.
+func (h *Hub) pong(c *client) {
+	c.lastPong.Store(time.Now().UnixMilli())
+	if c.stale.CompareAndSwap(true, false) {
+		h.announce(c, "alive")
+	}
+}
+
+// markStale announces c as stale the first time its last pong is older than
+// the room's threshold.
+func (h *Hub) markStale(c *client, now time.Time) {
+	if h.staleAfter <= 0 || now.Sub(time.UnixMilli(c.lastPong.Load())) <= h.staleAfter {
+		return
+	}
+	if c.stale.CompareAndSwap(false, true) {
+		h.announce(c, "stale")
+	}
+}
+
+// setPresence applies the fields a presence message carries to c's profile
+// and announces it as "updated", at most once per presenceInterval.
+func (h *Hub) setPresence(c *client, msg Message) *validationError {
+	var prof peerProfile
+	if old := c.profile.Load(); old != nil {
+		prof = *old
+	}
+	if msg.Nickname != nil {
+		if len(*msg.Nickname) > maxNicknameLength || !utf8.ValidString(*msg.Nickname) {
+			return invalid(errInvalidNickname, "nickname must be valid UTF-8 of at most %d bytes", maxNicknameLength)
+		}
+		prof.Nickname = *msg.Nickname
+	}
+	if msg.Color != nil {
+		if err := validateColor(*msg.Color); err != nil {
+			return err
+		}
+		prof.Color = *msg.Color
+	}
+	if msg.Camera != nil {
+		for _, v := range append(msg.Camera.Position[:], msg.Camera.Orientation[:]...) {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return invalid(errInvalidCoords, "camera must be finite numbers")
+			}
+		}
+		cam := *msg.Camera
+		prof.Camera = &cam
+	}
+	c.profile.Store(&prof)
+	c.presence.announce(h, c)
+	return nil
+}
+
+// presenceThrottle limits how often a client's presence is broadcast. An
+// update within presenceInterval of the previous broadcast is deferred to
+// the end of the interval, when the latest profile is sent.
+type presenceThrottle struct {
+	mu      sync.Mutex
+	last    time.Time
+	timer   *time.Timer
+	stopped bool
+}
+
+func (t *presenceThrottle) announce(h *Hub, c *client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timer != nil || t.stopped {
+		return
+	}
+	wait := h.presenceInterval - time.Since(t.last)
+	if wait <= 0 {
+		t.last = time.Now()
+		h.announce(c, "updated")
+		return
+	}
+	t.timer = time.AfterFunc(wait, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.timer = nil
+		if !t.stopped {
+			t.last = time.Now()
+			h.announce(c, "updated")
+		}
+	})
+}
+
+// stop drops a deferred update so that nothing follows a peer's "left".
+func (t *presenceThrottle) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
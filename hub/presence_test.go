@@ -0,0 +1,37 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSilentPeerTurnsStaleAndBack(t *testing.T) {
+	h := newHub("test")
+	h.staleAfter = time.Second
+	c, _ := testClient(h)
+	_, watcher := testClient(h)
+	c.lastPong.Store(time.Now().UnixMilli())
+
+	h.markStale(c, time.Now())
+	if got := watcher.ofType(t, "presence"); len(got) != 0 {
+		t.Fatalf("fresh peer announced %+v", got)
+	}
+	later := time.Now().Add(2 * time.Second)
+	h.markStale(c, later)
+	h.markStale(c, later)
+	stale := watcher.ofType(t, "presence")
+	if len(stale) != 1 || stale[0].Peer.ID != c.id || stale[0].Peer.State != "stale" {
+		t.Fatalf("got %+v, want one stale announcement", stale)
+	}
+	for _, p := range h.peers() {
+		if p.ID == c.id && p.State != "stale" {
+			t.Errorf("peer list shows %+v", p)
+		}
+	}
+
+	h.pong(c)
+	alive := watcher.ofType(t, "presence")
+	if len(alive) != 1 || alive[0].Peer.State != "alive" {
+		t.Errorf("after a pong got %+v, want one alive announcement", alive)
+	}
+}
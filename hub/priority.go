@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"time"
+)
+
+// Broadcast priorities. The mapping is fixed per message type: anything that
+// changes the points themselves, and replies to a client's own requests, are
+// high; awareness traffic about other clients and derived state is low. Under
+// backpressure low priority messages are delivered last and dropped first.
+const (
+	priorityLow = iota
+	priorityHigh
+)
+
+func messagePriority(msgType string) int {
+	switch msgType {
+	case "presence", "signal", "bounds", "lock", "unlock", "select", "deselect", "clearSelection":
+		return priorityLow
+	}
+	return priorityHigh
+}
+
+// slowWritePolicy degrades a client whose broadcast writes take longer than
+// threshold: it stops receiving low-priority messages until recoverAfter
+// consecutive writes complete in time, and is dropped after strikes slow
+// writes without recovering.
+type slowWritePolicy struct {
+	threshold    time.Duration
+	strikes      int32
+	recoverAfter int32
+}
+
+func (h *Hub) noteWrite(c *client, took time.Duration) {
+	p := h.slowWrites
+	if p.threshold <= 0 {
+		return
+	}
+	if took <= p.threshold {
+		if c.degraded.Load() && c.fastWrites.Add(1) >= p.recoverAfter {
+			c.degraded.Store(false)
+			c.strikes.Store(0)
+			c.log.Info("connection recovered, resuming low-priority messages")
+		}
+		return
+	}
+	c.fastWrites.Store(0)
+	strikes := c.strikes.Add(1)
+	if p.strikes > 0 && strikes >= p.strikes {
+		c.log.Warn("dropping slow connection", "slowWrites", strikes)
+		h.metrics.droppedClients.Add(1)
+		h.removeConn(c)
+		return
+	}
+	if c.degraded.CompareAndSwap(false, true) {
+		c.log.Info("connection is slow, withholding low-priority messages", "took", took)
+	}
+}
@@ -0,0 +1,53 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowClientDegradesThenRecovers(t *testing.T) {
+	h := newHub("test")
+	h.slowWrites = slowWritePolicy{threshold: 10 * time.Millisecond, strikes: 3, recoverAfter: 2}
+	editor, _ := testClient(h)
+	slow, fc := testClient(h)
+	p := Point{X: 1}
+	send(t, h, editor, Message{Type: "add", Point: &p})
+	fc.messages(t)
+
+	h.noteWrite(slow, time.Second)
+	send(t, h, editor, Message{Type: "select", Point: &p})
+	label := "a"
+	send(t, h, editor, Message{Type: "update", Point: &p, Label: &label})
+	got := fc.messages(t)
+	if len(got) != 1 || got[0].Type != "update" {
+		t.Fatalf("degraded client got %+v, want only the update", got)
+	}
+
+	h.noteWrite(slow, time.Millisecond)
+	h.noteWrite(slow, time.Millisecond)
+	send(t, h, editor, Message{Type: "deselect", Point: &p})
+	if got := fc.ofType(t, "deselect"); len(got) != 1 {
+		t.Errorf("recovered client got %d deselects, want 1", len(got))
+	}
+	if slow.strikes.Load() != 0 {
+		t.Errorf("strikes after recovering = %d", slow.strikes.Load())
+	}
+}
+
+func TestSlowClientIsDroppedAfterRepeatedStrikes(t *testing.T) {
+	h := newHub("test")
+	h.slowWrites = slowWritePolicy{threshold: 10 * time.Millisecond, strikes: 3, recoverAfter: 2}
+	slow, fc := testClient(h)
+
+	h.noteWrite(slow, time.Second)
+	h.noteWrite(slow, time.Second)
+	// A single fast write does not reach recoverAfter, so strikes carry on.
+	h.noteWrite(slow, time.Millisecond)
+	if _, ok := h.conns[slow]; !ok || !slow.degraded.Load() {
+		t.Fatal("client dropped or restored before its third strike")
+	}
+	h.noteWrite(slow, time.Second)
+	if _, ok := h.conns[slow]; ok || !fc.closed {
+		t.Error("client kept after three slow writes")
+	}
+}
@@ -0,0 +1,71 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+// broadcastLimiter caps how often a room writes broadcast frames. While the
+// room stays under the cap every message goes out immediately; above it,
+// messages queue up and are flushed together as one delta frame at the next
+// allowed instant, so nothing is withheld for longer than one interval.
+//
+// A ticking limiter never sends immediately: every message waits for the
+// next tick, one interval after the first message that arrives after a
+// flush.
+type broadcastLimiter struct {
+	interval time.Duration
+	tick     bool
+	send     func(Message)
+
+	mu      sync.Mutex
+	last    time.Time
+	pending []Message
+	armed   bool
+}
+
+func newBroadcastLimiter(perSecond float64, send func(Message)) *broadcastLimiter {
+	return &broadcastLimiter{interval: time.Duration(float64(time.Second) / perSecond), send: send}
+}
+
+func newBroadcastTicker(interval time.Duration, send func(Message)) *broadcastLimiter {
+	return &broadcastLimiter{interval: interval, tick: true, send: send}
+}
+
+func (l *broadcastLimiter) submit(msg Message) {
+	l.mu.Lock()
+	now := time.Now()
+	if !l.tick && !l.armed && now.Sub(l.last) >= l.interval {
+		l.last = now
+		l.mu.Unlock()
+		l.send(msg)
+		return
+	}
+	l.pending = append(l.pending, msg)
+	if !l.armed {
+		l.armed = true
+		wait := l.last.Add(l.interval).Sub(now)
+		if l.tick {
+			wait = l.interval
+		}
+		time.AfterFunc(wait, l.flush)
+	}
+	l.mu.Unlock()
+}
+
+func (l *broadcastLimiter) flush() {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.armed = false
+	l.last = time.Now()
+	l.mu.Unlock()
+
+	switch len(pending) {
+	case 0:
+	case 1:
+		l.send(pending[0])
+	default:
+		l.send(Message{Type: "delta", Seq: pending[len(pending)-1].Seq, Messages: pending})
+	}
+}
@@ -0,0 +1,56 @@
+package hub
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentFrames records what a broadcastLimiter sends.
+type sentFrames struct {
+	mu   sync.Mutex
+	msgs []Message
+}
+
+func (s *sentFrames) send(msg Message) {
+	s.mu.Lock()
+	s.msgs = append(s.msgs, msg)
+	s.mu.Unlock()
+}
+
+func (s *sentFrames) get() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.msgs...)
+}
+
+func TestBroadcastLimiterBatchesAboveTheCap(t *testing.T) {
+	var sent sentFrames
+	l := newBroadcastLimiter(10, sent.send)
+
+	for seq := uint64(1); seq <= 4; seq++ {
+		l.submit(Message{Type: "add", Seq: seq})
+	}
+	if got := sent.get(); len(got) != 1 || got[0].Seq != 1 {
+		t.Fatalf("before the interval sent %+v, want only the first", got)
+	}
+	time.Sleep(150 * time.Millisecond)
+	got := sent.get()
+	if len(got) != 2 || got[1].Type != "delta" || got[1].Seq != 4 || len(got[1].Messages) != 3 {
+		t.Fatalf("after the interval sent %+v, want a delta of the other three", got)
+	}
+}
+
+func TestBroadcastTickerWaitsForTheTick(t *testing.T) {
+	var sent sentFrames
+	l := newBroadcastTicker(50*time.Millisecond, sent.send)
+
+	l.submit(Message{Type: "add", Seq: 1})
+	if got := sent.get(); len(got) != 0 {
+		t.Fatalf("ticker sent %+v immediately", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := sent.get(); len(got) != 1 || got[0].Type != "add" {
+		t.Fatalf("after the tick sent %+v, want the lone add", got)
+	}
+}
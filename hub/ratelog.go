@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var digitRun = regexp.MustCompile(`[0-9]+`)
+
+type sampledLine struct {
+	first      time.Time
+	level      slog.Level
+	text       string
+	suppressed int
+}
+
+// logSampler collapses repeated log records. The first occurrence of a record
+// is logged immediately; identical ones within the window are counted and
+// reported once the window has passed. Records are compared by message and
+// attributes with digits removed, so errors that differ only in ports or ids
+// count as identical. The logger's own attributes, such as the connection,
+// are not compared.
+type logSampler struct {
+	window    time.Duration
+	mu        sync.Mutex
+	lines     map[string]*sampledLine
+	lastSweep time.Time
+}
+
+func newLogSampler(window time.Duration) *logSampler {
+	return &logSampler{window: window, lines: make(map[string]*sampledLine)}
+}
+
+// Warn logs msg with args to l at warning level unless a similar record was
+// logged within the window.
+func (s *logSampler) Warn(l *slog.Logger, msg string, args ...interface{}) {
+	s.log(l, slog.LevelWarn, msg, args...)
+}
+
+func (s *logSampler) log(l *slog.Logger, level slog.Level, msg string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	if s.window <= 0 {
+		l.Log(ctx, level, msg, args...)
+		return
+	}
+	text := strings.TrimSuffix(fmt.Sprintln(append([]interface{}{msg}, args...)...), "\n")
+	key := digitRun.ReplaceAllString(text, "#")
+	now := time.Now()
+
+	s.mu.Lock()
+	if now.Sub(s.lastSweep) >= s.window {
+		s.sweep(now)
+	}
+	if seen, ok := s.lines[key]; ok {
+		seen.suppressed++
+		s.mu.Unlock()
+		return
+	}
+	s.lines[key] = &sampledLine{first: now, level: level, text: text}
+	s.mu.Unlock()
+	l.Log(ctx, level, msg, args...)
+}
+
+// sweep forgets records whose window has passed, reporting how many copies of
+// each were suppressed. Must be called with s.mu held.
+func (s *logSampler) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, l := range s.lines {
+		if now.Sub(l.first) < s.window {
+			continue
+		}
+		if l.suppressed > 0 {
+			slog.Log(context.Background(), l.level, "suppressed similar records", "count", l.suppressed, "record", l.text)
+		}
+		delete(s.lines, key)
+	}
+}
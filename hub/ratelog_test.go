@@ -0,0 +1,37 @@
+package hub
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogSamplerCollapsesSimilarRecords(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	prev := slog.Default()
+	slog.SetDefault(l)
+	defer slog.SetDefault(prev)
+
+	s := newLogSampler(50 * time.Millisecond)
+	s.Warn(l, "read failed", "err", "dial 10.0.0.1:4001")
+	s.Warn(l, "read failed", "err", "dial 10.0.0.2:4312")
+	s.Warn(l, "read failed", "err", "dial 10.0.0.3:5000")
+	s.Warn(l, "write failed", "err", "timeout")
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Fatalf("logged %d lines, want 2:\n%s", n, buf.String())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	buf.Reset()
+	s.Warn(l, "read failed", "err", "dial 10.0.0.4:6000")
+	out := buf.String()
+	if !strings.Contains(out, "suppressed similar records") || !strings.Contains(out, "count=2") {
+		t.Errorf("after the window, got:\n%s", out)
+	}
+	if !strings.Contains(out, "10.0.0.4") {
+		t.Errorf("record after the window was suppressed:\n%s", out)
+	}
+}
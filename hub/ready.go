@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// readiness gates WebSocket upgrades until the server has finished loading
+// its initial state. Until then upgrades are refused with 503, or held until
+// ready when queue is set.
+type readiness struct {
+	ready chan struct{}
+	queue bool
+}
+
+func newReadiness(queue bool) *readiness {
+	return &readiness{ready: make(chan struct{}), queue: queue}
+}
+
+func (rd *readiness) isReady() bool {
+	select {
+	case <-rd.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rd *readiness) markReady() { close(rd.ready) }
+
+// wait reports whether r may proceed, writing the refusal when it may not.
+func (rd *readiness) wait(w http.ResponseWriter, r *http.Request) bool {
+	if rd.isReady() {
+		return true
+	}
+	if rd.queue {
+		select {
+		case <-rd.ready:
+			return true
+		case <-r.Context().Done():
+			return false
+		}
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "server is starting", http.StatusServiceUnavailable)
+	return false
+}
+
+// warmUp loads every room saved in dir, when preload is set, waits out delay
+// and then marks the server ready, logging what was loaded.
+func (m *roomManager) warmUp(preload bool, delay time.Duration) {
+	start := time.Now()
+	rooms, points := 0, 0
+	if preload && m.dir != "" {
+		rooms, points = m.preload()
+	}
+	if d := delay - time.Since(start); d > 0 {
+		time.Sleep(d)
+	}
+	m.ready.markReady()
+	slog.Info("ready", "after", time.Since(start).Round(time.Millisecond), "points", points, "rooms", rooms)
+}
+
+// preload reloads every room unloaded to dir, returning how many rooms and
+// points were loaded.
+func (m *roomManager) preload() (int, int) {
+	paths, err := filepath.Glob(filepath.Join(m.dir, "*.json"))
+	if err != nil {
+		slog.Error("preload rooms failed", "err", err)
+		return 0, 0
+	}
+	rooms, points := 0, 0
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !roomNamePattern.MatchString(name) {
+			continue
+		}
+		h := m.acquire(name)
+		rooms++
+		points += h.pointCount()
+		m.release(h)
+	}
+	return rooms, points
+}
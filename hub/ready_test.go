@@ -0,0 +1,52 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnectionsWaitForReadiness(t *testing.T) {
+	s := newTestServer(t, WithStartupDelay(100*time.Millisecond, false))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?room=r"
+
+	if rec := get(s, "/healthz"); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "not ready") {
+		t.Errorf("healthz while starting: %d %s", rec.Code, rec.Body)
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial while starting: %v, %v; want 503", resp, err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if rec := get(s, "/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("healthz once ready: %d %s", rec.Code, rec.Body)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial once ready: %v", err)
+	}
+	conn.Close()
+}
+
+func TestQueuedConnectionsProceedOnceReady(t *testing.T) {
+	s := newTestServer(t, WithStartupDelay(100*time.Millisecond, true))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	start := time.Now()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?room=r", nil)
+	if err != nil {
+		t.Fatalf("queued dial: %v", err)
+	}
+	defer conn.Close()
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("connection accepted after %v, before the server was ready", waited)
+	}
+}
@@ -0,0 +1,261 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recordingLayout names recordings so that they sort by time.
+const recordingLayout = "20060102T150405.000Z"
+
+var recordingNamePattern = regexp.MustCompile(`^universe-\d{8}T\d{6}\.\d{3}Z\.json$`)
+
+// recording is a snapshot of every loaded room at one instant, as written
+// by the recorder.
+type recording struct {
+	Time  int64          `json:"time"`
+	Rooms []RoomSnapshot `json:"rooms"`
+}
+
+// recorder writes recordings to a directory every interval and on shutdown,
+// keeping the newest keep of them (0 for all) and none older than maxAge (0
+// for no limit).
+type recorder struct {
+	dir    string
+	keep   int
+	maxAge time.Duration
+	rooms  *roomManager
+}
+
+func (rec *recorder) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := rec.record(); err != nil {
+			slog.Error("record failed", "err", err)
+		}
+	}
+}
+
+// record writes a recording of the loaded rooms, leaving out points that
+// are not durable, applies the retention policy and returns the file name.
+func (rec *recorder) record() (string, error) {
+	now := time.Now().UTC()
+	out := recording{Time: now.UnixMilli(), Rooms: []RoomSnapshot{}}
+	for _, h := range rec.rooms.hubs() {
+		snap := h.snapshot()
+		kept := make([]Point, 0, len(snap.Points))
+		for _, p := range snap.Points {
+			if durable(p) {
+				kept = append(kept, p)
+			}
+		}
+		snap.Points = kept
+		out.Rooms = append(out.Rooms, snap)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return "", err
+	}
+	name := "universe-" + now.Format(recordingLayout) + ".json"
+	if err := writeFileAtomic(filepath.Join(rec.dir, name), data); err != nil {
+		return "", err
+	}
+	rec.prune(now)
+	return name, nil
+}
+
+// names lists the recordings in the directory, oldest first.
+func (rec *recorder) names() ([]string, error) {
+	entries, err := os.ReadDir(rec.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && recordingNamePattern.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func recordingTime(name string) time.Time {
+	t, _ := time.Parse(recordingLayout, strings.TrimSuffix(strings.TrimPrefix(name, "universe-"), ".json"))
+	return t
+}
+
+func (rec *recorder) prune(now time.Time) {
+	names, err := rec.names()
+	if err != nil {
+		slog.Error("prune recordings failed", "err", err)
+		return
+	}
+	for i, name := range names {
+		tooMany := rec.keep > 0 && len(names)-i > rec.keep
+		tooOld := rec.maxAge > 0 && now.Sub(recordingTime(name)) > rec.maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(filepath.Join(rec.dir, name)); err != nil {
+			slog.Error("prune recordings failed", "err", err)
+		}
+	}
+}
+
+// restore replaces the rooms in the named recording with their recorded
+// state, or only room when it is not empty, and returns the rooms restored.
+// Rooms the recording does not contain are left alone. Connected clients
+// get a reset followed by a fresh init.
+func (rec *recorder) restore(name, room string) ([]string, error) {
+	if !recordingNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid recording name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(rec.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var saved recording
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", name, err)
+	}
+	restored := []string{}
+	for _, snap := range saved.Rooms {
+		if room != "" && snap.Room != room {
+			continue
+		}
+		h := rec.rooms.acquire(snap.Room)
+		h.sequenced(func() { h.reset(snap) })
+		rec.rooms.release(h)
+		restored = append(restored, snap.Room)
+	}
+	if room != "" && len(restored) == 0 {
+		return nil, fmt.Errorf("%s does not contain room %s", name, room)
+	}
+	return restored, nil
+}
+
+// reset replaces the room with snap and resends the state to every client.
+// Sequence numbers keep increasing across the reset so that clients never
+// confuse the new state with the old. Must be called from within
+// h.sequenced.
+func (h *Hub) reset(snap RoomSnapshot) {
+	h.mu.Lock()
+	snap.Seq = h.seq + 1
+	h.mu.Unlock()
+	h.restore(snap)
+	h.mu.Lock()
+	if h.storage != nil {
+		h.storage.markDirty(h)
+	}
+	conns := make([]*client, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+	for _, c := range conns {
+		if err := h.sendReset(c, snap.Seq); err != nil {
+			h.hotLog.Warn(c.log, "reset init failed", "err", err)
+		}
+	}
+}
+
+// sendReset sends c a reset followed by the room's new state. Frames still
+// queued for c describe the old state and are dropped, so none of them can
+// follow the new init.
+func (h *Hub) sendReset(c *client, seq uint64) error {
+	if c.queue == nil {
+		if err := c.writeJSON(Message{Type: "reset", Seq: seq}); err != nil {
+			return err
+		}
+		return h.sendInit(c)
+	}
+	data, err := c.encode(Message{Type: "reset", Seq: seq})
+	if err != nil {
+		return err
+	}
+	frames := []frame{{data: data}}
+	err = h.encodeInit(c, func(data []byte) error {
+		frames = append(frames, frame{data: data})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !c.queue.replace(frames) {
+		return errSendQueueFull
+	}
+	return nil
+}
+
+// recordingsHandler lists the recordings (GET) or records one now (POST).
+func (rec *recorder) recordingsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		names, err := rec.names()
+		if err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, errBadRequest, err.Error())
+			return
+		}
+		type entry struct {
+			Name  string `json:"name"`
+			Time  int64  `json:"time"`
+			Bytes int64  `json:"bytes"`
+		}
+		out := []entry{}
+		for _, name := range names {
+			info, err := os.Stat(filepath.Join(rec.dir, name))
+			if err != nil {
+				continue
+			}
+			out = append(out, entry{name, recordingTime(name).UnixMilli(), info.Size()})
+		}
+		writeJSONResponse(w, rec.rooms.wire, http.StatusOK, out)
+	case http.MethodPost:
+		name, err := rec.record()
+		if err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, errBadRequest, err.Error())
+			return
+		}
+		writeJSONResponse(w, rec.rooms.wire, http.StatusCreated, struct {
+			Name string `json:"name"`
+		}{name})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+	}
+}
+
+// restoreHandler restores the recording named by ?name=, limited to the
+// room named by ?room= when given.
+func (rec *recorder) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	room := r.URL.Query().Get("room")
+	if room != "" && !roomNamePattern.MatchString(room) {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "invalid room name")
+		return
+	}
+	restored, err := rec.restore(r.URL.Query().Get("name"), room)
+	if os.IsNotExist(err) {
+		writeErrorResponse(w, http.StatusNotFound, errNotFound, "no such recording")
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	writeJSONResponse(w, rec.rooms.wire, http.StatusOK, struct {
+		Restored []string `json:"restored"`
+	}{restored})
+}
@@ -0,0 +1,190 @@
+package hub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redisBackplane publishes to and subscribes on one Redis pub/sub channel,
+// speaking just enough of the RESP protocol for PUBLISH and SUBSCRIBE. The
+// subscription holds its own connection, as Redis requires; both reconnect
+// after failures.
+type redisBackplane struct {
+	addr     string
+	password string
+	channel  string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newRedisBackplane parses a redis://[:password@]host[:port] URL.
+func newRedisBackplane(rawURL, channel string) (*redisBackplane, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("backplane URL must look like redis://host:6379, got %q", rawURL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	password, _ := u.User.Password()
+	return &redisBackplane{addr: addr, password: password, channel: channel}, nil
+}
+
+func (b *redisBackplane) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, 5*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	if b.password != "" {
+		if _, err := redisCall(conn, r, "AUTH", b.password); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, r, nil
+}
+
+func (b *redisBackplane) publish(data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// A connection that failed since the last publish is redialled once.
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.conn == nil {
+			conn, r, err := b.dial()
+			if err != nil {
+				return err
+			}
+			b.conn, b.r = conn, r
+		}
+		b.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err := redisCall(b.conn, b.r, "PUBLISH", b.channel, string(data))
+		if err == nil {
+			return nil
+		}
+		b.conn.Close()
+		b.conn = nil
+		if attempt == 1 {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *redisBackplane) subscribe(deliver func([]byte)) {
+	for {
+		if err := b.listen(deliver); err != nil {
+			slog.Warn("backplane subscription failed", "err", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (b *redisBackplane) listen(deliver func([]byte)) error {
+	conn, r, err := b.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := writeRedisCommand(conn, "SUBSCRIBE", b.channel); err != nil {
+		return err
+	}
+	slog.Info("backplane subscribed", "channel", b.channel, "addr", b.addr)
+	for {
+		v, err := readRedisValue(r)
+		if err != nil {
+			return err
+		}
+		// Pushes are ["message", channel, payload]; the subscribe
+		// confirmation and anything else are skipped.
+		if items, ok := v.([]interface{}); ok && len(items) == 3 && items[0] == "message" {
+			if payload, ok := items[2].(string); ok {
+				deliver([]byte(payload))
+			}
+		}
+	}
+}
+
+func redisCall(conn net.Conn, r *bufio.Reader, args ...string) (interface{}, error) {
+	if err := writeRedisCommand(conn, args...); err != nil {
+		return nil, err
+	}
+	return readRedisValue(r)
+}
+
+func writeRedisCommand(w io.Writer, args ...string) error {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(a))+"\r\n"...)
+		buf = append(buf, a...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readRedisValue reads one RESP value: simple strings and bulk strings as
+// string, integers as int64, arrays as []interface{} and nil bulks as nil.
+// Error replies are returned as errors.
+func readRedisValue(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[n] != '\r' || buf[n+1] != '\n' {
+			return nil, errors.New("redis: bulk string longer than its length")
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
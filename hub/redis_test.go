@@ -0,0 +1,211 @@
+package hub
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedisCommandFraming(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeRedisCommand(&buf, "PUBLISH", "room", "a\r\nb"); err != nil {
+		t.Fatal(err)
+	}
+	want := "*3\r\n$7\r\nPUBLISH\r\n$4\r\nroom\r\n$4\r\na\r\nb\r\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+	v, err := readRedisValue(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, []interface{}{"PUBLISH", "room", "a\r\nb"}) {
+		t.Fatalf("read back %#v", v)
+	}
+}
+
+func TestRedisReplies(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{":42\r\n", int64(42)},
+		{":-1\r\n", int64(-1)},
+		{"$0\r\n\r\n", ""},
+		{"$-1\r\n", nil},
+		{"*-1\r\n", nil},
+		{"*0\r\n", []interface{}{}},
+		{"*3\r\n$7\r\nmessage\r\n$1\r\nc\r\n$2\r\n{}\r\n", []interface{}{"message", "c", "{}"}},
+		{"*2\r\n*1\r\n:1\r\n$-1\r\n", []interface{}{[]interface{}{int64(1)}, nil}},
+	} {
+		got, err := readRedisValue(bufio.NewReader(strings.NewReader(tc.in)))
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q = %#v, want %#v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestRedisMalformedReplies(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"+OK\n",
+		"$3\r\nab\r\n",
+		"$2\r\nabc\r\n",
+		":x\r\n",
+		"*2\r\n+a\r\n",
+		"?\r\n",
+	} {
+		if v, err := readRedisValue(bufio.NewReader(strings.NewReader(in))); err == nil {
+			t.Errorf("%q = %#v, want an error", in, v)
+		}
+	}
+	_, err := readRedisValue(bufio.NewReader(strings.NewReader("-ERR wrong\r\n")))
+	if _, ok := err.(redisError); !ok || err.Error() != "redis: ERR wrong" {
+		t.Errorf("error reply = %v, want a redisError", err)
+	}
+}
+
+// fakeRedis serves PUBLISH, SUBSCRIBE and AUTH for one channel namespace.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu    sync.Mutex
+	conns []net.Conn
+	subs  map[string][]net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, subs: make(map[string][]net.Conn)}
+	t.Cleanup(func() {
+		ln.Close()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for _, c := range f.conns {
+			c.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		v, err := readRedisValue(r)
+		if err != nil {
+			return
+		}
+		args, _ := v.([]interface{})
+		if len(args) == 0 {
+			return
+		}
+		arg := func(i int) string { s, _ := args[i].(string); return s }
+		switch cmd := strings.ToUpper(arg(0)); {
+		case cmd == "AUTH":
+			if arg(1) != f.password {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+				continue
+			}
+			authed = true
+			conn.Write([]byte("+OK\r\n"))
+		case !authed:
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+		case cmd == "SUBSCRIBE":
+			f.mu.Lock()
+			f.subs[arg(1)] = append(f.subs[arg(1)], conn)
+			f.mu.Unlock()
+			writeRedisCommand(conn, "subscribe", arg(1))
+		case cmd == "PUBLISH":
+			f.mu.Lock()
+			subs := f.subs[arg(1)]
+			for _, c := range subs {
+				writeRedisCommand(c, "message", arg(1), arg(2))
+			}
+			f.mu.Unlock()
+			conn.Write([]byte(":" + strconv.Itoa(len(subs)) + "\r\n"))
+		}
+	}
+}
+
+func (f *fakeRedis) subscribers(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs[channel])
+}
+
+func TestRedisBackplanePublishAndSubscribe(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	b, err := newRedisBackplane("redis://:secret@"+f.ln.Addr().String(), "points")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string, 1)
+	go b.subscribe(func(data []byte) { got <- string(data) })
+	deadline := time.Now().Add(2 * time.Second)
+	for f.subscribers("points") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	payload := `{"room":"r","note":"line\r\nbreak"}`
+	if err := b.publish([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-got:
+		if data != payload {
+			t.Fatalf("delivered %q, want %q", data, payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("published payload was not delivered")
+	}
+}
+
+func TestRedisBackplaneRejectsWrongPassword(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	b, err := newRedisBackplane("redis://:nope@"+f.ln.Addr().String(), "points")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.publish([]byte("x")); err == nil {
+		t.Fatal("publish with the wrong password succeeded")
+	}
+}
+
+func TestRedisBackplaneURL(t *testing.T) {
+	b, err := newRedisBackplane("redis://cache", "c")
+	if err != nil || b.addr != "cache:6379" {
+		t.Errorf("default port: addr = %q, err = %v", b.addr, err)
+	}
+	for _, u := range []string{"http://cache:6379", "redis://", "redis://%zz"} {
+		if _, err := newRedisBackplane(u, "c"); err == nil {
+			t.Errorf("%q was accepted", u)
+		}
+	}
+}
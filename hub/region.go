@@ -0,0 +1,109 @@
+package hub
+
+// region is an axis-aligned box a client subscribed to; broadcasts about
+// points outside it are not sent to that client.
+type region struct {
+	min, max [3]float64
+}
+
+func (r *region) contains(p Point) bool {
+	v := [3]float64{p.X, p.Y, p.Z}
+	for i := range v {
+		if v[i] < r.min[i] || v[i] > r.max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *region) inside(ps []Point) []Point {
+	var kept []Point
+	for _, p := range ps {
+		if r.contains(p) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// clip narrows msg to the points inside r. It reports whether anything is
+// left to send and whether msg had to be rewritten to drop points. Moves are
+// kept when either end is inside so clients see points leave and enter.
+// Messages that are not about particular points pass unchanged.
+func (r *region) clip(msg Message) (Message, bool, bool) {
+	if r == nil {
+		return msg, true, false
+	}
+	ps, located := locate(msg)
+	if !located {
+		return msg, true, false
+	}
+	if pointBatches[msg.Type] {
+		kept := r.inside(ps)
+		if len(kept) == 0 {
+			return msg, false, false
+		}
+		if len(kept) == len(ps) {
+			return msg, true, false
+		}
+		msg.Points = kept
+		return msg, true, true
+	}
+	for _, p := range ps {
+		if r.contains(p) {
+			return msg, true, false
+		}
+	}
+	return msg, false, false
+}
+
+// pointBatches are the broadcasts a region trims to the points inside it.
+var pointBatches = map[string]bool{"addBatch": true, "removeBatch": true, "updateBatch": true, "select": true, "deselect": true, "simTick": true}
+
+// locate returns the points that decide whether msg is inside a region, or
+// false when msg is not about particular points.
+func locate(msg Message) ([]Point, bool) {
+	switch {
+	case msg.Type == "move":
+		if msg.From == nil || msg.To == nil {
+			return nil, false
+		}
+		return []Point{*msg.From, *msg.To}, true
+	case pointBatches[msg.Type]:
+		return msg.Points, true
+	case msg.Point != nil && msg.Type != "error":
+		return []Point{*msg.Point}, true
+	}
+	return nil, false
+}
+
+// setRegion replaces the box c receives broadcasts for; nil means
+// everywhere.
+func (c *client) setRegion(r *region) {
+	c.subMu.Lock()
+	c.region = r
+	c.subMu.Unlock()
+}
+
+func (c *client) subscribedRegion() *region {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+	return c.region
+}
+
+// parseRegion turns the min and max corners of a subscribe message into a
+// region; neither means everywhere.
+func parseRegion(lo, hi *[3]float64) (*region, *validationError) {
+	if lo == nil && hi == nil {
+		return nil, nil
+	}
+	if lo == nil || hi == nil {
+		return nil, invalid(errBadRequest, "a region needs both min and max")
+	}
+	for i := range lo {
+		if !(lo[i] <= hi[i]) {
+			return nil, invalid(errInvalidCoords, "region min must not exceed max")
+		}
+	}
+	return &region{min: *lo, max: *hi}, nil
+}
@@ -0,0 +1,244 @@
+package hub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+func loadAuditEntries(path, room string) ([]auditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []auditEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Room == room {
+			out = append(out, e)
+		}
+	}
+	return out, sc.Err()
+}
+
+func replayKey(p Point) string {
+	if p.ID != "" {
+		return p.ID
+	}
+	return CoordKey(p, defaultKeyDecimals)
+}
+
+// replayState rebuilds the room as it was after the first n entries.
+func replayState(entries []auditEntry, n int) []Point {
+	state := make(map[string]Point)
+	var order []string
+	for _, e := range entries[:n] {
+		key := replayKey(e.Point)
+		switch e.Op {
+		case "add", "update":
+			if _, exists := state[key]; !exists {
+				order = append(order, key)
+			}
+			state[key] = e.Point
+		case "remove":
+			delete(state, key)
+		case "move":
+			if e.From != nil {
+				delete(state, replayKey(*e.From))
+			}
+			if _, exists := state[key]; !exists {
+				order = append(order, key)
+			}
+			state[key] = e.Point
+		}
+	}
+	out := make([]Point, 0, len(state))
+	for _, key := range order {
+		if p, ok := state[key]; ok {
+			out = append(out, p)
+			delete(state, key)
+		}
+	}
+	return out
+}
+
+// replayHandler streams a room's recorded mutations from the audit log with
+// their original spacing, divided by the speed query parameter. The from and
+// to parameters, in unix milliseconds, limit playback to that period: the
+// init frame holds the room as it was at from and playback ends after the
+// last mutation at or before to. Clients may
+// send pause, resume and seek (with at, in milliseconds from the start of the
+// recording); every other message is rejected since replay is read-only.
+func (m *roomManager) replayHandler(auditPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room := r.URL.Query().Get("room")
+		if room == "" {
+			room = defaultRoom
+		}
+		if _, _, ok := m.authorize(w, r, room, roleViewer); !ok {
+			return
+		}
+		speed := 1.0
+		if s := r.URL.Query().Get("speed"); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || v <= 0 {
+				http.Error(w, "invalid speed", http.StatusBadRequest)
+				return
+			}
+			speed = v
+		}
+		var bounds [2]int64
+		for i, name := range [...]string{"from", "to"} {
+			if s := r.URL.Query().Get(name); s != "" {
+				v, err := strconv.ParseInt(s, 10, 64)
+				if err != nil {
+					http.Error(w, "invalid "+name, http.StatusBadRequest)
+					return
+				}
+				bounds[i] = v
+			}
+		}
+		from, to := bounds[0], bounds[1]
+		entries, err := loadAuditEntries(auditPath, room)
+		if err != nil {
+			http.Error(w, "cannot read audit log", http.StatusInternalServerError)
+			slog.Warn("replay load failed", "err", err)
+			return
+		}
+		if to > 0 {
+			end := 0
+			for end < len(entries) && entries[end].Time <= to {
+				end++
+			}
+			entries = entries[:end]
+		}
+		start := 0
+		for start < len(entries) && entries[start].Time < from {
+			start++
+		}
+
+		conn, err := m.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			m.hotLog.Warn(slog.Default(), "upgrade failed", "err", err)
+			return
+		}
+		c := &client{id: fmt.Sprintf("c%d", connSeq.Add(1)), conn: conn, wire: m.wire, totals: m.metrics.totalsFor(false)}
+		c.log = slog.With("conn", c.id, "room", room)
+		defer conn.Close()
+
+		controls := make(chan Message)
+		go func() {
+			defer close(controls)
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				var msg Message
+				if err := m.wire.unmarshal(data, &msg); err != nil {
+					return
+				}
+				switch msg.Type {
+				case "pause", "resume", "seek":
+					controls <- msg
+				default:
+					if c.replyError(invalid(errReadOnly, "replay does not accept mutations")) != nil {
+						return
+					}
+				}
+			}
+		}()
+
+		var origin int64
+		if len(entries) > 0 {
+			origin = entries[0].Time
+		}
+		if err := c.writeJSON(Message{Type: "init", Points: replayState(entries, start), StartTime: origin}); err != nil {
+			return
+		}
+		if start == len(entries) {
+			c.writeJSON(Message{Type: "replayEnd"})
+		}
+
+		next, paused := start, false
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		schedule := func() {
+			if paused || next >= len(entries) {
+				return
+			}
+			var gap time.Duration
+			if next > 0 {
+				gap = time.Duration(float64(entries[next].Time-entries[next-1].Time)/speed) * time.Millisecond
+			}
+			timer.Reset(gap)
+		}
+		stop := func() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		for {
+			select {
+			case msg, ok := <-controls:
+				if !ok {
+					return
+				}
+				switch msg.Type {
+				case "pause":
+					paused = true
+					stop()
+				case "resume":
+					if paused {
+						paused = false
+						schedule()
+					}
+				case "seek":
+					stop()
+					next = 0
+					for next < len(entries) && entries[next].Time-origin <= msg.At {
+						next++
+					}
+					if err := c.writeJSON(Message{Type: "init", Points: replayState(entries, next), StartTime: origin}); err != nil {
+						return
+					}
+					schedule()
+				}
+			case <-timer.C:
+				if next >= len(entries) {
+					continue
+				}
+				e := entries[next]
+				p := e.Point
+				msg := Message{Type: e.Op, Point: &p}
+				if e.Op == "move" {
+					msg = Message{Type: e.Op, From: e.From, To: &p}
+				}
+				if err := c.writeJSON(msg); err != nil {
+					return
+				}
+				next++
+				if next == len(entries) {
+					c.writeJSON(Message{Type: "replayEnd"})
+					continue
+				}
+				schedule()
+			}
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package hub
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+type itemResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Point  *Point `json:"point,omitempty"`
+}
+
+type errorBody struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func writeJSONResponse(w http.ResponseWriter, wire wireEncoding, status int, v interface{}) {
+	body, err := wire.marshal(v)
+	if err != nil {
+		slog.Error("response marshal failed", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		slog.Warn("response write failed", "err", err)
+	}
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, code, reason string) {
+	// The keys of an error body read the same in either case.
+	writeJSONResponse(w, wireEncoding{}, status, errorBody{Code: code, Reason: reason})
+}
+
+// roomFromRequest resolves the room query parameter, writing a 400 and
+// returning false when it is invalid.
+func roomFromRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.URL.Query().Get("room")
+	if name == "" {
+		name = defaultRoom
+	}
+	if !roomNamePattern.MatchString(name) {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "invalid room name")
+		return "", false
+	}
+	return name, true
+}
+
+// decodePoints accepts either a single point object or an array of points
+// and reports which form was used.
+func decodePoints(wire wireEncoding, body []byte) ([]Point, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var ps []Point
+		err := wire.unmarshal(body, &ps)
+		return ps, true, err
+	}
+	var p Point
+	if err := wire.unmarshal(body, &p); err != nil {
+		return nil, false, err
+	}
+	return []Point{p}, false, nil
+}
+
+// pointsHandler serves /points and /api/points: GET lists a room's points,
+// POST adds and DELETE removes them, broadcasting like the WebSocket
+// messages would.
+func (m *roomManager) pointsHandler(w http.ResponseWriter, r *http.Request) {
+	need := roleEditor
+	switch r.Method {
+	case http.MethodGet:
+		need = roleViewer
+	case http.MethodPost, http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+		return
+	}
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	identity, access, ok := m.authorize(w, r, name, need)
+	if !ok {
+		return
+	}
+	h := m.acquire(name)
+	defer m.release(h)
+	switch r.Method {
+	case http.MethodGet:
+		writeJSONResponse(w, m.wire, http.StatusOK, struct {
+			Room   string  `json:"room"`
+			Points []Point `json:"points"`
+		}{h.room, h.snapshotPoints()})
+	case http.MethodPost:
+		h.restAdd(w, r, identity)
+	case http.MethodDelete:
+		h.restRemove(w, r, identity, access)
+	}
+}
+
+// restRemove removes the points in the body, a single point or an array
+// addressed like WebSocket removes, and reports the ones removed. Points
+// locked by a connection, or owned by another caller where only owners may
+// remove points, are left in place and counted.
+func (h *Hub) restRemove(w http.ResponseWriter, r *http.Request, identity string, access role) {
+	if h.config.ReadOnly {
+		writeErrorResponse(w, http.StatusForbidden, errReadOnly, "room is read-only")
+		return
+	}
+	if h.maxMessageBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxMessageBytes)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrorResponse(w, http.StatusRequestEntityTooLarge, errBadRequest, err.Error())
+		return
+	}
+	ps, _, err := decodePoints(h.wire, body)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	if err := h.checkBatch(len(ps)); err != nil {
+		writeErrorResponse(w, http.StatusRequestEntityTooLarge, err.Code, err.Reason)
+		return
+	}
+	actor := restActor(identity, r.RemoteAddr)
+	var removed []Point
+	var locked, foreign int
+	h.sequenced(func() {
+		if !h.removesAny(access) {
+			ps, foreign = h.ownedOnly(ps, actor)
+		}
+		removed, locked = h.removePoints(ps, actor)
+		h.afterRemove(removed)
+		switch len(removed) {
+		case 0:
+		case 1:
+			h.broadcast(Message{Type: "remove", Point: &removed[0]})
+		default:
+			h.broadcast(Message{Type: "removeBatch", Points: removed})
+		}
+	})
+	if removed == nil {
+		removed = []Point{}
+	}
+	writeJSONResponse(w, h.wire, http.StatusOK, struct {
+		Removed int     `json:"removed"`
+		Locked  int     `json:"locked,omitempty"`
+		Foreign int     `json:"foreign,omitempty"`
+		Points  []Point `json:"points"`
+	}{len(removed), locked, foreign, removed})
+}
+
+// restAdd adds the posted points and reports a result per point. A single
+// point answers 201, 400 or 409; a batch answers 201 when every point was
+// added and 207 with per-item results otherwise.
+//
+// A request carrying an Idempotency-Key header that was already seen is not
+// applied again; the original response is repeated instead.
+func (h *Hub) restAdd(w http.ResponseWriter, r *http.Request, identity string) {
+	if h.config.ReadOnly {
+		writeErrorResponse(w, http.StatusForbidden, errReadOnly, "room is read-only")
+		return
+	}
+	idemKey := r.Header.Get("Idempotency-Key")
+	if idemKey != "" {
+		if prev, seen := h.idem.begin(idemKey); seen {
+			if !prev.done {
+				writeErrorResponse(w, http.StatusConflict, errDuplicate, "a request with this idempotency key is in progress")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(prev.status)
+			w.Write(prev.body)
+			return
+		}
+		// Requests rejected before any point is applied may be retried.
+		defer func() {
+			if !h.idem.isDone(idemKey) {
+				h.idem.forget(idemKey)
+			}
+		}()
+	}
+	respond := func(status int, v interface{}) {
+		body, err := h.wire.marshal(v)
+		if err != nil {
+			slog.Error("response marshal failed", "err", err)
+			return
+		}
+		body = append(body, '\n')
+		if idemKey != "" {
+			h.idem.finish(idemKey, status, body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+
+	if h.maxMessageBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxMessageBytes)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeErrorResponse(w, http.StatusRequestEntityTooLarge, errBadRequest, err.Error())
+		return
+	}
+	ps, isBatch, err := decodePoints(h.wire, body)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	if h.maxBatch > 0 && len(ps) > h.maxBatch {
+		reason := fmt.Sprintf("batch of %d points exceeds the limit of %d", len(ps), h.maxBatch)
+		writeErrorResponse(w, http.StatusRequestEntityTooLarge, errBatchTooLarge, reason)
+		return
+	}
+
+	actor := restActor(identity, r.RemoteAddr)
+	if isBatch && r.URL.Query().Get("atomic") == "true" {
+		h.restAddAtomic(ps, actor, respond)
+		return
+	}
+
+	results := make([]itemResult, len(ps))
+	valid := make([]Point, 0, len(ps))
+	validIdx := make([]int, 0, len(ps))
+	for i, p := range ps {
+		results[i].Index = i
+		if err := validatePoint(p, h.meta); err != nil {
+			results[i].Status = "rejected"
+			results[i].Code = err.Code
+			results[i].Reason = err.Reason
+			continue
+		}
+		valid = append(valid, p)
+		validIdx = append(validIdx, i)
+	}
+
+	var added []Point
+	h.sequenced(func() {
+		stored, errs := h.addPointsEach(valid, actor)
+		added = make([]Point, 0, len(stored))
+		for j, i := range validIdx {
+			p := stored[j]
+			results[i].Point = &p
+			if errs[j] == nil {
+				results[i].Status = "added"
+				added = append(added, p)
+			} else {
+				results[i].Status = "rejected"
+				results[i].Code = errs[j].Code
+				results[i].Reason = errs[j].Reason
+			}
+		}
+		switch len(added) {
+		case 0:
+		case 1:
+			h.broadcast(Message{Type: "add", Point: &added[0]})
+		default:
+			h.broadcast(Message{Type: "addBatch", Points: added})
+		}
+	})
+
+	if !isBatch {
+		res := results[0]
+		switch {
+		case res.Status == "added":
+			respond(http.StatusCreated, res)
+		case res.Code == errDuplicate || res.Code == errTooClose:
+			respond(http.StatusConflict, res)
+		default:
+			respond(http.StatusBadRequest, res)
+		}
+		return
+	}
+	status := http.StatusCreated
+	if len(added) < len(ps) {
+		status = http.StatusMultiStatus
+	}
+	respond(status, struct {
+		Added    int          `json:"added"`
+		Rejected int          `json:"rejected"`
+		Results  []itemResult `json:"results"`
+	}{len(added), len(ps) - len(added), results})
+}
+
+// restAddAtomic adds a batch with ?atomic=true: either every point is added
+// or the room is left untouched and the offending entries are returned.
+func (h *Hub) restAddAtomic(ps []Point, actor string, respond func(int, interface{})) {
+	type batchResult struct {
+		Added    int          `json:"added"`
+		Rejected int          `json:"rejected"`
+		Results  []itemResult `json:"results"`
+	}
+	if rejected := rejectInvalid(ps, h.meta); len(rejected) > 0 {
+		respond(http.StatusBadRequest, batchResult{0, len(rejected), rejected})
+		return
+	}
+	var added []Point
+	var rejected []itemResult
+	h.sequenced(func() {
+		added, rejected = h.addPointsAtomic(ps, actor)
+		if len(added) > 0 {
+			h.broadcast(Message{Type: "addBatch", Points: added})
+		}
+	})
+	if len(rejected) > 0 {
+		respond(http.StatusConflict, batchResult{0, len(rejected), rejected})
+		return
+	}
+	results := make([]itemResult, len(added))
+	for i := range added {
+		results[i] = itemResult{Index: i, Status: "added", Point: &added[i]}
+	}
+	respond(http.StatusCreated, batchResult{len(added), 0, results})
+}
@@ -0,0 +1,43 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRESTAddReportsStructuredResults(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := do(s, "POST", "/points?room=r", `{"x":1,"color":"nope"}`)
+	var res itemResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest || res.Status != "rejected" || res.Code != errInvalidColor || res.Reason == "" {
+		t.Errorf("invalid point: %d %+v", rec.Code, res)
+	}
+
+	if rec := do(s, "POST", "/points?room=r", `{"x":1}`); rec.Code != http.StatusCreated {
+		t.Errorf("valid point: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(s, "POST", "/points?room=r", `{"x":1}`); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate point: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(s, "POST", "/points?room=r", `[{"x":2},{"x":3,"weight":-1}]`)
+	var batch struct {
+		Added    int          `json:"added"`
+		Rejected int          `json:"rejected"`
+		Results  []itemResult `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &batch); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusMultiStatus || batch.Added != 1 || batch.Rejected != 1 || len(batch.Results) != 2 {
+		t.Fatalf("mixed batch: %d %+v", rec.Code, batch)
+	}
+	if r := batch.Results[1]; r.Index != 1 || r.Status != "rejected" || r.Code != errInvalidWeight {
+		t.Errorf("rejected entry = %+v", r)
+	}
+}
@@ -0,0 +1,179 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+)
+
+// roomConfig holds the rules a room enforces. It is resolved once, when the
+// room is created.
+type roomConfig struct {
+	// GridStep snaps coordinates to multiples of the step (0 to disable).
+	GridStep float64 `json:"gridStep,omitempty"`
+	// TwoD flattens every point onto z=0.
+	TwoD bool `json:"twoD,omitempty"`
+	// MaxPoints caps the number of points in the room (0 for no cap).
+	MaxPoints int `json:"maxPoints,omitempty"`
+	// ReadOnly rejects every client mutation.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Codecs lists the accepted incoming frame formats, "json", "msgpack"
+	// and "binary" (move frames); empty accepts all.
+	Codecs []string `json:"codecs,omitempty"`
+}
+
+// roomConfigs is the parsed -room-config file: defaults for every room and
+// per-room overrides, where fields present in an override replace the
+// defaults.
+type roomConfigs struct {
+	Default roomConfig                 `json:"default"`
+	Rooms   map[string]json.RawMessage `json:"rooms"`
+}
+
+func loadRoomConfigs(path string) (*roomConfigs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rc roomConfigs
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	for name := range rc.Rooms {
+		if !roomNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s: invalid room name %q", path, name)
+		}
+		if _, err := rc.resolve(name); err != nil {
+			return nil, fmt.Errorf("%s: room %s: %v", path, name, err)
+		}
+	}
+	if err := rc.Default.validate(); err != nil {
+		return nil, fmt.Errorf("%s: default: %v", path, err)
+	}
+	return &rc, nil
+}
+
+func (rc *roomConfigs) resolve(name string) (roomConfig, error) {
+	if rc == nil {
+		return roomConfig{}, nil
+	}
+	cfg := rc.Default
+	cfg.Codecs = append([]string(nil), rc.Default.Codecs...)
+	if raw, ok := rc.Rooms[name]; ok {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return roomConfig{}, err
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+func (cfg roomConfig) validate() error {
+	if cfg.GridStep < 0 || math.IsNaN(cfg.GridStep) || math.IsInf(cfg.GridStep, 0) {
+		return fmt.Errorf("gridStep must be a finite non-negative number")
+	}
+	if cfg.MaxPoints < 0 {
+		return fmt.Errorf("maxPoints must not be negative")
+	}
+	for _, c := range cfg.Codecs {
+		if c != "json" && c != "msgpack" && c != "binary" {
+			return fmt.Errorf("unknown codec %q", c)
+		}
+	}
+	return nil
+}
+
+func (cfg roomConfig) accepts(codec string) bool {
+	if len(cfg.Codecs) == 0 {
+		return true
+	}
+	for _, c := range cfg.Codecs {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// place applies the room's grid and 2D rules to p's coordinates.
+func (cfg roomConfig) place(p Point) Point {
+	if s := cfg.GridStep; s > 0 {
+		p.X = math.Round(p.X/s) * s
+		p.Y = math.Round(p.Y/s) * s
+		p.Z = math.Round(p.Z/s) * s
+	}
+	if cfg.TwoD {
+		p.Z = 0
+	}
+	return p
+}
+
+// mutates reports whether a client message of type t changes room state.
+func mutates(t string) bool {
+	switch t {
+	case "add", "addIfAbsent", "addBatch", "remove", "removeBatch", "removePrefix",
+		"clear", "update", "updateBatch", "move", "lock", "unlock", "undo", "redo",
+		"generate", "pauseSimulation", "resumeSimulation":
+		return true
+	}
+	return false
+}
+
+type capabilities struct {
+	Room            string     `json:"room"`
+	Mode            string     `json:"mode"`
+	Config          roomConfig `json:"config"`
+	MinDistance     float64    `json:"minDistance,omitempty"`
+	MaxBatch        int        `json:"maxBatch,omitempty"`
+	MaxMessageBytes int64      `json:"maxMessageBytes,omitempty"`
+	MoveQuantum     float64    `json:"moveQuantum"`
+	KeyDecimals     int        `json:"keyDecimals,omitempty"`
+	Transform       bool       `json:"transform,omitempty"`
+	Compression     bool       `json:"compression"`
+	Simulation      *simState  `json:"simulation,omitempty"`
+	ChatMaxLength   int        `json:"chatMaxLength,omitempty"`
+}
+
+func (h *Hub) capabilities() capabilities {
+	return capabilities{
+		Room:            h.room,
+		Mode:            h.mode(),
+		Config:          h.config,
+		MinDistance:     h.minDistance,
+		MaxBatch:        h.maxBatch,
+		MaxMessageBytes: h.maxMessageBytes,
+		MoveQuantum:     h.moveQuantum,
+		KeyDecimals:     h.keyDecimals,
+		Transform:       h.transform != nil,
+		Compression:     h.upgrader.EnableCompression,
+		Simulation:      h.simulationState(),
+		ChatMaxLength:   h.chatMaxLength(),
+	}
+}
+
+// capabilitiesHandler reports the effective rules of the room named by
+// ?room=, creating it if needed.
+func (m *roomManager) capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	h := m.acquire(name)
+	defer m.release(h)
+	writeJSONResponse(w, m.wire, http.StatusOK, h.capabilities())
+}
+
+// roomsHandler lists the loaded rooms with their occupancy and rules.
+func (m *roomManager) roomsHandler(w http.ResponseWriter, r *http.Request) {
+	type roomInfo struct {
+		capabilities
+		Conns  int `json:"conns"`
+		Points int `json:"points"`
+	}
+	out := []roomInfo{}
+	for _, h := range m.hubs() {
+		out = append(out, roomInfo{capabilities: h.capabilities(), Conns: h.connCount(), Points: h.pointCount()})
+	}
+	writeJSONResponse(w, m.wire, http.StatusOK, out)
+}
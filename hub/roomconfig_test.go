@@ -0,0 +1,80 @@
+package hub
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoomsEnforceTheirOwnConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.json")
+	err := os.WriteFile(path, []byte(`{
+		"default": {"maxPoints": 2},
+		"rooms": {
+			"voxels": {"gridStep": 1, "twoD": true},
+			"showcase": {"readOnly": true, "codecs": ["json"]}
+		}
+	}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without send queues, replies are written straight to the fake
+	// connections.
+	s := newTestServer(t, WithRoomConfig(path), WithSendQueue(0))
+
+	s.Room("voxels", func(h *Hub) {
+		c, fc := testClient(h)
+		send(t, h, c, Message{Type: "add", Point: &Point{X: 1.4, Y: 2.6, Z: 5}})
+		if _, ok := h.points[h.key(Point{X: 1, Y: 3})]; !ok {
+			t.Errorf("voxel room stored %+v, want the point snapped to (1, 3, 0)", h.points)
+		}
+		send(t, h, c, Message{Type: "add", Point: &Point{X: 4}})
+		send(t, h, c, Message{Type: "add", Point: &Point{X: 8}})
+		if code := errorCode(t, fc); code != errRoomFull {
+			t.Errorf("third point in a room of two: error %q, want %q", code, errRoomFull)
+		}
+	})
+	s.Room("showcase", func(h *Hub) {
+		c, fc := testClient(h)
+		send(t, h, c, Message{Type: "add", Point: &Point{X: 1.4}})
+		if code := errorCode(t, fc); code != errReadOnly || len(h.points) != 0 {
+			t.Errorf("add to the read-only room: error %q, points %+v", code, h.points)
+		}
+		if h.config.accepts("binary") || !h.config.accepts("json") {
+			t.Errorf("showcase codecs = %v", h.config.Codecs)
+		}
+	})
+	s.Room("other", func(h *Hub) {
+		c, _ := testClient(h)
+		send(t, h, c, Message{Type: "add", Point: &Point{X: 1.4, Z: 5}})
+		if _, ok := h.points[h.key(Point{X: 1.4, Z: 5})]; !ok {
+			t.Errorf("default room stored %+v, want the point as sent", h.points)
+		}
+	})
+
+	var caps capabilities
+	if err := json.Unmarshal(get(s, "/capabilities?room=voxels").Body.Bytes(), &caps); err != nil {
+		t.Fatal(err)
+	}
+	if caps.Config.GridStep != 1 || !caps.Config.TwoD || caps.Config.MaxPoints != 2 || caps.Config.ReadOnly {
+		t.Errorf("voxel capabilities report %+v", caps.Config)
+	}
+}
+
+func TestRoomConfigRejectsBadRules(t *testing.T) {
+	for _, doc := range []string{
+		`{"default": {"gridStep": -1}}`,
+		`{"rooms": {"a": {"codecs": ["xml"]}}}`,
+		`{"rooms": {"bad name!": {}}}`,
+		`{"rooms": {"a": {"maxPoints": -3}}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "rooms.json")
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadRoomConfigs(path); err == nil {
+			t.Errorf("loaded %s", doc)
+		}
+	}
+}
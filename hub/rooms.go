@@ -0,0 +1,221 @@
+package hub
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultRoom = "default"
+
+var roomNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+type room struct {
+	hub       *Hub
+	refs      int
+	idleSince time.Time
+}
+
+// roomManager lazily creates one hub per room name. Every user of a hub holds
+// a reference through acquire/release so that a room is only reclaimed while
+// nobody is using it.
+//
+// When dir is set, rooms that stay idle are written there and evicted from
+// memory, then reloaded the next time they are acquired.
+type roomManager struct {
+	mu        sync.Mutex
+	rooms     map[string]*room
+	newHub    func(name string) *Hub
+	lastCheck *checkResult
+	observers []func(Mutation)
+	publish   func(Mutation)
+	dir       string
+	ready     *readiness
+
+	// jwt, when set, verifies callers' tokens. acl then restricts what each
+	// identity may do in each room; without one, verified callers may do
+	// anything and callers without a token get the anonymous role.
+	acl       *accessList
+	jwt       *jwtVerifier
+	anonymous role
+
+	// The server-wide parts of its rooms, for the handlers outside them.
+	wire     wireEncoding
+	upgrader *websocket.Upgrader
+	hotLog   *logSampler
+	metrics  *serverMetrics
+}
+
+func newRoomManager(newHub func(name string) *Hub) *roomManager {
+	return &roomManager{
+		rooms:    make(map[string]*room),
+		newHub:   newHub,
+		ready:    newReadiness(false),
+		upgrader: newUpgrader(false, nil),
+		hotLog:   newLogSampler(10 * time.Second),
+		metrics:  new(serverMetrics),
+	}
+}
+
+func (m *roomManager) acquire(name string) *Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rooms[name]
+	if !ok {
+		r = &room{hub: m.newHub(name)}
+		m.reload(r.hub)
+		for _, fn := range m.observers {
+			r.hub.OnMutation(fn)
+		}
+		r.hub.setPublisher(m.publish)
+		m.rooms[name] = r
+	}
+	r.refs++
+	return r.hub
+}
+
+func (m *roomManager) release(h *Hub) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rooms[h.room]
+	if !ok || r.hub != h {
+		return
+	}
+	r.refs--
+	if r.refs == 0 {
+		r.idleSince = time.Now()
+	}
+}
+
+// OnMutation registers fn with every current and future room.
+func (m *roomManager) OnMutation(fn func(Mutation)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observers = append(m.observers, fn)
+	for _, r := range m.rooms {
+		r.hub.OnMutation(fn)
+	}
+}
+
+func (m *roomManager) hubs() []*Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.rooms))
+	for name := range m.rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]*Hub, 0, len(names))
+	for _, name := range names {
+		out = append(out, m.rooms[name].hub)
+	}
+	return out
+}
+
+// compactRooms drops rooms that have had no users and no points for at
+// least ttl and reports how many were reclaimed.
+func (m *roomManager) compactRooms(now time.Time, ttl time.Duration) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reclaimed := 0
+	for name, r := range m.rooms {
+		if r.refs > 0 || now.Sub(r.idleSince) < ttl || r.hub.pointCount() > 0 {
+			continue
+		}
+		delete(m.rooms, name)
+		r.hub.close()
+		reclaimed++
+	}
+	return reclaimed
+}
+
+func (m *roomManager) unloadPath(name string) string {
+	return filepath.Join(m.dir, name+".json")
+}
+
+// reload restores a previously unloaded room into h and removes its file. A
+// file that cannot be read is set aside rather than overwritten later.
+func (m *roomManager) reload(h *Hub) {
+	if m.dir == "" {
+		return
+	}
+	path := m.unloadPath(h.room)
+	snap, ok, err := loadSnapshotFile(path)
+	if err != nil {
+		slog.Error("reload room failed", "room", h.room, "err", err)
+		if err := os.Rename(path, path+".bad"); err != nil {
+			slog.Error("set aside failed", "path", path, "err", err)
+		}
+		return
+	}
+	if !ok {
+		return
+	}
+	h.restore(snap)
+	if err := os.Remove(path); err != nil {
+		slog.Error("remove failed", "path", path, "err", err)
+	}
+	slog.Info("reloaded room", "room", h.room, "points", len(snap.Points))
+}
+
+// unloadIdle writes rooms that have had no users for at least after to disk
+// and evicts them, reporting how many were unloaded. Empty rooms are left to
+// compactRooms.
+func (m *roomManager) unloadIdle(now time.Time, after time.Duration) int {
+	if m.dir == "" {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	unloaded := 0
+	for name, r := range m.rooms {
+		if r.refs > 0 || now.Sub(r.idleSince) < after {
+			continue
+		}
+		snap := r.hub.snapshot()
+		if len(snap.Points) == 0 {
+			continue
+		}
+		if err := saveSnapshotFile(m.unloadPath(name), snap); err != nil {
+			slog.Error("unload room failed", "room", name, "err", err)
+			continue
+		}
+		delete(m.rooms, name)
+		r.hub.close()
+		unloaded++
+	}
+	return unloaded
+}
+
+func (m *roomManager) wsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("room")
+	if name == "" {
+		name = defaultRoom
+	}
+	if !roomNamePattern.MatchString(name) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	identity, access, granted, ok := m.authorizeLimited(w, r, name, roleViewer)
+	if !ok {
+		return
+	}
+	// Spectators receive everything but may not change anything.
+	spectator := r.URL.Query().Get("mode") == "view"
+	if spectator && access > roleViewer {
+		access = roleViewer
+	}
+	if !m.ready.wait(w, r) {
+		return
+	}
+	h := m.acquire(name)
+	defer m.release(h)
+	h.wsHandler(w, r, identity, access, granted, spectator)
+}
@@ -0,0 +1,60 @@
+package hub
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompactRoomsDropsOnlyIdleEmptyRooms(t *testing.T) {
+	m := newRoomManager(newHub)
+	empty := m.acquire("empty")
+	m.release(empty)
+	full := m.acquire("full")
+	full.addPoint(Point{X: 1}, "test")
+	m.release(full)
+	busy := m.acquire("busy")
+	defer m.release(busy)
+
+	if n := m.compactRooms(time.Now(), time.Hour); n != 0 {
+		t.Errorf("reclaimed %d rooms idle for less than the ttl", n)
+	}
+	if n := m.compactRooms(time.Now().Add(2*time.Hour), time.Hour); n != 1 {
+		t.Errorf("reclaimed %d rooms, want only the empty one", n)
+	}
+	for _, h := range m.hubs() {
+		if h.room == "empty" {
+			t.Error("idle empty room kept")
+		}
+	}
+	if got := len(m.hubs()); got != 2 {
+		t.Errorf("%d rooms left, want the full and the busy one", got)
+	}
+}
+
+func TestIdleRoomIsUnloadedAndReloaded(t *testing.T) {
+	m := newRoomManager(newHub)
+	m.dir = t.TempDir()
+	h := m.acquire("idle")
+	h.addPoint(Point{X: 1, Label: "kept"}, "test")
+	m.release(h)
+
+	if n := m.unloadIdle(time.Now().Add(2*time.Hour), time.Hour); n != 1 {
+		t.Fatalf("unloaded %d rooms, want 1", n)
+	}
+	if _, err := os.Stat(m.unloadPath("idle")); err != nil {
+		t.Fatalf("no snapshot written: %v", err)
+	}
+	if len(m.hubs()) != 0 {
+		t.Fatal("unloaded room still loaded")
+	}
+
+	h = m.acquire("idle")
+	defer m.release(h)
+	if ps := h.Points(); len(ps) != 1 || ps[0].Label != "kept" {
+		t.Errorf("reloaded room holds %+v", ps)
+	}
+	if _, err := os.Stat(m.unloadPath("idle")); !os.IsNotExist(err) {
+		t.Errorf("snapshot left behind after reload: %v", err)
+	}
+}
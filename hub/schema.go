@@ -0,0 +1,102 @@
+package hub
+
+import (
+	"sort"
+	"strings"
+)
+
+// messageFields reports whether a client-settable field of message is set.
+// Fields only the server sends are not checked, nor is requestId, which any
+// message may carry.
+var messageFields = map[string]func(m Message) bool{
+	"point":          func(m Message) bool { return m.Point != nil },
+	"points":         func(m Message) bool { return m.Points != nil },
+	"path":           func(m Message) bool { return m.Path != "" },
+	"from":           func(m Message) bool { return m.From != nil },
+	"to":             func(m Message) bool { return m.To != nil },
+	"updates":        func(m Message) bool { return m.Updates != nil },
+	"types":          func(m Message) bool { return m.Types != nil },
+	"min":            func(m Message) bool { return m.Min != nil },
+	"max":            func(m Message) bool { return m.Max != nil },
+	"weight":         func(m Message) bool { return m.Weight != nil },
+	"color":          func(m Message) bool { return m.Color != nil },
+	"label":          func(m Message) bool { return m.Label != nil },
+	"nickname":       func(m Message) bool { return m.Nickname != nil },
+	"camera":         func(m Message) bool { return m.Camera != nil },
+	"pinned":         func(m Message) bool { return m.Pinned != nil },
+	"velocity":       func(m Message) bool { return m.Velocity != nil },
+	"generate":       func(m Message) bool { return m.Generate != nil },
+	"text":           func(m Message) bool { return m.Text != "" },
+	"meta":           func(m Message) bool { return m.Meta != nil },
+	"metaMode":       func(m Message) bool { return m.MetaMode != "" },
+	"atomic":         func(m Message) bool { return m.Atomic },
+	"since":          func(m Message) bool { return m.Since != nil },
+	"idempotencyKey": func(m Message) bool { return m.IdempotencyKey != "" },
+}
+
+// messageShape lists the fields a message type must carry and the ones it
+// may carry; any other client-settable field is rejected.
+type messageShape struct {
+	required []string
+	optional []string
+}
+
+// messageShapes is the contract for every message type clients may send.
+var messageShapes = map[string]messageShape{
+	"add":            {required: []string{"point"}, optional: []string{"idempotencyKey"}},
+	"addIfAbsent":    {required: []string{"point"}, optional: []string{"idempotencyKey"}},
+	"addBatch":       {required: []string{"points"}, optional: []string{"atomic", "idempotencyKey"}},
+	"remove":         {required: []string{"point"}},
+	"removeBatch":    {required: []string{"points"}},
+	"removePrefix":   {required: []string{"path"}},
+	"clear":          {},
+	"select":         {optional: []string{"point", "points"}},
+	"deselect":       {optional: []string{"point", "points"}},
+	"clearSelection": {},
+	"subscribe":      {optional: []string{"types", "min", "max"}},
+	"signal":         {required: []string{"point"}},
+	"sync":           {required: []string{"since"}},
+	"update":         {required: []string{"point"}, optional: []string{"weight", "color", "label", "pinned", "velocity", "meta", "metaMode"}},
+	"updateBatch":    {required: []string{"updates"}},
+	"move":           {required: []string{"from", "to"}},
+	"undo":           {},
+	"presence":       {optional: []string{"nickname", "color", "camera"}},
+	"redo":           {},
+	"lock":           {required: []string{"point"}},
+	"unlock":         {optional: []string{"point"}},
+
+	"generate":         {required: []string{"generate"}},
+	"pauseSimulation":  {},
+	"resumeSimulation": {},
+	"chat":             {required: []string{"text"}, optional: []string{"nickname"}},
+}
+
+// checkShape rejects messages of an unknown type and ones whose fields do
+// not match messageShapes, naming what is missing or not allowed.
+func checkShape(m Message) *validationError {
+	shape, ok := messageShapes[m.Type]
+	if !ok {
+		return invalid(errInvalidMessage, "unknown message type %q", m.Type)
+	}
+	allowed := make(map[string]bool, len(shape.required)+len(shape.optional))
+	for _, f := range shape.required {
+		if !messageFields[f](m) {
+			return invalid(errInvalidMessage, "%s requires %s", m.Type, f)
+		}
+		allowed[f] = true
+	}
+	for _, f := range shape.optional {
+		allowed[f] = true
+	}
+	var extra []string
+	for f, set := range messageFields {
+		if !allowed[f] && set(m) {
+			extra = append(extra, f)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return invalid(errInvalidMessage, "%s does not take %s", m.Type, strings.Join(extra, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package hub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckShape(t *testing.T) {
+	p := &Point{X: 1}
+	label := "a"
+	for _, tc := range []struct {
+		msg    Message
+		reason string // empty when the message is well formed
+	}{
+		{Message{Type: "add", Point: p}, ""},
+		{Message{Type: "add"}, "add requires point"},
+		{Message{Type: "add", Point: p, Points: []Point{*p}}, "add does not take points"},
+		{Message{Type: "addBatch", Points: []Point{*p}, Atomic: true}, ""},
+		{Message{Type: "addBatch", Point: p}, "addBatch requires points"},
+		{Message{Type: "move", From: p}, "move requires to"},
+		{Message{Type: "move", From: p, To: p, Label: &label}, "move does not take label"},
+		{Message{Type: "update", Point: p, Label: &label}, ""},
+		{Message{Type: "update", Point: p, From: p, To: p}, "update does not take from, to"},
+		{Message{Type: "remove", Point: p, IdempotencyKey: "k"}, "remove does not take idempotencyKey"},
+		{Message{Type: "clear", Point: p}, "clear does not take point"},
+		{Message{Type: "select"}, ""},
+		{Message{Type: "teleport", Point: p}, `unknown message type "teleport"`},
+	} {
+		err := checkShape(tc.msg)
+		switch {
+		case tc.reason == "" && err != nil:
+			t.Errorf("%+v rejected: %s", tc.msg, err.Reason)
+		case tc.reason != "" && (err == nil || err.Code != errInvalidMessage || !strings.Contains(err.Reason, tc.reason)):
+			t.Errorf("%+v: got %v, want %q", tc.msg, err, tc.reason)
+		}
+	}
+}
+
+// Every field a shape names must be one checkShape knows how to test.
+func TestMessageShapesNameKnownFields(t *testing.T) {
+	for msgType, shape := range messageShapes {
+		for _, f := range append(shape.required, shape.optional...) {
+			if _, ok := messageFields[f]; !ok {
+				t.Errorf("%s names unknown field %s", msgType, f)
+			}
+		}
+	}
+}
+
+func TestStrictRoomRepliesWithTheShapeError(t *testing.T) {
+	h := newHub("test")
+	h.strict = true
+	c, fc := testClient(h)
+	send(t, h, c, Message{Type: "move", From: &Point{X: 1}, RequestID: "r1"})
+	errs := fc.ofType(t, "error")
+	if len(errs) != 1 || errs[0].Code != errInvalidMessage || errs[0].RequestID != "r1" || !strings.Contains(errs[0].Reason, "requires to") {
+		t.Errorf("got %+v", errs)
+	}
+}
@@ -0,0 +1,75 @@
+package hub
+
+// selectPoints adds the listed points that exist to the shared selection and
+// returns the ones that were newly selected.
+func (h *Hub) selectPoints(ps []Point) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []Point
+	for _, p := range ps {
+		key := h.key(p)
+		stored, exists := h.points[key]
+		if !exists {
+			continue
+		}
+		if _, selected := h.selection[key]; selected {
+			continue
+		}
+		h.selection[key] = struct{}{}
+		out = append(out, stored)
+	}
+	return out
+}
+
+// deselectPoints removes the listed points from the selection and returns
+// the ones that were selected. It also works for points that no longer
+// exist, which is how removals drop their points from the selection.
+func (h *Hub) deselectPoints(ps []Point) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []Point
+	for _, p := range ps {
+		key := h.key(p)
+		if _, selected := h.selection[key]; !selected {
+			continue
+		}
+		delete(h.selection, key)
+		out = append(out, p)
+	}
+	return out
+}
+
+func (h *Hub) clearSelection() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.selection) == 0 {
+		return false
+	}
+	h.selection = make(map[string]struct{})
+	return true
+}
+
+func (h *Hub) selectedPoints() []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]Point, 0, len(h.selection))
+	for key := range h.selection {
+		if p, ok := h.points[key]; ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// afterRemove runs the bookkeeping every removal needs before its broadcast:
+// pending coalesced moves of the removed points go out first, and points
+// that were selected are deselected for everyone.
+func (h *Hub) afterRemove(removed []Point) {
+	for _, p := range removed {
+		h.moves.flush(h.key(p))
+	}
+	h.dropLocksOf(removed)
+	if deselected := h.deselectPoints(removed); len(deselected) > 0 {
+		h.broadcast(Message{Type: "deselect", Points: deselected})
+	}
+}
@@ -0,0 +1,44 @@
+package hub
+
+import "testing"
+
+func TestSelectionIsSharedAndDroppedOnRemove(t *testing.T) {
+	h := newHub("test")
+	alice, _ := testClient(h)
+	_, bobConn := testClient(h)
+	a, b := Point{X: 1}, Point{X: 2}
+	send(t, h, alice, Message{Type: "addBatch", Points: []Point{a, b}})
+	bobConn.messages(t)
+
+	// Only stored, not yet selected points are broadcast.
+	send(t, h, alice, Message{Type: "select", Points: []Point{a, b, {X: 9}}})
+	send(t, h, alice, Message{Type: "select", Point: &a})
+	sel := bobConn.ofType(t, "select")
+	if len(sel) != 1 || len(sel[0].Points) != 2 {
+		t.Fatalf("got selects %+v, want one of the two stored points", sel)
+	}
+
+	// A late joiner sees the selection in its init.
+	late, lateConn := testClient(h)
+	if err := h.sendInit(late); err != nil {
+		t.Fatal(err)
+	}
+	if inits := lateConn.ofType(t, "init"); len(inits) != 1 || len(inits[0].Selection) != 2 {
+		t.Fatalf("late joiner got %+v", inits)
+	}
+
+	send(t, h, alice, Message{Type: "remove", Point: &a})
+	desel := bobConn.ofType(t, "deselect")
+	if len(desel) != 1 || len(desel[0].Points) != 1 || desel[0].Points[0].X != 1 {
+		t.Errorf("removing a selected point deselected %+v", desel)
+	}
+
+	send(t, h, alice, Message{Type: "clearSelection"})
+	send(t, h, alice, Message{Type: "clearSelection"})
+	if got := bobConn.ofType(t, "clearSelection"); len(got) != 1 {
+		t.Errorf("got %d clearSelection broadcasts, want 1", len(got))
+	}
+	if got := h.selectedPoints(); len(got) != 0 {
+		t.Errorf("selection after clear = %+v", got)
+	}
+}
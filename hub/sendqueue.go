@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// frame is an encoded message waiting to be written. Broadcast frames share
+// a prepared message between recipients, so each is framed and deflated
+// once rather than per connection.
+type frame struct {
+	data     []byte
+	prepared *websocket.PreparedMessage
+}
+
+// prepareFrame encodes a JSON payload for format as a shared frame.
+func prepareFrame(format wireFormat, payload []byte) (frame, error) {
+	data, err := format.encode(payload)
+	if err != nil {
+		return frame{}, err
+	}
+	pm, err := websocket.NewPreparedMessage(format.frameType(), data)
+	if err != nil {
+		return frame{}, err
+	}
+	return frame{data: data, prepared: pm}, nil
+}
+
+// sendQueue buffers the frames waiting to be written to one client. High
+// priority frames are written before low priority ones, and when the queue is
+// full low priority frames are dropped, newest first, to make room. A full
+// queue of high priority frames means the client cannot keep up with the
+// state of the room, and push reports false.
+type sendQueue struct {
+	mu     sync.Mutex
+	high   []frame
+	low    []frame
+	limit  int
+	closed bool
+	wake   chan struct{}
+
+	dropped atomic.Uint64
+}
+
+func newSendQueue(limit int) *sendQueue {
+	return &sendQueue{limit: limit, wake: make(chan struct{}, 1)}
+}
+
+func (q *sendQueue) push(f frame, priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	if len(q.high)+len(q.low) >= q.limit {
+		switch {
+		case priority < priorityHigh:
+			q.dropped.Add(1)
+			return true
+		case len(q.low) > 0:
+			q.low = q.low[:len(q.low)-1]
+			q.dropped.Add(1)
+		default:
+			return false
+		}
+	}
+	if priority < priorityHigh {
+		q.low = append(q.low, f)
+	} else {
+		q.high = append(q.high, f)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop waits for the next frame, reporting false once the queue is closed.
+func (q *sendQueue) pop() (frame, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return frame{}, false
+		}
+		var f frame
+		ok := true
+		switch {
+		case len(q.high) > 0:
+			f, q.high = q.high[0], q.high[1:]
+		case len(q.low) > 0:
+			f, q.low = q.low[0], q.low[1:]
+		default:
+			ok = false
+		}
+		q.mu.Unlock()
+		if ok {
+			return f, true
+		}
+		<-q.wake
+	}
+}
+
+// replace drops every queued frame in favour of frames, which are written
+// next. A frame already taken by the writer is still written first.
+func (q *sendQueue) replace(frames []frame) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.high, q.low = frames, nil
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.high, q.low = nil, nil
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop drains c's send queue until the connection is removed.
+func (h *Hub) writeLoop(c *client) {
+	for {
+		f, ok := c.queue.pop()
+		if !ok {
+			return
+		}
+		start := time.Now()
+		if err := c.write(f); err != nil {
+			h.hotLog.Warn(c.log, "write failed", "err", err)
+			h.removeConn(c)
+			return
+		}
+		h.noteWrite(c, time.Since(start))
+	}
+}
+
+var errSendQueueFull = errors.New("send queue full")
+
+func (c *client) droppedCount() uint64 {
+	if c.queue == nil {
+		return 0
+	}
+	return c.queue.dropped.Load()
+}
@@ -0,0 +1,45 @@
+package hub
+
+import "testing"
+
+func TestSendQueuePrefersHighPriority(t *testing.T) {
+	q := newSendQueue(3)
+	push := func(data string, priority int) bool { return q.push(frame{data: []byte(data)}, priority) }
+	push("cursor1", priorityLow)
+	push("add1", priorityHigh)
+	push("cursor2", priorityLow)
+
+	// Full: a low frame is dropped, a high one evicts the newest low frame.
+	if !push("cursor3", priorityLow) || !push("add2", priorityHigh) {
+		t.Fatal("push into a full queue with low frames failed")
+	}
+	if n := q.dropped.Load(); n != 2 {
+		t.Errorf("dropped %d frames, want 2", n)
+	}
+	var order []string
+	for i := 0; i < 3; i++ {
+		f, ok := q.pop()
+		if !ok {
+			t.Fatal("queue closed")
+		}
+		order = append(order, string(f.data))
+	}
+	want := []string{"add1", "add2", "cursor1"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("popped %v, want %v", order, want)
+		}
+	}
+
+	// A queue full of high frames cannot keep up.
+	for i := 0; i < 3; i++ {
+		push("add", priorityHigh)
+	}
+	if push("add", priorityHigh) {
+		t.Error("push into a queue full of high frames succeeded")
+	}
+	q.close()
+	if _, ok := q.pop(); ok {
+		t.Error("pop after close returned a frame")
+	}
+}
@@ -0,0 +1,445 @@
+// Package hub implements the universe server: rooms of points shared with
+// WebSocket clients, plus the REST and administrative endpoints around them.
+// The universe command in ./server is a thin wrapper that maps flags to
+// Options.
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Server serves every room over WebSocket and HTTP. It is an http.Handler
+// for the routes the universe command exposes.
+type Server struct {
+	rooms    *roomManager
+	mux      *http.ServeMux
+	origins  originPolicy
+	audit    *auditLog
+	webhooks []*webhook
+	recorder *recorder
+	settings settings
+}
+
+// NewServer checks the options, loads the files they name and starts the
+// background connection checks and compaction.
+func NewServer(opts ...Option) (*Server, error) {
+	cfg := defaultSettings()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.jsonCase != "camel" && cfg.jsonCase != "snake" {
+		return nil, fmt.Errorf("invalid JSON case %q", cfg.jsonCase)
+	}
+	if cfg.reconnect.max < cfg.reconnect.min {
+		return nil, errors.New("longest shutdown reconnect delay must not be below the shortest")
+	}
+	authEnabled := cfg.jwtSecret != "" || cfg.jwtKeys != ""
+	if cfg.aclPath != "" && !authEnabled {
+		return nil, errors.New("an ACL requires a JWT secret or key file")
+	}
+	anonymousRoles := map[string]role{"full": roleAdmin, "read-only": roleViewer, "reject": roleNone}
+	if _, ok := anonymousRoles[cfg.anonymous]; !ok {
+		return nil, fmt.Errorf("invalid anonymous mode %q", cfg.anonymous)
+	}
+	if cfg.preloadRooms && cfg.roomDir == "" {
+		return nil, errors.New("preloading rooms requires a room dir")
+	}
+	if cfg.checkInterval > 0 && cfg.readTimeout > 0 && cfg.readTimeout <= cfg.checkInterval {
+		return nil, errors.New("read timeout must exceed the check interval")
+	}
+	if cfg.broadcastTick > 0 && cfg.broadcastRate > 0 {
+		return nil, errors.New("broadcast tick and max broadcast rate are exclusive")
+	}
+	var webhookURLs []string
+	for _, u := range strings.Split(cfg.webhookURLs, ",") {
+		if u = strings.TrimSpace(u); u == "" {
+			continue
+		}
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", u)
+		}
+		webhookURLs = append(webhookURLs, u)
+	}
+	if len(webhookURLs) > 0 && (cfg.webhookBatch < 1 || cfg.webhookInterval <= 0) {
+		return nil, errors.New("webhooks need a batch size of at least 1 and a positive interval")
+	}
+	origins, err := newOriginPolicy(cfg.allowedOrigins)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed origins: %v", err)
+	}
+	if cfg.keyDecimals < 0 || cfg.keyDecimals > 15 {
+		return nil, errors.New("key decimals must be between 0 and 15")
+	}
+	if cfg.flood.rate > 0 && cfg.flood.burst < 1 {
+		return nil, errors.New("client burst must be at least 1")
+	}
+	if cfg.chatRate.rate > 0 && cfg.chatRate.burst < 1 {
+		return nil, errors.New("chat burst must be at least 1")
+	}
+	if cfg.debug && cfg.adminToken == "" {
+		return nil, errors.New("debug requires an admin token")
+	}
+	if cfg.simStep > 0 && !cfg.idMode {
+		// Moving a point would change its key every step.
+		return nil, errors.New("simulation requires id mode")
+	}
+	if cfg.coordDecimals >= 0 && !cfg.idMode {
+		// Clients address points by the coordinates they were sent, which
+		// would no longer match the stored keys.
+		return nil, errors.New("coordinate decimals require id mode")
+	}
+	for name := range cfg.roomMinDistance {
+		if !roomNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid room name %q", name)
+		}
+	}
+
+	var globalTransform *transform
+	if cfg.transform != "" {
+		t, err := parseTransform(cfg.transform)
+		if err != nil {
+			return nil, err
+		}
+		globalTransform = t
+	}
+	roomTransforms := map[string]*transform{}
+	for name, spec := range cfg.roomTransforms {
+		if !roomNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid room name %q", name)
+		}
+		t, err := parseTransform(spec)
+		if err != nil {
+			return nil, fmt.Errorf("room %s transform: %v", name, err)
+		}
+		roomTransforms[name] = t
+	}
+
+	var configs *roomConfigs
+	if cfg.roomConfigPath != "" {
+		rc, err := loadRoomConfigs(cfg.roomConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		configs = rc
+	}
+	var jwt *jwtVerifier
+	if authEnabled {
+		v, err := newJWTVerifier(cfg.jwtSecret, cfg.jwtKeys)
+		if err != nil {
+			return nil, err
+		}
+		jwt = v
+	}
+	var acl *accessList
+	if cfg.aclPath != "" {
+		a, err := loadAccessList(cfg.aclPath)
+		if err != nil {
+			return nil, err
+		}
+		acl = a
+	}
+	var bp backplane
+	if cfg.backplaneURL != "" {
+		b, err := newRedisBackplane(cfg.backplaneURL, cfg.backplaneTopic)
+		if err != nil {
+			return nil, err
+		}
+		bp = b
+	}
+	for _, dir := range [...]string{cfg.dataDir, cfg.roomDir, cfg.recordDir} {
+		if dir == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create %s: %v", dir, err)
+		}
+	}
+
+	s := &Server{settings: cfg, origins: origins}
+	if cfg.auditPath != "" {
+		a, err := openAuditLog(cfg.auditPath, cfg.auditBuffer)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %v", err)
+		}
+		s.audit = a
+	}
+
+	wire := newWireEncoding(cfg.jsonCase == "snake", cfg.coordDecimals)
+	upgrader := newUpgrader(cfg.compress, origins.allows)
+	hotLog := newLogSampler(cfg.logSample)
+	metrics := new(serverMetrics)
+	var total atomic.Int64
+	var accept *acceptQueue
+	if cfg.acceptDepth > 0 {
+		accept = newAcceptQueue(cfg.acceptDepth)
+	}
+	s.rooms = newRoomManager(func(name string) *Hub {
+		h := newHub(name)
+		h.transform = globalTransform
+		if t, ok := roomTransforms[name]; ok {
+			h.transform = t
+		}
+		h.maxBatch = cfg.maxBatch
+		h.maxMessageBytes = cfg.maxMessageBytes
+		h.idMode = cfg.idMode
+		h.keyDecimals = cfg.keyDecimals
+		h.maxLifetime = cfg.maxLifetime
+		h.lifetimeJitter = cfg.lifetimeJitter
+		h.moves.window = cfg.moveWindow
+		h.initChunkSize = cfg.initChunkSize
+		h.moveQuantum = cfg.moveQuantum
+		h.maxInitBytes = cfg.maxInitBytes
+		h.idem = newIdempotencyCache(cfg.idemTTL, cfg.idemSize)
+		if cfg.broadcastTick > 0 {
+			h.limiter = newBroadcastTicker(cfg.broadcastTick, h.deliver)
+		} else if cfg.broadcastRate > 0 {
+			h.limiter = newBroadcastLimiter(cfg.broadcastRate, h.deliver)
+		}
+		if cfg.boundsInterval > 0 {
+			h.bounds = newBoundsTracker(cfg.boundsInterval, cfg.boundsThreshold)
+		}
+		h.lockTimeout = cfg.lockTimeout
+		h.pointTTL = cfg.pointTTL
+		h.maxGenerate = cfg.maxGenerate
+		if cfg.simStep > 0 {
+			h.sim = newSimulation(cfg.simStep, cfg.simPublish)
+		}
+		h.limits = pointLimits{total: &total, maxTotal: cfg.maxTotalPoints, perOwner: cfg.maxOwnerPoints}
+		if cfg.maxOwnerPoints > 0 {
+			h.owned = make(map[string]int)
+		}
+		h.staleAfter = cfg.staleAfter
+		h.sendQueue = cfg.sendQueue
+		h.slowWrites = cfg.slowWrites
+		h.accept = accept
+		h.changes = newChangeLog(cfg.tombstoneTTL, cfg.tombstoneLimit)
+		// Every room's config was validated when the file was loaded.
+		h.config, _ = configs.resolve(name)
+		h.minDistance = cfg.minDistance
+		if d, ok := cfg.roomMinDistance[name]; ok {
+			h.minDistance = d
+		}
+		if h.minDistance > 0 {
+			h.grid = newSpatialGrid(h.minDistance)
+		}
+		if store := cfg.roomStorage(name); store != nil {
+			h.storage = newPersister(store, cfg.persistInterval)
+			if err := h.storage.load(h); err != nil {
+				slog.Error("load room failed", "room", name, "err", err)
+			}
+		}
+		h.writeTimeout = cfg.writeTimeout
+		h.ownerRemoves, h.aclAdmins = cfg.ownerRemoves, cfg.aclPath != ""
+		h.undoDepth = cfg.undoDepth
+		h.presenceInterval = cfg.presenceEvery
+		h.flood = cfg.flood
+		if cfg.checkInterval > 0 {
+			// Without pings an idle but healthy client would time out.
+			h.readTimeout = cfg.readTimeout
+		}
+		h.compressMin = cfg.compressMin
+		if cfg.syncHistory > 0 {
+			h.history = newOpHistory(cfg.syncHistory)
+		}
+		if cfg.signalRetention > 0 {
+			h.signals = newSignalBuffer(cfg.signalRetention, cfg.signalLimit)
+		}
+		if cfg.chatLength > 0 {
+			h.chats = newChatLog(cfg.chatHistory, cfg.chatLength, cfg.chatRate)
+		}
+		h.audit = s.audit
+		h.wire = wire
+		h.meta = metaLimits{keys: cfg.metaKeys, bytes: cfg.metaBytes}
+		h.strict = cfg.strict
+		h.upgrader, h.hotLog, h.metrics = upgrader, hotLog, metrics
+		return h
+	})
+	rooms := s.rooms
+	rooms.wire, rooms.upgrader, rooms.hotLog, rooms.metrics = wire, upgrader, hotLog, metrics
+	rooms.dir = cfg.roomDir
+	rooms.ready = newReadiness(cfg.queueUntilReady)
+	rooms.jwt, rooms.acl = jwt, acl
+	if jwt != nil {
+		rooms.anonymous = anonymousRoles[cfg.anonymous]
+	}
+	if bp != nil {
+		rooms.joinBackplane(bp)
+	}
+	for _, u := range webhookURLs {
+		w := newWebhook(u, cfg.webhookSecret, cfg.webhookBatch, cfg.webhookInterval)
+		s.webhooks = append(s.webhooks, w)
+		rooms.OnMutation(w.record)
+	}
+	if cfg.recordDir != "" {
+		s.recorder = &recorder{dir: cfg.recordDir, keep: cfg.recordKeep, maxAge: cfg.recordMaxAge, rooms: rooms}
+	}
+	s.mux = s.routes()
+
+	go rooms.warmUp(cfg.preloadRooms, cfg.startupDelay)
+	if cfg.checkInterval > 0 {
+		go rooms.runChecks(cfg.checkInterval)
+	}
+	if cfg.expiryInterval > 0 {
+		go rooms.runExpiry(cfg.expiryInterval)
+	}
+	if cfg.simStep > 0 {
+		go rooms.runSimulation(cfg.simStep)
+	}
+	if s.recorder != nil && cfg.recordInterval > 0 {
+		go s.recorder.run(cfg.recordInterval)
+	}
+	if cfg.compactInterval > 0 {
+		go runCompaction(cfg.compactInterval, []compactionPass{
+			{name: "rooms", run: func(now time.Time) int { return rooms.compactRooms(now, cfg.roomTTL) }},
+			{name: "unloaded", run: func(now time.Time) int { return rooms.unloadIdle(now, cfg.unloadAfter) }},
+		})
+	}
+	return s, nil
+}
+
+func (s *Server) routes() *http.ServeMux {
+	cfg, rooms := s.settings, s.rooms
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", rooms.wsHandler)
+	mux.HandleFunc("/healthz", rooms.healthHandler)
+	mux.HandleFunc("/points", rooms.pointsHandler)
+	mux.HandleFunc("/api/points", rooms.pointsHandler)
+	mux.HandleFunc("/stats", rooms.statsHandler)
+	mux.HandleFunc("/metrics", rooms.metricsHandler)
+	mux.HandleFunc("/rooms", rooms.roomsHandler)
+	mux.HandleFunc("/capabilities", rooms.capabilitiesHandler)
+	mux.HandleFunc("/points/changed", rooms.changesHandler)
+	if cfg.aclPath != "" {
+		mux.HandleFunc("/rooms/acl", rooms.aclHandler)
+	}
+	if cfg.jwtSecret != "" {
+		mux.HandleFunc("/rooms/view-link", rooms.viewLinkHandler)
+	}
+	mux.HandleFunc("/points.ply", rooms.plyHandler)
+	mux.HandleFunc("/snapshot.json", rooms.snapshotHandler)
+	mux.HandleFunc("/export", rooms.exportHandler)
+	mux.HandleFunc("/import", rooms.uploadHandler(cfg.maxImportBytes))
+	mux.HandleFunc("/generate", rooms.generateHandler)
+	if cfg.auditPath != "" {
+		mux.HandleFunc("/replay", rooms.replayHandler(cfg.auditPath))
+	}
+	if cfg.debug {
+		mux.HandleFunc("/debug/state", requireToken(cfg.adminToken, rooms.debugHandler))
+	}
+	if cfg.adminToken != "" {
+		mux.HandleFunc("/admin/import", requireToken(cfg.adminToken, rooms.importHandler(newImporter(cfg.importTimeout, cfg.maxImportBytes, rooms.wire))))
+		mux.HandleFunc("/admin/stats", requireToken(cfg.adminToken, rooms.adminStatsHandler))
+		mux.HandleFunc("/admin/clear", requireToken(cfg.adminToken, rooms.adminClearHandler))
+		mux.HandleFunc("/admin/kick", requireToken(cfg.adminToken, rooms.adminKickHandler))
+		if s.recorder != nil {
+			mux.HandleFunc("/admin/recordings", requireToken(cfg.adminToken, s.recorder.recordingsHandler))
+			mux.HandleFunc("/admin/recordings/restore", requireToken(cfg.adminToken, s.recorder.restoreHandler))
+		}
+	}
+	if cfg.staticDir != "" {
+		mux.Handle("/", http.FileServer(http.Dir(cfg.staticDir)))
+	}
+	return mux
+}
+
+// ServeHTTP routes r to the WebSocket, REST and administrative endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.origins.cors(w, r) {
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Room calls fn with the named room, keeping it loaded until fn returns.
+func (s *Server) Room(name string, fn func(h *Hub)) {
+	h := s.rooms.acquire(name)
+	defer s.rooms.release(h)
+	fn(h)
+}
+
+// OnMutation registers fn with every current and future room.
+func (s *Server) OnMutation(fn func(Mutation)) {
+	s.rooms.OnMutation(fn)
+}
+
+// Ingest adds the newline-delimited JSON points read from r to the default
+// room until r is exhausted, attributing them to actor.
+func (s *Server) Ingest(r io.Reader, actor string) {
+	s.Room(defaultRoom, func(h *Hub) { h.ingest(r, actor) })
+}
+
+// Shutdown tells every client to reconnect after a random delay, closes the
+// connections, saves rooms with pending changes and writes a final
+// recording.
+func (s *Server) Shutdown() {
+	s.rooms.shutdown(s.settings.reconnect)
+	s.rooms.flushStorage()
+	if s.recorder != nil {
+		if _, err := s.recorder.record(); err != nil {
+			slog.Error("record failed", "err", err)
+		}
+	}
+}
+
+// Close sends what the webhooks have queued, then flushes and closes the
+// audit log. Mutations after Close are neither sent nor audited.
+func (s *Server) Close() error {
+	for _, w := range s.webhooks {
+		w.Close()
+	}
+	if s.audit == nil {
+		return nil
+	}
+	return s.audit.Close()
+}
+
+// Name returns the room h serves.
+func (h *Hub) Name() string { return h.room }
+
+// Points returns every point in the room, in no particular order.
+func (h *Hub) Points() []Point { return h.snapshotPoints() }
+
+// Add stores p as added by actor and broadcasts it to the room's clients.
+func (h *Hub) Add(p Point, actor string) (Point, error) {
+	if err := validatePoint(p, h.meta); err != nil {
+		return Point{}, err
+	}
+	var stored Point
+	var err *validationError
+	h.sequenced(func() {
+		if stored, err = h.addPoint(p, actor); err == nil {
+			h.broadcast(Message{Type: "add", Point: &stored})
+		}
+	})
+	if err != nil {
+		return Point{}, err
+	}
+	return stored, nil
+}
+
+// Remove deletes the point with p's key as removed by actor and broadcasts
+// the removal.
+func (h *Hub) Remove(p Point, actor string) (Point, error) {
+	var stored Point
+	var err *validationError
+	h.sequenced(func() {
+		if stored, err = h.removePoint(p, actor); err == nil {
+			h.afterRemove([]Point{stored})
+			h.broadcast(Message{Type: "remove", Point: &stored})
+		}
+	})
+	if err != nil {
+		return Point{}, err
+	}
+	return stored, nil
+}
@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+func newTestServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	s, err := NewServer(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func get(s *Server, target string) *httptest.ResponseRecorder {
+	return do(s, "GET", target, "")
+}
+
+func do(s *Server, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+// Settings that used to be package variables must not leak from one server
+// to another created later in the same process.
+func TestServersKeepTheirOwnSettings(t *testing.T) {
+	snake := newTestServer(t, WithJSONCase("snake"), WithMetaLimits(1, 64))
+	camel := newTestServer(t, WithCompression(false, 0))
+
+	if body := get(snake, "/capabilities?room=r").Body.String(); !strings.Contains(body, `"max_batch"`) {
+		t.Errorf("snake-case server sent %s", body)
+	}
+	if body := get(camel, "/capabilities?room=r").Body.String(); !strings.Contains(body, `"maxBatch"`) {
+		t.Errorf("camel-case server sent %s", body)
+	}
+	if body := get(snake, "/stats").Body.String(); !strings.Contains(body, `"compression_enabled":true`) {
+		t.Errorf("compressing server reported %s", body)
+	}
+	if body := get(camel, "/stats").Body.String(); !strings.Contains(body, `"compressionEnabled":false`) {
+		t.Errorf("plain server reported %s", body)
+	}
+
+	meta := map[string]string{"a": "1", "b": "2"}
+	snake.Room("r", func(h *Hub) {
+		if _, err := h.Add(Point{X: 1, Meta: meta}, "test"); err == nil {
+			t.Error("two meta keys accepted with a limit of one")
+		}
+	})
+	camel.Room("r", func(h *Hub) {
+		if _, err := h.Add(Point{X: 1, Meta: meta}, "test"); err != nil {
+			t.Errorf("two meta keys rejected with the default limit: %v", err)
+		}
+	})
+}
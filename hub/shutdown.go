@@ -0,0 +1,60 @@
+package hub
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// reconnectWindow is the range a client is told to wait before reconnecting
+// after a shutdown, so a fleet of clients does not land on the new instances
+// at once.
+type reconnectWindow struct {
+	min, max time.Duration
+}
+
+func (rw reconnectWindow) pick() time.Duration {
+	if rw.max <= rw.min {
+		return rw.min
+	}
+	return rw.min + time.Duration(rand.Int63n(int64(rw.max-rw.min)))
+}
+
+// shutdown tells every client of every room to reconnect after its own
+// share of rw, then closes the connections with a going-away frame.
+func (m *roomManager) shutdown(rw reconnectWindow) {
+	var wg sync.WaitGroup
+	for _, h := range m.hubs() {
+		h.mu.Lock()
+		for c := range h.conns {
+			wg.Add(1)
+			go func(h *Hub, c *client) {
+				defer wg.Done()
+				h.shutdownConn(c, rw.pick())
+			}(h, c)
+		}
+		h.mu.Unlock()
+	}
+	wg.Wait()
+}
+
+// shutdownConn writes the shutdown message directly rather than through the
+// send queue so that it is guaranteed to precede the close frame.
+func (h *Hub) shutdownConn(c *client, after time.Duration) {
+	c.writeMu.Lock()
+	data, err := c.encode(Message{Type: "shutdown", ReconnectAfter: after.Milliseconds()})
+	if err == nil {
+		err = c.writeFrame(data)
+	}
+	if err == nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		err = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+	c.writeMu.Unlock()
+	if err != nil {
+		c.log.Warn("shutdown write failed", "err", err)
+	}
+	h.removeConn(c)
+}
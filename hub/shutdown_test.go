@@ -0,0 +1,40 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdownSpreadsReconnects(t *testing.T) {
+	rw := reconnectWindow{min: time.Second, max: 3 * time.Second}
+	for i := 0; i < 50; i++ {
+		if d := rw.pick(); d < rw.min || d >= rw.max {
+			t.Fatalf("picked %v outside [%v, %v)", d, rw.min, rw.max)
+		}
+	}
+	if d := (reconnectWindow{min: time.Second}).pick(); d != time.Second {
+		t.Errorf("empty window picked %v", d)
+	}
+
+	m := newRoomManager(newHub)
+	var fakes []*fakeConn
+	for _, name := range []string{"a", "b"} {
+		h := m.acquire(name)
+		for i := 0; i < 2; i++ {
+			_, fc := testClient(h)
+			fakes = append(fakes, fc)
+		}
+	}
+	m.shutdown(rw)
+	for i, fc := range fakes {
+		msgs := fc.messages(t)
+		if len(msgs) != 1 || msgs[0].Type != "shutdown" || msgs[0].ReconnectAfter < 1000 || msgs[0].ReconnectAfter >= 3000 {
+			t.Errorf("connection %d got %+v, want one shutdown with a hint in the window", i, msgs)
+		}
+		if len(fc.closeFrame) < 2 || int(fc.closeFrame[0])<<8|int(fc.closeFrame[1]) != websocket.CloseGoingAway || !fc.closed {
+			t.Errorf("connection %d closed with %q, want going away", i, fc.closeFrame)
+		}
+	}
+}
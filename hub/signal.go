@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"sync"
+	"time"
+)
+
+// signalEvent is a fire-and-forget event at a position, such as a meteor
+// landing. Signals are never stored as points.
+type signalEvent struct {
+	Point Point `json:"point"`
+	At    int64 `json:"at"`
+}
+
+// signalBuffer retains the signals of the last retention, at most limit of
+// them, so clients that join a moment after one still catch its tail end.
+type signalBuffer struct {
+	retention time.Duration
+	limit     int
+
+	mu     sync.Mutex
+	events []signalEvent
+}
+
+func newSignalBuffer(retention time.Duration, limit int) *signalBuffer {
+	return &signalBuffer{retention: retention, limit: limit}
+}
+
+func (b *signalBuffer) add(e signalEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, e)
+	if b.limit > 0 && len(b.events) > b.limit {
+		b.events = b.events[len(b.events)-b.limit:]
+	}
+	b.prune(time.UnixMilli(e.At))
+}
+
+// prune drops events older than the retention. The caller must hold mu.
+func (b *signalBuffer) prune(now time.Time) {
+	cutoff := now.Add(-b.retention).UnixMilli()
+	i := 0
+	for i < len(b.events) && b.events[i].At < cutoff {
+		i++
+	}
+	if i > 0 {
+		b.events = append([]signalEvent(nil), b.events[i:]...)
+	}
+}
+
+// recent returns the signals still within the retention at now.
+func (b *signalBuffer) recent(now time.Time) []signalEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(now)
+	if len(b.events) == 0 {
+		return nil
+	}
+	return append([]signalEvent(nil), b.events...)
+}
+
+// signal broadcasts a signal at p and retains it when the room keeps
+// signals for late joiners.
+func (h *Hub) signal(p Point) {
+	e := signalEvent{Point: p, At: time.Now().UnixMilli()}
+	if h.signals != nil {
+		h.signals.add(e)
+	}
+	h.broadcast(Message{Type: "signal", Point: &e.Point, At: e.At})
+}
+
+func (h *Hub) recentSignals() []signalEvent {
+	if h.signals == nil {
+		return nil
+	}
+	return h.signals.recent(time.Now())
+}
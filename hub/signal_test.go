@@ -0,0 +1,37 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLateJoinerSeesRecentSignals(t *testing.T) {
+	h := newHub("test")
+	h.signals = newSignalBuffer(50*time.Millisecond, 2)
+	c, _ := testClient(h)
+	_, watcher := testClient(h)
+
+	for _, x := range []float64{1, 2, 3} {
+		send(t, h, c, Message{Type: "signal", Point: &Point{X: x}})
+	}
+	if got := watcher.ofType(t, "signal"); len(got) != 3 {
+		t.Errorf("watcher got %d signals, want 3", len(got))
+	}
+	if len(h.points) != 0 {
+		t.Errorf("signals stored as points: %+v", h.points)
+	}
+
+	late, lateConn := testClient(h)
+	if err := h.sendInit(late); err != nil {
+		t.Fatal(err)
+	}
+	inits := lateConn.ofType(t, "init")
+	if len(inits) != 1 || len(inits[0].Signals) != 2 || inits[0].Signals[0].Point.X != 2 || inits[0].Signals[1].Point.X != 3 {
+		t.Fatalf("late joiner got %+v, want the last two signals", inits)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	if got := h.recentSignals(); len(got) != 0 {
+		t.Errorf("signals past the retention still retained: %+v", got)
+	}
+}
@@ -0,0 +1,126 @@
+package hub
+
+import (
+	"math"
+	"time"
+)
+
+// simulation advances the points of a room that carry a velocity, on a fixed
+// step. Steps change points in place without emitting mutations: they take
+// no sequence number and are not audited, persisted to the history or sent
+// to observers. Instead the room publishes the full state of every point
+// that moved in a simTick frame, stamped with the server time so clients can
+// extrapolate positions from velocities until the next one. Guarded by the
+// hub's mu.
+type simulation struct {
+	step, publish time.Duration
+	paused        bool
+	moved         map[string]struct{}
+	lastPublish   time.Time
+}
+
+// simState describes a room's simulation to clients.
+type simState struct {
+	StepMs    int64 `json:"stepMs"`
+	PublishMs int64 `json:"publishMs"`
+	Paused    bool  `json:"paused"`
+}
+
+func newSimulation(step, publish time.Duration) *simulation {
+	if publish < step {
+		publish = step
+	}
+	return &simulation{step: step, publish: publish, moved: make(map[string]struct{})}
+}
+
+func (s *simulation) state() *simState {
+	return &simState{StepMs: s.step.Milliseconds(), PublishMs: s.publish.Milliseconds(), Paused: s.paused}
+}
+
+// simulationState returns the room's simulation state, or nil without one.
+func (h *Hub) simulationState() *simState {
+	if h.sim == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sim.state()
+}
+
+// stepSimulation moves every point with a velocity by one step and, once
+// the publish interval has passed, broadcasts the points that moved.
+func (h *Hub) stepSimulation(now time.Time) {
+	h.sequenced(func() {
+		h.mu.Lock()
+		s := h.sim
+		if s.paused {
+			h.mu.Unlock()
+			return
+		}
+		dt := s.step.Seconds()
+		moved := false
+		for key, p := range h.points {
+			v := p.Velocity
+			if v == nil {
+				continue
+			}
+			old := p
+			p.X, p.Y, p.Z = p.X+v[0]*dt, p.Y+v[1]*dt, p.Z+v[2]*dt
+			if math.IsInf(p.X, 0) || math.IsInf(p.Y, 0) || math.IsInf(p.Z, 0) {
+				// Stop at the edge of the representable universe.
+				p = old
+				p.Velocity = nil
+			}
+			h.points[key] = p
+			if h.grid != nil {
+				h.trackGrid(Mutation{Type: "move", Point: p, From: &old})
+			}
+			if h.bounds != nil {
+				h.trackBounds(Mutation{Type: "move", Point: p, From: &old})
+			}
+			s.moved[key] = struct{}{}
+			moved = true
+		}
+		if moved && h.storage != nil {
+			h.storage.markDirty(h)
+		}
+		if len(s.moved) == 0 || now.Sub(s.lastPublish) < s.publish {
+			h.mu.Unlock()
+			return
+		}
+		s.lastPublish = now
+		ps := make([]Point, 0, len(s.moved))
+		for key := range s.moved {
+			if p, ok := h.points[key]; ok {
+				ps = append(ps, p)
+			}
+		}
+		s.moved = make(map[string]struct{})
+		h.mu.Unlock()
+		if len(ps) > 0 {
+			h.broadcast(Message{Type: "simTick", At: now.UnixMilli(), Points: ps})
+		}
+	})
+}
+
+// pauseSimulation stops or restarts the room's simulation and reports the
+// new state.
+func (h *Hub) pauseSimulation(paused bool) (*simState, *validationError) {
+	if h.sim == nil {
+		return nil, invalid(errBadRequest, "room %s does not simulate", h.room)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sim.paused = paused
+	return h.sim.state(), nil
+}
+
+func (m *roomManager) runSimulation(step time.Duration) {
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, h := range m.hubs() {
+			h.stepSimulation(now)
+		}
+	}
+}
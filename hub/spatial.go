@@ -0,0 +1,100 @@
+package hub
+
+import "math"
+
+// spatialGrid buckets point keys into cubic cells so that neighbours within
+// one cell size are found by looking at the 27 cells around a position.
+type spatialGrid struct {
+	cell  float64
+	cells map[[3]int64]map[string]struct{}
+}
+
+func newSpatialGrid(cell float64) *spatialGrid {
+	return &spatialGrid{cell: cell, cells: make(map[[3]int64]map[string]struct{})}
+}
+
+func (g *spatialGrid) cellOf(p Point) [3]int64 {
+	return [3]int64{
+		int64(math.Floor(p.X / g.cell)),
+		int64(math.Floor(p.Y / g.cell)),
+		int64(math.Floor(p.Z / g.cell)),
+	}
+}
+
+func (g *spatialGrid) insert(key string, p Point) {
+	c := g.cellOf(p)
+	keys, ok := g.cells[c]
+	if !ok {
+		keys = make(map[string]struct{})
+		g.cells[c] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (g *spatialGrid) remove(key string, p Point) {
+	c := g.cellOf(p)
+	if keys, ok := g.cells[c]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(g.cells, c)
+		}
+	}
+}
+
+func (g *spatialGrid) rebuild(points map[string]Point) {
+	g.cells = make(map[[3]int64]map[string]struct{})
+	for key, p := range points {
+		g.insert(key, p)
+	}
+}
+
+// nearest returns the stored point closest to p that lies strictly within
+// dist, which must not exceed the cell size, ignoring the point under skip.
+func (g *spatialGrid) nearest(p Point, dist float64, points map[string]Point, skip string) (Point, bool) {
+	c := g.cellOf(p)
+	var best Point
+	bestDist, found := dist, false
+	for dx := int64(-1); dx <= 1; dx++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			for dz := int64(-1); dz <= 1; dz++ {
+				for key := range g.cells[[3]int64{c[0] + dx, c[1] + dy, c[2] + dz}] {
+					q, ok := points[key]
+					if !ok || key == skip {
+						continue
+					}
+					if d := math.Sqrt((q.X-p.X)*(q.X-p.X) + (q.Y-p.Y)*(q.Y-p.Y) + (q.Z-p.Z)*(q.Z-p.Z)); d < bestDist {
+						best, bestDist, found = q, d, true
+					}
+				}
+			}
+		}
+	}
+	return best, found
+}
+
+// trackGrid keeps the spatial grid in step with a mutation. Must be called
+// with h.mu held.
+func (h *Hub) trackGrid(m Mutation) {
+	switch m.Type {
+	case "add":
+		h.grid.insert(h.key(m.Point), m.Point)
+	case "remove":
+		h.grid.remove(h.key(m.Point), m.Point)
+	case "move":
+		h.grid.remove(h.key(*m.From), *m.From)
+		h.grid.insert(h.key(m.Point), m.Point)
+	}
+}
+
+// spacingErr rejects placing p closer than the room's minimum distance to
+// any point other than the one under skip, returning that neighbour. Must be
+// called with h.mu held.
+func (h *Hub) spacingErr(p Point, skip string) (Point, *validationError) {
+	if h.grid == nil {
+		return Point{}, nil
+	}
+	if q, ok := h.grid.nearest(p, h.minDistance, h.points, skip); ok {
+		return q, invalid(errTooClose, "closer than %g to an existing point", h.minDistance)
+	}
+	return Point{}, nil
+}
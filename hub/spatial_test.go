@@ -0,0 +1,34 @@
+package hub
+
+import "testing"
+
+func TestMinimumDistanceIsEnforced(t *testing.T) {
+	h := newHub("test")
+	h.minDistance = 1
+	h.grid = newSpatialGrid(h.minDistance)
+	c, fc := testClient(h)
+
+	send(t, h, c, Message{Type: "add", Point: &Point{}})
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 0.5, Y: 0.5}})
+	errs := fc.ofType(t, "error")
+	if len(errs) != 1 || errs[0].Code != errTooClose || errs[0].Point == nil || errs[0].Point.X != 0 {
+		t.Fatalf("add too close got %+v, want %s naming the neighbour", errs, errTooClose)
+	}
+
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1.5}})
+	send(t, h, c, Message{Type: "move", From: &Point{X: 1.5}, To: &Point{X: 0.2}})
+	if code := errorCode(t, fc); code != errTooClose {
+		t.Errorf("move too close: error %q, want %q", code, errTooClose)
+	}
+	// A point may move within its own radius.
+	send(t, h, c, Message{Type: "move", From: &Point{X: 1.5}, To: &Point{X: 1.2}})
+	if code := errorCode(t, fc); code != "" {
+		t.Errorf("short move: error %q", code)
+	}
+
+	send(t, h, c, Message{Type: "remove", Point: &Point{}})
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 0.1}})
+	if code := errorCode(t, fc); code != "" || len(h.points) != 2 {
+		t.Errorf("add after the neighbour left: error %q, %d points", code, len(h.points))
+	}
+}
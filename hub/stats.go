@@ -0,0 +1,89 @@
+package hub
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// byteTotals accumulates what was written to connections: payload bytes as
+// handed to the WebSocket layer and wire bytes as they left the socket after
+// framing and, where negotiated, permessage-deflate.
+type byteTotals struct {
+	conns   atomic.Int64
+	payload atomic.Uint64
+	wire    atomic.Uint64
+}
+
+// offersCompression mirrors u's negotiation: compression is used when it is
+// enabled and the client offers permessage-deflate.
+func offersCompression(u *websocket.Upgrader, r *http.Request) bool {
+	if !u.EnableCompression {
+		return false
+	}
+	for _, h := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(h, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// countingConn counts bytes written to a hijacked connection.
+type countingConn struct {
+	net.Conn
+	totals *byteTotals
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.totals.wire.Add(uint64(n))
+	return n, err
+}
+
+// countingWriter hands the upgrader a countingConn when it hijacks the
+// response.
+type countingWriter struct {
+	http.ResponseWriter
+	totals *byteTotals
+}
+
+func (w countingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, totals: w.totals}, brw, nil
+}
+
+type byteStats struct {
+	Conns        int64   `json:"conns"`
+	PayloadBytes uint64  `json:"payloadBytes"`
+	WireBytes    uint64  `json:"wireBytes"`
+	Ratio        float64 `json:"ratio,omitempty"`
+}
+
+func (t *byteTotals) stats() byteStats {
+	s := byteStats{Conns: t.conns.Load(), PayloadBytes: t.payload.Load(), WireBytes: t.wire.Load()}
+	if s.PayloadBytes > 0 {
+		s.Ratio = float64(s.WireBytes) / float64(s.PayloadBytes)
+	}
+	return s
+}
+
+// statsHandler reports cumulative bytes written since start. conns counts
+// open connections.
+func (m *roomManager) statsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, m.wire, http.StatusOK, struct {
+		CompressionEnabled bool      `json:"compressionEnabled"`
+		Compressed         byteStats `json:"compressed"`
+		Plain              byteStats `json:"plain"`
+	}{m.upgrader.EnableCompression, m.metrics.compressed.stats(), m.metrics.plain.stats()})
+}
@@ -0,0 +1,184 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCompressionIsRecordedPerConnection(t *testing.T) {
+	s := newTestServer(t)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?room=r"
+
+	for _, compress := range []bool{true, false} {
+		d := websocket.Dialer{EnableCompression: compress}
+		conn, _, err := d.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// The init arrives once the connection is registered.
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flags := map[bool]int{}
+	s.Room("r", func(h *Hub) {
+		h.mu.Lock()
+		for c := range h.conns {
+			flags[c.compressed]++
+		}
+		h.mu.Unlock()
+	})
+	if flags[true] != 1 || flags[false] != 1 {
+		t.Errorf("connections by compression: %v, want one of each", flags)
+	}
+
+	var stats struct {
+		CompressionEnabled bool      `json:"compressionEnabled"`
+		Compressed         byteStats `json:"compressed"`
+		Plain              byteStats `json:"plain"`
+	}
+	if err := json.Unmarshal(get(s, "/stats").Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if !stats.CompressionEnabled || stats.Compressed.Conns != 1 || stats.Plain.Conns != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.Compressed.PayloadBytes == 0 || stats.Plain.WireBytes == 0 {
+		t.Errorf("no bytes counted: %+v", stats)
+	}
+}
+
+func TestOffersCompression(t *testing.T) {
+	on, off := newUpgrader(true, nil), newUpgrader(false, nil)
+	for header, want := range map[string]bool{
+		"": false,
+		"permessage-deflate; client_max_window_bits": true,
+		"x-webkit-deflate-frame, permessage-deflate": true,
+		"x-webkit-deflate-frame":                     false,
+	} {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if header != "" {
+			r.Header.Set("Sec-WebSocket-Extensions", header)
+		}
+		if got := offersCompression(on, r); got != want {
+			t.Errorf("offersCompression(%q) = %v, want %v", header, got, want)
+		}
+		if offersCompression(off, r) {
+			t.Errorf("disabled upgrader offers compression for %q", header)
+		}
+	}
+}
+
+func TestOnlyLargeFramesAreCompressed(t *testing.T) {
+	h := newHub("test")
+	h.compressMin = 100
+	fc := &fakeConn{}
+	c := h.addConn(fc, true, formatJSON)
+	plainConn := &fakeConn{}
+	plain := h.addConn(plainConn, false, formatJSON)
+
+	for _, n := range []int{10, 99, 100, 5000} {
+		for _, c := range []*client{c, plain} {
+			if err := c.write(frame{data: make([]byte, n)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	want := []bool{false, false, true, true}
+	if len(fc.compression) != len(want) {
+		t.Fatalf("compression toggled %v, want %v", fc.compression, want)
+	}
+	for i := range want {
+		if fc.compression[i] != want[i] {
+			t.Fatalf("compression toggled %v, want %v", fc.compression, want)
+		}
+	}
+	if len(plainConn.compression) != 0 {
+		t.Errorf("connection without compression toggled it: %v", plainConn.compression)
+	}
+}
+
+// BenchmarkMixedWorkload writes nine small move messages and one large batch
+// per iteration to a real connection, reporting the bytes that reach the
+// socket. Compare the CPU and wire/op of compressing everything, compressing
+// only large frames, and not compressing.
+func BenchmarkMixedWorkload(b *testing.B) {
+	small, err := json.Marshal(Message{Type: "move", Seq: 12345, From: &Point{X: 1.25, Y: 2.5, Z: 3.75}, To: &Point{X: 1.5, Y: 2.5, Z: 3.75}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	batch := make([]Point, 300)
+	for i := range batch {
+		batch[i] = Point{X: float64(i) * 0.37, Y: float64(i%17) * 1.3, Z: float64(i%5) - 2, Color: "#33aaff"}
+	}
+	large, err := json.Marshal(Message{Type: "addBatch", Seq: 12346, Points: batch})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name        string
+		compressed  bool
+		compressMin int
+	}{
+		{"all", true, 0},
+		{"large", true, 512},
+		{"none", false, 0},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var totals byteTotals
+			conns := make(chan *websocket.Conn, 1)
+			upgrader := newUpgrader(true, nil)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := upgrader.Upgrade(countingWriter{ResponseWriter: w, totals: &totals}, r, nil)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				conns <- conn
+			}))
+			defer srv.Close()
+			d := websocket.Dialer{EnableCompression: bc.compressed}
+			peer, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer peer.Close()
+			go func() {
+				for {
+					if _, _, err := peer.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+			conn := <-conns
+			defer conn.Close()
+			c := &client{conn: conn, compressed: bc.compressed, compressMin: bc.compressMin, format: formatJSON}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := totals.wire.Load()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < 9; j++ {
+					if err := c.write(frame{data: small}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := c.write(frame{data: large}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(totals.wire.Load()-start)/float64(b.N), "wire/op")
+		})
+	}
+}
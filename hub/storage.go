@@ -0,0 +1,115 @@
+package hub
+
+import (
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Storage keeps a room's points across restarts.
+type Storage interface {
+	// Load returns the last saved snapshot, with ok=false when nothing has
+	// been saved yet.
+	Load() (snap RoomSnapshot, ok bool, err error)
+	Save(snap RoomSnapshot) error
+}
+
+// fileStorage stores a room as one JSON snapshot file, replaced atomically
+// on every save.
+type fileStorage struct {
+	path string
+}
+
+func newFileStorage(dir, room string) fileStorage {
+	return fileStorage{path: filepath.Join(dir, room+".json")}
+}
+
+func (s fileStorage) Load() (RoomSnapshot, bool, error) { return loadSnapshotFile(s.path) }
+
+func (s fileStorage) Save(snap RoomSnapshot) error { return saveSnapshotFile(s.path, snap) }
+
+// roomStorage returns the storage configured for room, or nil when rooms
+// are kept in memory only.
+func (s *settings) roomStorage(room string) Storage {
+	switch {
+	case s.storage != nil:
+		return s.storage(room)
+	case s.dataDir != "":
+		return newFileStorage(s.dataDir, room)
+	}
+	return nil
+}
+
+// persister saves a hub to its Storage at most once per interval after it
+// changes, so a burst of mutations costs a single write.
+type persister struct {
+	store    Storage
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending bool
+	stopped bool
+	saveMu  sync.Mutex
+}
+
+func newPersister(store Storage, interval time.Duration) *persister {
+	return &persister{store: store, interval: interval}
+}
+
+// markDirty schedules a save of h unless one is already pending.
+func (p *persister) markDirty(h *Hub) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending {
+		return
+	}
+	p.pending = true
+	time.AfterFunc(p.interval, func() { p.flush(h) })
+}
+
+// flush saves h now. Saves are serialized so an older snapshot never
+// overwrites a newer one.
+func (p *persister) flush(h *Hub) {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+	p.mu.Lock()
+	p.pending = false
+	stopped := p.stopped
+	p.mu.Unlock()
+	if stopped {
+		return
+	}
+	if err := p.store.Save(h.snapshot()); err != nil {
+		slog.Error("save room failed", "room", h.room, "err", err)
+	}
+}
+
+// stop saves h a last time and disables later saves, so a timer firing for
+// a discarded hub cannot overwrite the file of its successor.
+func (p *persister) stop(h *Hub) {
+	p.flush(h)
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+}
+
+// load restores h from its storage, if anything was saved.
+func (p *persister) load(h *Hub) error {
+	snap, ok, err := p.store.Load()
+	if err != nil || !ok {
+		return err
+	}
+	h.restore(snap)
+	slog.Info("loaded room", "room", h.room, "points", len(snap.Points))
+	return nil
+}
+
+// flushStorage saves every room with storage now, for shutdown.
+func (m *roomManager) flushStorage() {
+	for _, h := range m.hubs() {
+		if h.storage != nil {
+			h.storage.flush(h)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package hub
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStorage keeps snapshots by room, like a database would.
+type memoryStorage struct {
+	mu    sync.Mutex
+	rooms map[string]RoomSnapshot
+}
+
+func (m *memoryStorage) forRoom(room string) Storage { return memoryRoom{m, room} }
+
+type memoryRoom struct {
+	m    *memoryStorage
+	room string
+}
+
+func (r memoryRoom) Load() (RoomSnapshot, bool, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	snap, ok := r.m.rooms[r.room]
+	return snap, ok, nil
+}
+
+func (r memoryRoom) Save(snap RoomSnapshot) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.rooms[r.room] = snap
+	return nil
+}
+
+func TestCustomStorage(t *testing.T) {
+	store := &memoryStorage{rooms: make(map[string]RoomSnapshot)}
+	s := newTestServer(t, WithStorage(store.forRoom, time.Hour), WithDataDir(t.TempDir(), time.Hour))
+	s.Room("r", func(h *Hub) { h.Add(Point{X: 1}, "test") })
+	s.Shutdown()
+
+	snap, ok, _ := store.forRoom("r").Load()
+	if !ok || len(snap.Points) != 1 || snap.Room != "r" {
+		t.Fatalf("saved %+v, %v; want room r with one point", snap, ok)
+	}
+
+	s = newTestServer(t, WithStorage(store.forRoom, time.Hour))
+	s.Room("r", func(h *Hub) {
+		if ps := h.Points(); len(ps) != 1 || ps[0].X != 1 {
+			t.Errorf("reloaded %v, want the saved point", ps)
+		}
+	})
+}
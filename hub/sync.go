@@ -0,0 +1,71 @@
+package hub
+
+// opHistory keeps a room's most recent mutations so a reconnecting client
+// can catch up on the ones it missed instead of fetching the whole room.
+// Guarded by the hub's mu.
+type opHistory struct {
+	ops   []Mutation
+	start int
+	limit int
+}
+
+func newOpHistory(limit int) *opHistory {
+	return &opHistory{limit: limit}
+}
+
+func (o *opHistory) record(m Mutation) {
+	if len(o.ops) < o.limit {
+		o.ops = append(o.ops, m)
+		return
+	}
+	o.ops[o.start] = m
+	o.start = (o.start + 1) % o.limit
+}
+
+// since returns the mutations after seq, or ok=false when some of them are
+// no longer kept.
+func (o *opHistory) since(seq, current uint64) ([]Mutation, bool) {
+	if seq >= current {
+		return nil, true
+	}
+	if len(o.ops) == 0 || o.ops[o.start].Seq > seq+1 {
+		return nil, false
+	}
+	var out []Mutation
+	for i := range o.ops {
+		m := o.ops[(o.start+i)%len(o.ops)]
+		if m.Seq > seq {
+			out = append(out, m)
+		}
+	}
+	return out, true
+}
+
+// mutationMessage is the broadcast a client would have received for m.
+func mutationMessage(m Mutation) Message {
+	p := m.Point
+	if m.Type == "move" {
+		return Message{Type: "move", Seq: m.Seq, From: m.From, To: &p}
+	}
+	return Message{Type: m.Type, Seq: m.Seq, Point: &p}
+}
+
+// missedSince returns the messages reporting every mutation after seq along
+// with the current sequence number, or ok=false when the history no longer
+// reaches back that far.
+func (h *Hub) missedSince(seq uint64) ([]Message, uint64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.history == nil || seq > h.seq {
+		return nil, h.seq, false
+	}
+	ops, ok := h.history.since(seq, h.seq)
+	if !ok {
+		return nil, h.seq, false
+	}
+	msgs := make([]Message, len(ops))
+	for i, m := range ops {
+		msgs[i] = mutationMessage(m)
+	}
+	return msgs, h.seq, true
+}
@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// transform maps incoming coordinates into the stored space: each output
+// axis i takes input axis axes[i], optionally negated, then scales and
+// offsets it.
+type transform struct {
+	axes   [3]int
+	sign   [3]float64
+	scale  [3]float64
+	offset [3]float64
+}
+
+func identityTransform() *transform {
+	return &transform{
+		axes:  [3]int{0, 1, 2},
+		sign:  [3]float64{1, 1, 1},
+		scale: [3]float64{1, 1, 1},
+	}
+}
+
+func (t *transform) apply(p Point) Point {
+	in := [3]float64{p.X, p.Y, p.Z}
+	var out [3]float64
+	for i := range out {
+		out[i] = t.sign[i]*in[t.axes[i]]*t.scale[i] + t.offset[i]
+	}
+	p.X, p.Y, p.Z = out[0], out[1], out[2]
+	return p
+}
+
+// applyDelta maps a displacement, which the offset does not affect.
+func (t *transform) applyDelta(d [3]float64) [3]float64 {
+	var out [3]float64
+	for i := range out {
+		out[i] = t.sign[i] * d[t.axes[i]] * t.scale[i]
+	}
+	return out
+}
+
+// parseTransform parses a spec such as "axes=x,-z,y;scale=0.01;offset=0,0,5".
+// scale and offset take either one value for all axes or one per axis.
+func parseTransform(spec string) (*transform, error) {
+	t := identityTransform()
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("transform: expected key=value, got %q", part)
+		}
+		switch name {
+		case "axes":
+			if err := t.parseAxes(value); err != nil {
+				return nil, err
+			}
+		case "scale":
+			v, err := parseVector(value)
+			if err != nil {
+				return nil, fmt.Errorf("transform scale: %w", err)
+			}
+			t.scale = v
+		case "offset":
+			v, err := parseVector(value)
+			if err != nil {
+				return nil, fmt.Errorf("transform offset: %w", err)
+			}
+			t.offset = v
+		default:
+			return nil, fmt.Errorf("transform: unknown key %q", name)
+		}
+	}
+	return t, nil
+}
+
+func (t *transform) parseAxes(value string) error {
+	names := strings.Split(value, ",")
+	if len(names) != 3 {
+		return fmt.Errorf("transform axes: want 3 axes, got %q", value)
+	}
+	var seen [3]bool
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		t.sign[i] = 1
+		if strings.HasPrefix(name, "-") {
+			t.sign[i] = -1
+			name = name[1:]
+		}
+		axis := strings.Index("xyz", name)
+		if len(name) != 1 || axis < 0 || seen[axis] {
+			return fmt.Errorf("transform axes: %q is not a permutation of x,y,z", value)
+		}
+		seen[axis] = true
+		t.axes[i] = axis
+	}
+	return nil
+}
+
+func parseVector(value string) ([3]float64, error) {
+	var v [3]float64
+	parts := strings.Split(value, ",")
+	if len(parts) != 1 && len(parts) != 3 {
+		return v, fmt.Errorf("want 1 or 3 values, got %q", value)
+	}
+	for i := range v {
+		f, err := strconv.ParseFloat(strings.TrimSpace(parts[i%len(parts)]), 64)
+		if err != nil {
+			return v, err
+		}
+		v[i] = f
+	}
+	return v, nil
+}
@@ -0,0 +1,30 @@
+package hub
+
+import "testing"
+
+func TestTransformAppliedOnIngest(t *testing.T) {
+	tr, err := parseTransform("axes=x,-z,y;scale=2;offset=0,0,5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.apply(Point{X: 1, Y: 2, Z: 3}); got.X != 2 || got.Y != -6 || got.Z != 9 {
+		t.Errorf("apply = %+v, want (2, -6, 9)", got)
+	}
+	if got := tr.applyDelta([3]float64{1, 2, 3}); got != [3]float64{2, -6, 4} {
+		t.Errorf("applyDelta = %v, want the offset left out", got)
+	}
+	for _, spec := range []string{"axes=x,y", "scale=1,2", "shear=1", "axes"} {
+		if _, err := parseTransform(spec); err == nil {
+			t.Errorf("parseTransform(%q) succeeded", spec)
+		}
+	}
+
+	h := newHub("test")
+	h.transform = tr
+	c, fc := testClient(h)
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1, Y: 2, Z: 3}})
+	adds := fc.ofType(t, "add")
+	if len(adds) != 1 || adds[0].Point.X != 2 || adds[0].Point.Y != -6 || adds[0].Point.Z != 9 {
+		t.Errorf("add broadcast %+v, want the transformed point", adds)
+	}
+}
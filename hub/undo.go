@@ -0,0 +1,151 @@
+package hub
+
+// change is one undoable operation of a client: the points it removed and
+// the ones it added, as stored. A move removes its origin and adds its
+// destination.
+type change struct {
+	removed []Point
+	added   []Point
+	move    bool
+}
+
+func (ch change) inverse() change {
+	return change{removed: ch.added, added: ch.removed, move: ch.move}
+}
+
+func (ch change) empty() bool { return len(ch.removed) == 0 && len(ch.added) == 0 }
+
+// undoHistory holds a connection's most recent changes for undo and the ones
+// it undid for redo. Only the connection's read loop uses it, so it needs no
+// lock.
+type undoHistory struct {
+	depth int
+	undo  []change
+	redo  []change
+}
+
+func newUndoHistory(depth int) *undoHistory {
+	return &undoHistory{depth: depth}
+}
+
+func pushChange(stack []change, ch change, depth int) []change {
+	if len(stack) == depth {
+		stack = append(stack[:0], stack[1:]...)
+	}
+	return append(stack, ch)
+}
+
+// record notes a new change, which makes the undone ones unreachable.
+// Consecutive moves of the same point, such as a drag, are merged into one.
+func (u *undoHistory) record(h *Hub, ch change) {
+	if u == nil || ch.empty() {
+		return
+	}
+	u.redo = nil
+	if n := len(u.undo); n > 0 && ch.move && u.undo[n-1].move && h.key(u.undo[n-1].added[0]) == h.key(ch.removed[0]) {
+		u.undo[n-1].added = ch.added
+		return
+	}
+	u.undo = pushChange(u.undo, ch, u.depth)
+}
+
+// undo reverts c's most recent change and returns what was reverted; points
+// that were changed by others since are left alone.
+func (h *Hub) undo(c *client) (change, bool) {
+	u := c.undo
+	if u == nil || len(u.undo) == 0 {
+		return change{}, false
+	}
+	ch := u.undo[len(u.undo)-1]
+	u.undo = u.undo[:len(u.undo)-1]
+	done := h.applyChange(c, ch.inverse())
+	if !done.empty() {
+		u.redo = pushChange(u.redo, done.inverse(), u.depth)
+	}
+	return done, true
+}
+
+// redo reapplies the change most recently undone by c.
+func (h *Hub) redo(c *client) (change, bool) {
+	u := c.undo
+	if u == nil || len(u.redo) == 0 {
+		return change{}, false
+	}
+	ch := u.redo[len(u.redo)-1]
+	u.redo = u.redo[:len(u.redo)-1]
+	done := h.applyChange(c, ch)
+	if !done.empty() {
+		u.undo = pushChange(u.undo, done, u.depth)
+	}
+	return done, true
+}
+
+// applyChange removes ch.removed and adds ch.added under the room's rules,
+// broadcasting the outcome, and returns the part that could be applied.
+// Points are only removed or moved while they are still the ones recorded.
+func (h *Hub) applyChange(c *client, ch change) change {
+	if ch.move {
+		if len(h.recorded(c, ch.removed, false)) == 0 {
+			return change{}
+		}
+		from, to, err := h.moveByKey(h.key(ch.removed[0]), c.id, func(p Point) Point {
+			p.X, p.Y, p.Z = ch.added[0].X, ch.added[0].Y, ch.added[0].Z
+			return p
+		})
+		if err != nil {
+			return change{}
+		}
+		h.moves.add(h.key(from), h.key(to), from, to)
+		return change{removed: []Point{from}, added: []Point{to}, move: true}
+	}
+	removed, _ := h.removePoints(h.recorded(c, ch.removed, true), c.id)
+	h.afterRemove(removed)
+	if len(removed) > 0 {
+		h.broadcast(Message{Type: "removeBatch", Points: removed})
+	}
+	added := h.reinsert(ch.added, c.principal())
+	if len(added) > 0 {
+		h.broadcast(Message{Type: "addBatch", Points: added})
+	}
+	return change{removed: removed, added: added}
+}
+
+// recorded returns the points of ps still stored as they were recorded, not
+// removed and added again by someone else meanwhile. With removal, only the
+// ones c may remove under the room's ownership rule are returned.
+func (h *Hub) recorded(c *client, ps []Point, removal bool) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	anyOwner := !removal || h.removesAny(role(c.role.Load()))
+	var kept []Point
+	for _, p := range ps {
+		stored, ok := h.points[h.key(p)]
+		if !ok || stored.ID != p.ID || stored.Owner != p.Owner || stored.CreatedAt != p.CreatedAt {
+			continue
+		}
+		if !anyOwner && stored.Owner != c.principal() {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// reinsert adds back points exactly as they were stored, keeping their id,
+// owner and creation time, for those the room still admits.
+func (h *Hub) reinsert(ps []Point, actor string) []Point {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	added := make([]Point, 0, len(ps))
+	for _, p := range ps {
+		key := h.key(p)
+		if _, err := h.admit(p, key); err != nil {
+			continue
+		}
+		h.points[key] = p
+		h.trackExpiry(p)
+		h.emit(Mutation{Type: "add", Actor: actor, Point: p})
+		added = append(added, p)
+	}
+	return added
+}
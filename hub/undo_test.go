@@ -0,0 +1,168 @@
+package hub
+
+import (
+	"testing"
+	"time"
+)
+
+func undoHub(depth int) *Hub {
+	h := newHub("test")
+	h.undoDepth = depth
+	return h
+}
+
+func (h *Hub) has(p Point) (Point, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stored, ok := h.points[h.key(p)]
+	return stored, ok
+}
+
+func TestUndoAndRedoAdd(t *testing.T) {
+	h := undoHub(10)
+	c, fc := testClient(h)
+	p := Point{X: 1, Label: "a"}
+	send(t, h, c, Message{Type: "add", Point: &p})
+	added, _ := h.has(p)
+
+	send(t, h, c, Message{Type: "undo"})
+	if _, ok := h.has(p); ok {
+		t.Fatal("undo left the added point")
+	}
+	send(t, h, c, Message{Type: "redo"})
+	back, ok := h.has(p)
+	if !ok || back.Owner != added.Owner || back.CreatedAt != added.CreatedAt || back.Label != "a" {
+		t.Fatalf("redo restored %+v, want %+v", back, added)
+	}
+	send(t, h, c, Message{Type: "redo"})
+	if code := errorCode(t, fc); code != errNotFound {
+		t.Errorf("second redo: error %q, want %q", code, errNotFound)
+	}
+}
+
+func TestUndoSkipsPointsChangedByOthers(t *testing.T) {
+	h := undoHub(10)
+	a, afc := testClient(h)
+	b, _ := testClient(h)
+	a.identity, b.identity = "alice", "alice"
+	p := Point{X: 1}
+	send(t, h, a, Message{Type: "add", Point: &p})
+
+	// Another connection replaces the point with one at the same key. The
+	// owner is the same, so only the creation time tells them apart.
+	time.Sleep(2 * time.Millisecond)
+	send(t, h, b, Message{Type: "remove", Point: &p})
+	send(t, h, b, Message{Type: "add", Point: &Point{X: 1, Label: "b"}})
+	replaced, _ := h.has(p)
+
+	send(t, h, a, Message{Type: "undo"})
+	if got, ok := h.has(p); !ok || got.Label != "b" || got.CreatedAt != replaced.CreatedAt {
+		t.Fatalf("undo touched the other connection's point: %+v, %v", got, ok)
+	}
+	// Nothing was reverted, so there is nothing to redo.
+	send(t, h, a, Message{Type: "redo"})
+	if code := errorCode(t, afc); code != errNotFound {
+		t.Errorf("redo after a skipped undo: error %q, want %q", code, errNotFound)
+	}
+}
+
+func TestUndoSkipsMovesOfPointsMovedByOthers(t *testing.T) {
+	h := undoHub(10)
+	a, _ := testClient(h)
+	b, _ := testClient(h)
+	p1, p2, p3 := Point{X: 1}, Point{X: 2}, Point{X: 3}
+	send(t, h, a, Message{Type: "add", Point: &p1})
+	send(t, h, a, Message{Type: "move", From: &p1, To: &p2})
+	send(t, h, b, Message{Type: "move", From: &p2, To: &p3})
+
+	send(t, h, a, Message{Type: "undo"})
+	if _, ok := h.has(p3); !ok {
+		t.Fatal("undo moved a point another connection had moved since")
+	}
+	if _, ok := h.has(p1); ok {
+		t.Fatal("undo recreated the origin of the move")
+	}
+	// Nor is anything at the key a's add recorded left to remove.
+	send(t, h, a, Message{Type: "undo"})
+	if _, ok := h.has(p3); !ok {
+		t.Fatal("undo removed the point b moved")
+	}
+}
+
+func TestUndoMergesDragsAndBoundsDepth(t *testing.T) {
+	h := undoHub(2)
+	c, fc := testClient(h)
+	p1, p2, p3, q := Point{X: 1}, Point{X: 2}, Point{X: 3}, Point{X: 9}
+	send(t, h, c, Message{Type: "add", Point: &q})
+	send(t, h, c, Message{Type: "add", Point: &p1})
+	send(t, h, c, Message{Type: "move", From: &p1, To: &p2})
+	send(t, h, c, Message{Type: "move", From: &p2, To: &p3})
+
+	// The two moves count as one change, and the first add fell off.
+	send(t, h, c, Message{Type: "undo"})
+	if _, ok := h.has(p1); !ok {
+		t.Fatal("undoing a drag did not return the point to its start")
+	}
+	send(t, h, c, Message{Type: "undo"})
+	if _, ok := h.has(p1); ok {
+		t.Fatal("second undo did not revert the add")
+	}
+	send(t, h, c, Message{Type: "undo"})
+	if code := errorCode(t, fc); code != errNotFound {
+		t.Errorf("undo past the depth: error %q, want %q", code, errNotFound)
+	}
+	if _, ok := h.has(q); !ok {
+		t.Error("a change beyond the depth was undone")
+	}
+}
+
+func TestNewChangeClearsRedo(t *testing.T) {
+	h := undoHub(10)
+	c, fc := testClient(h)
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1}})
+	send(t, h, c, Message{Type: "undo"})
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 2}})
+	send(t, h, c, Message{Type: "redo"})
+	if code := errorCode(t, fc); code != errNotFound {
+		t.Errorf("redo after a new change: error %q, want %q", code, errNotFound)
+	}
+	if _, ok := h.has(Point{X: 1}); ok {
+		t.Error("redo reapplied a change made unreachable")
+	}
+}
+
+func TestUndoHistoryDoesNotSurviveReconnect(t *testing.T) {
+	h := undoHub(10)
+	old, _ := testClient(h)
+	old.identity = "alice"
+	p := Point{X: 1}
+	send(t, h, old, Message{Type: "add", Point: &p})
+	send(t, h, old, Message{Type: "add", Point: &Point{X: 2}})
+	send(t, h, old, Message{Type: "undo"})
+	h.removeConn(old)
+
+	c, fc := testClient(h)
+	c.identity = "alice"
+	send(t, h, c, Message{Type: "undo"})
+	if code := errorCode(t, fc); code != errNotFound {
+		t.Fatalf("undo on a new connection: error %q, want %q", code, errNotFound)
+	}
+	send(t, h, c, Message{Type: "redo"})
+	if _, ok := h.has(Point{X: 2}); ok {
+		t.Fatal("a new connection redid the previous one's undo")
+	}
+	if _, ok := h.has(p); !ok {
+		t.Fatal("the previous connection's add was undone")
+	}
+
+	// The new connection's own changes are undoable.
+	q := Point{X: 3}
+	send(t, h, c, Message{Type: "add", Point: &q})
+	send(t, h, c, Message{Type: "undo"})
+	if _, ok := h.has(q); ok {
+		t.Error("undo after reconnect did not revert the new connection's add")
+	}
+	if _, ok := h.has(p); !ok {
+		t.Error("undo after reconnect reached the previous connection's change")
+	}
+}
@@ -0,0 +1,128 @@
+package hub
+
+// pointUpdate changes the metadata of the point identified by Point. Nil
+// fields are left as they are.
+//
+// Meta is merged into the point's map by default, an empty value removing
+// its key; with MetaMode "replace" it replaces the map, so an empty Meta
+// clears it.
+type pointUpdate struct {
+	Point    Point             `json:"point"`
+	Weight   *float64          `json:"weight,omitempty"`
+	Color    *string           `json:"color,omitempty"`
+	Label    *string           `json:"label,omitempty"`
+	Pinned   *bool             `json:"pinned,omitempty"`
+	Velocity *[3]float64       `json:"velocity,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	MetaMode string            `json:"metaMode,omitempty"`
+}
+
+func (u pointUpdate) validate(meta metaLimits) *validationError {
+	if u.Weight != nil {
+		if err := validateWeight(*u.Weight); err != nil {
+			return err
+		}
+	}
+	if u.Color != nil {
+		if err := validateColor(*u.Color); err != nil {
+			return err
+		}
+	}
+	if u.Label != nil {
+		if err := validateLabel(*u.Label); err != nil {
+			return err
+		}
+	}
+	if u.Velocity != nil {
+		if err := validateVelocity(*u.Velocity); err != nil {
+			return err
+		}
+	}
+	if u.MetaMode != "" && u.MetaMode != "merge" && u.MetaMode != "replace" {
+		return invalid(errInvalidMeta, `metaMode must be "merge" or "replace"`)
+	}
+	return meta.check(u.Meta)
+}
+
+// apply changes p and checks that its merged metadata stays within limits.
+func (u pointUpdate) apply(p *Point, meta metaLimits) *validationError {
+	if u.Weight != nil {
+		p.Weight = *u.Weight
+	}
+	if u.Color != nil {
+		p.Color = *u.Color
+	}
+	if u.Label != nil {
+		p.Label = *u.Label
+	}
+	if u.Pinned != nil {
+		p.Pinned = *u.Pinned
+	}
+	if u.Velocity != nil {
+		if *u.Velocity == ([3]float64{}) {
+			p.Velocity = nil
+		} else {
+			v := *u.Velocity
+			p.Velocity = &v
+		}
+	}
+	switch {
+	case u.MetaMode == "replace":
+		p.Meta = u.Meta
+	case u.Meta != nil:
+		// Stored points share their map with earlier copies, so build a new
+		// one.
+		merged := make(map[string]string, len(p.Meta)+len(u.Meta))
+		for k, v := range p.Meta {
+			merged[k] = v
+		}
+		for k, v := range u.Meta {
+			if v == "" {
+				delete(merged, k)
+			} else {
+				merged[k] = v
+			}
+		}
+		if len(merged) == 0 {
+			merged = nil
+		}
+		p.Meta = merged
+	}
+	return meta.check(p.Meta)
+}
+
+// updatePoints validates and applies every update under a single lock
+// acquisition. It returns the updated points and a result for each update
+// that was rejected, either because it was invalid or because its point does
+// not exist; the others are applied regardless.
+func (h *Hub) updatePoints(updates []pointUpdate, actor string) ([]Point, []itemResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var updated []Point
+	var rejected []itemResult
+	for i, u := range updates {
+		if err := u.validate(h.meta); err != nil {
+			rejected = append(rejected, itemResult{Index: i, Status: "rejected", Code: err.Code, Reason: err.Reason})
+			continue
+		}
+		key := h.key(u.Point)
+		stored, exists := h.points[key]
+		if !exists {
+			rejected = append(rejected, itemResult{Index: i, Status: "rejected", Code: errNotFound, Reason: "no such point"})
+			continue
+		}
+		if err := h.lockErr(key, actor); err != nil {
+			rejected = append(rejected, itemResult{Index: i, Status: "rejected", Code: err.Code, Reason: err.Reason})
+			continue
+		}
+		if err := u.apply(&stored, h.meta); err != nil {
+			rejected = append(rejected, itemResult{Index: i, Status: "rejected", Code: err.Code, Reason: err.Reason})
+			continue
+		}
+		h.points[key] = stored
+		h.trackExpiry(stored)
+		h.emit(Mutation{Type: "update", Actor: actor, Point: stored})
+		updated = append(updated, stored)
+	}
+	return updated, rejected
+}
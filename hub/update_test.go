@@ -0,0 +1,66 @@
+package hub
+
+import "testing"
+
+func TestUpdateBatchAppliesTheGoodEntries(t *testing.T) {
+	h := newHub("test")
+	c, fc := testClient(h)
+	_, watcher := testClient(h)
+	send(t, h, c, Message{Type: "addBatch", Points: []Point{{X: 1}, {X: 2}}})
+	watcher.messages(t)
+	fc.messages(t)
+
+	red, bad, label := "#ff0000", "nope", "a"
+	send(t, h, c, Message{Type: "updateBatch", Updates: []pointUpdate{
+		{Point: Point{X: 1}, Color: &red, Label: &label},
+		{Point: Point{X: 2}, Color: &bad},
+		{Point: Point{X: 9}, Color: &red},
+	}})
+
+	batches := watcher.ofType(t, "updateBatch")
+	if len(batches) != 1 || len(batches[0].Points) != 1 || batches[0].Points[0].Color != red || batches[0].Points[0].Label != label {
+		t.Fatalf("watcher got %+v, want one batch with the recoloured point", batches)
+	}
+	results := fc.ofType(t, "updateBatchResult")
+	if len(results) != 1 || len(results[0].Results) != 2 {
+		t.Fatalf("sender got %+v, want two rejections", results)
+	}
+	if r := results[0].Results; r[0].Index != 1 || r[0].Code != errInvalidColor || r[1].Index != 2 || r[1].Code != errNotFound {
+		t.Errorf("rejections = %+v", r)
+	}
+	if p := h.points[h.key(Point{X: 2})]; p.Color != "" {
+		t.Errorf("rejected entry applied: %+v", p)
+	}
+}
+
+func TestUpdateMergesOrReplacesMeta(t *testing.T) {
+	h := newHub("test")
+	c, fc := testClient(h)
+	p := Point{X: 1, Meta: map[string]string{"src": "gps", "conf": "0.9"}}
+	send(t, h, c, Message{Type: "add", Point: &p})
+	// Meta is not part of the key.
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1, Meta: map[string]string{"src": "radar"}}})
+	if len(h.points) != 1 {
+		t.Fatalf("points differing only in meta stored separately: %+v", h.points)
+	}
+
+	meta := func() map[string]string { return h.points[h.key(p)].Meta }
+	send(t, h, c, Message{Type: "update", Point: &p, Meta: map[string]string{"conf": "", "cat": "a"}})
+	if m := meta(); len(m) != 2 || m["src"] != "gps" || m["cat"] != "a" {
+		t.Errorf("after merge meta = %v, want src kept, conf removed and cat added", m)
+	}
+	send(t, h, c, Message{Type: "update", Point: &p, Meta: map[string]string{"cat": "b"}, MetaMode: "replace"})
+	if m := meta(); len(m) != 1 || m["cat"] != "b" {
+		t.Errorf("after replace meta = %v, want only cat", m)
+	}
+
+	// A merge pushing the map over the limit is rejected.
+	h.meta = metaLimits{keys: 2, bytes: 1024}
+	send(t, h, c, Message{Type: "update", Point: &p, Meta: map[string]string{"x": "1", "y": "2"}})
+	if code := errorCode(t, fc); code != errInvalidMeta {
+		t.Errorf("merge over the key limit: error %q, want %q", code, errInvalidMeta)
+	}
+	if m := meta(); len(m) != 1 {
+		t.Errorf("rejected merge changed meta to %v", m)
+	}
+}
@@ -0,0 +1,198 @@
+package hub
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	errBatchTooLarge   = "batch_too_large"
+	errInvalidCoords   = "invalid_coordinates"
+	errInvalidID       = "invalid_id"
+	errInvalidWeight   = "invalid_weight"
+	errInvalidColor    = "invalid_color"
+	errInvalidLabel    = "invalid_label"
+	errInvalidNickname = "invalid_nickname"
+	errInvalidTTL      = "invalid_ttl"
+	errInvalidVelocity = "invalid_velocity"
+	errInvalidPath     = "invalid_path"
+	errInvalidMeta     = "invalid_meta"
+	errInvalidChat     = "invalid_chat"
+	errNotFound        = "not_found"
+	errReadOnly        = "read_only"
+	errDuplicate       = "duplicate"
+	errBadRequest      = "bad_request"
+	errInvalidMessage  = "invalid_message"
+	errLocked          = "locked"
+	errTooClose        = "too_close"
+	errRoomFull        = "room_full"
+	errLimitExceeded   = "limit_exceeded"
+	errRateLimited     = "rate_limited"
+	errUpstream        = "upstream_error"
+	errUnauthorized    = "unauthorized"
+)
+
+const (
+	maxIDLength    = 64
+	maxLabelLength = 256
+	maxPathLength  = 256
+	maxPathDepth   = 16
+)
+
+// metaLimits bound a point's metadata: the number of keys and the total
+// bytes of keys and values together.
+type metaLimits struct {
+	keys  int
+	bytes int
+}
+
+var (
+	colorPattern       = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	pathSegmentPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// validationError carries the protocol error code reported to clients along
+// with a human-readable reason.
+type validationError struct {
+	Code   string
+	Reason string
+}
+
+func (e *validationError) Error() string { return e.Reason }
+
+func invalid(code, format string, args ...interface{}) *validationError {
+	return &validationError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+func validatePoint(p Point, meta metaLimits) *validationError {
+	for _, v := range [...]float64{p.X, p.Y, p.Z} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return invalid(errInvalidCoords, "coordinates must be finite numbers")
+		}
+	}
+	if p.Velocity != nil {
+		if err := validateVelocity(*p.Velocity); err != nil {
+			return err
+		}
+	}
+	if len(p.ID) > maxIDLength {
+		return invalid(errInvalidID, "point id longer than %d bytes", maxIDLength)
+	}
+	if err := validateWeight(p.Weight); err != nil {
+		return err
+	}
+	if p.TTLMs < 0 || p.ExpiresAt < 0 {
+		return invalid(errInvalidTTL, "ttlMs and expiresAt must not be negative")
+	}
+	if err := validateColor(p.Color); err != nil {
+		return err
+	}
+	if err := validateLabel(p.Label); err != nil {
+		return err
+	}
+	if p.Path != "" {
+		if err := validatePath(p.Path); err != nil {
+			return err
+		}
+	}
+	return meta.check(p.Meta)
+}
+
+func validateVelocity(v [3]float64) *validationError {
+	for _, c := range v {
+		if math.IsNaN(c) || math.IsInf(c, 0) {
+			return invalid(errInvalidVelocity, "velocity components must be finite numbers")
+		}
+	}
+	return nil
+}
+
+func (l metaLimits) check(meta map[string]string) *validationError {
+	if len(meta) > l.keys {
+		return invalid(errInvalidMeta, "more than %d meta keys", l.keys)
+	}
+	size := 0
+	for k, v := range meta {
+		if k == "" || !utf8.ValidString(k) || !utf8.ValidString(v) {
+			return invalid(errInvalidMeta, "meta keys must be non-empty and keys and values valid UTF-8")
+		}
+		size += len(k) + len(v)
+	}
+	if size > l.bytes {
+		return invalid(errInvalidMeta, "meta larger than %d bytes", l.bytes)
+	}
+	return nil
+}
+
+// validateColor accepts an empty color or a #rgb / #rrggbb hex string.
+func validateColor(c string) *validationError {
+	if c != "" && !colorPattern.MatchString(c) {
+		return invalid(errInvalidColor, "color must be #rgb or #rrggbb")
+	}
+	return nil
+}
+
+func validateLabel(l string) *validationError {
+	if len(l) > maxLabelLength || !utf8.ValidString(l) {
+		return invalid(errInvalidLabel, "label must be valid UTF-8 of at most %d bytes", maxLabelLength)
+	}
+	return nil
+}
+
+// validatePath accepts slash-separated names such as "system/planet/moon".
+func validatePath(path string) *validationError {
+	if len(path) > maxPathLength {
+		return invalid(errInvalidPath, "path longer than %d bytes", maxPathLength)
+	}
+	segments := strings.Split(path, "/")
+	if len(segments) > maxPathDepth {
+		return invalid(errInvalidPath, "path deeper than %d segments", maxPathDepth)
+	}
+	for _, seg := range segments {
+		if !pathSegmentPattern.MatchString(seg) || seg == "." || seg == ".." {
+			return invalid(errInvalidPath, "path segments must be non-empty names of letters, digits, '_', '.' or '-'")
+		}
+	}
+	return nil
+}
+
+// pathHasPrefix reports whether path is prefix itself or one of its
+// descendants; "a/b" matches "a/b" and "a/b/c" but not "a/bc".
+func pathHasPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+func validateWeight(w float64) *validationError {
+	if math.IsNaN(w) || math.IsInf(w, 0) || w < 0 {
+		return invalid(errInvalidWeight, "weight must be a finite non-negative number")
+	}
+	return nil
+}
+
+// validatePoints checks every point and returns the index of the first
+// invalid one, or -1 when all are valid.
+func validatePoints(ps []Point, meta metaLimits) (int, *validationError) {
+	for i, p := range ps {
+		if err := validatePoint(p, meta); err != nil {
+			return i, err
+		}
+	}
+	return -1, nil
+}
+
+// rejectInvalid returns a rejected result for every invalid point in ps.
+func rejectInvalid(ps []Point, meta metaLimits) []itemResult {
+	var rejected []itemResult
+	for i, p := range ps {
+		if err := validatePoint(p, meta); err != nil {
+			rejected = append(rejected, itemResult{Index: i, Status: "rejected", Code: err.Code, Reason: err.Reason})
+		}
+	}
+	return rejected
+}
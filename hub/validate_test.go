@@ -0,0 +1,80 @@
+package hub
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestPointWeights(t *testing.T) {
+	var p Point
+	if err := json.Unmarshal([]byte(`{"x":1}`), &p); err != nil || p.Weight != 1 {
+		t.Errorf("omitted weight decoded as %v (%v), want 1", p.Weight, err)
+	}
+	for _, w := range []float64{-1, math.NaN(), math.Inf(1)} {
+		if err := validatePoint(Point{Weight: w}, metaLimits{}); err == nil || err.Code != errInvalidWeight {
+			t.Errorf("weight %v: got %v, want %s", w, err, errInvalidWeight)
+		}
+	}
+
+	h := newHub("test")
+	c, fc := testClient(h)
+	send(t, h, c, Message{Type: "add", Point: &Point{X: 1, Weight: 2.5}})
+	if adds := fc.ofType(t, "add"); len(adds) != 1 || adds[0].Point.Weight != 2.5 {
+		t.Errorf("add broadcast %+v, want weight 2.5", adds)
+	}
+	w := 4.0
+	send(t, h, c, Message{Type: "update", Point: &Point{X: 1}, Weight: &w})
+	if ups := fc.ofType(t, "update"); len(ups) != 1 || ups[0].Point.Weight != 4 {
+		t.Errorf("update broadcast %+v, want weight 4", ups)
+	}
+}
+
+func TestPaths(t *testing.T) {
+	for _, path := range []string{"a", "sol/earth/moon", "v1.2/x_y-z"} {
+		if err := validatePath(path); err != nil {
+			t.Errorf("validatePath(%q) = %v", path, err)
+		}
+	}
+	for _, path := range []string{"", "a//b", "/a", "a/", "a/../b", "a b"} {
+		if err := validatePath(path); err == nil {
+			t.Errorf("validatePath(%q) accepted", path)
+		}
+	}
+	for _, tc := range []struct {
+		path, prefix string
+		want         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b/c", "a/b", true},
+		{"a/bc", "a/b", false},
+		{"a", "a/b", false},
+	} {
+		if got := pathHasPrefix(tc.path, tc.prefix); got != tc.want {
+			t.Errorf("pathHasPrefix(%q, %q) = %v", tc.path, tc.prefix, got)
+		}
+	}
+}
+
+func TestMetaLimits(t *testing.T) {
+	limits := metaLimits{keys: 2, bytes: 10}
+	for _, meta := range []map[string]string{
+		nil,
+		{"src": "gps"},
+		{"a": "1", "b": "1234567"},
+	} {
+		if err := limits.check(meta); err != nil {
+			t.Errorf("check(%v) = %v", meta, err)
+		}
+	}
+	for _, meta := range []map[string]string{
+		{"a": "1", "b": "2", "c": "3"},
+		{"a": "1234567890"},
+		{"": "x"},
+		{"k": "\xff"},
+	} {
+		if err := limits.check(meta); err == nil || err.Code != errInvalidMeta {
+			t.Errorf("check(%q) = %v, want %s", meta, err, errInvalidMeta)
+		}
+	}
+}
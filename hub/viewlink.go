@@ -0,0 +1,49 @@
+package hub
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultViewLinkTTL is how long view links stay valid unless ttl is given.
+const defaultViewLinkTTL = 7 * 24 * time.Hour
+
+// viewLinkHandler lets a room's admins mint a view link (POST): a signed
+// token that lets anyone holding it watch the room, and nothing more, until
+// it expires. The optional ttl parameter is a duration such as 24h.
+func (m *roomManager) viewLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeErrorResponse(w, http.StatusMethodNotAllowed, errBadRequest, "method not allowed")
+		return
+	}
+	name, ok := roomFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if _, _, ok := m.authorize(w, r, name, roleAdmin); !ok {
+		return
+	}
+	ttl := defaultViewLinkTTL
+	if s := r.URL.Query().Get("ttl"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			writeErrorResponse(w, http.StatusBadRequest, errBadRequest, "ttl must be a positive duration")
+			return
+		}
+		ttl = d
+	}
+	expires := time.Now().Add(ttl)
+	token, err := m.jwt.sign(jwtClaims{Subject: "view-link", ExpiresAt: expires.Unix(), Room: name, Role: roleViewer.String()})
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, errBadRequest, err.Error())
+		return
+	}
+	q := url.Values{"room": {name}, "mode": {"view"}, "access_token": {token}}
+	writeJSONResponse(w, m.wire, http.StatusOK, struct {
+		Token     string `json:"token"`
+		URL       string `json:"url"`
+		ExpiresAt int64  `json:"expiresAt"`
+	}{token, "/?" + q.Encode(), expires.UnixMilli()})
+}
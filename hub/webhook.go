@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// webhookBuffer is how many mutations each webhook queues before new
+	// ones are dropped.
+	webhookBuffer = 10000
+	// webhookAttempts bounds the deliveries of one batch.
+	webhookAttempts = 6
+)
+
+// webhook POSTs batches of mutations made on this instance to a URL from its
+// own goroutine. A batch goes out when it is full or interval after its
+// first mutation; failed deliveries are retried with exponential backoff.
+type webhook struct {
+	url      string
+	secret   []byte
+	batch    int
+	interval time.Duration
+	client   *http.Client
+	entries  chan auditEntry
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+func newWebhook(url, secret string, batch int, interval time.Duration) *webhook {
+	w := &webhook{
+		url:      url,
+		secret:   []byte(secret),
+		batch:    batch,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		entries:  make(chan auditEntry, webhookBuffer),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *webhook) record(m Mutation) {
+	if m.Remote {
+		// The instance that made it notifies.
+		return
+	}
+	select {
+	case <-w.quit:
+		return
+	default:
+	}
+	e := auditEntry{Time: m.Time.UnixMilli(), Seq: m.Seq, Room: m.Room, Op: m.Type, Actor: m.Actor, Point: m.Point, From: m.From}
+	select {
+	case w.entries <- e:
+	default:
+		slog.Warn("webhook buffer full, dropping mutation", "url", w.url, "room", m.Room, "type", m.Type)
+	}
+}
+
+func (w *webhook) run() {
+	defer close(w.done)
+	var pending []auditEntry
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-w.quit:
+			for n := len(w.entries); n > 0; n-- {
+				pending = append(pending, <-w.entries)
+			}
+			if len(pending) > 0 {
+				w.deliver(pending)
+			}
+			return
+		case e := <-w.entries:
+			if len(pending) == 0 {
+				timer = time.After(w.interval)
+			}
+			pending = append(pending, e)
+			if len(pending) < w.batch {
+				continue
+			}
+		case <-timer:
+		}
+		w.deliver(pending)
+		pending, timer = nil, nil
+	}
+}
+
+// deliver posts events until the endpoint accepts them, the attempts run out
+// or the webhook is closed.
+func (w *webhook) deliver(events []auditEntry) {
+	body, err := json.Marshal(struct {
+		Events []auditEntry `json:"events"`
+	}{events})
+	if err != nil {
+		slog.Error("webhook encode failed", "err", err)
+		return
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			slog.Error("webhook delivery dropped", "url", w.url, "events", len(events), "attempts", attempt, "err", err)
+			return
+		}
+		slog.Warn("webhook delivery failed, retrying", "url", w.url, "in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-w.quit:
+			slog.Error("webhook delivery dropped on close", "url", w.url, "events", len(events), "err", err)
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// post sends one delivery, reporting whether a failure is worth retrying.
+func (w *webhook) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set("X-Universe-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %s", resp.Status)
+	}
+	return false, fmt.Errorf("status %s", resp.Status)
+}
+
+// Close delivers what is queued, without retrying, and stops the webhook.
+func (w *webhook) Close() {
+	close(w.quit)
+	<-w.done
+}
@@ -0,0 +1,189 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// wireEncoding is how JSON sent to and read from clients over WebSocket and
+// REST is spelled. The zero value is camelCase without rounding.
+type wireEncoding struct {
+	// snakeCase selects snake_case keys (start_time) instead of camelCase
+	// (startTime). Files written by the server keep camelCase either way.
+	snakeCase bool
+	// scale, when not zero, rounds the coordinates of points in WebSocket
+	// messages to multiples of 1/scale. Stored points keep full precision;
+	// rounding the same value always gives the same output.
+	scale float64
+}
+
+func newWireEncoding(snakeCase bool, decimals int) wireEncoding {
+	e := wireEncoding{snakeCase: snakeCase}
+	if decimals >= 0 {
+		e.scale = math.Pow10(decimals)
+	}
+	return e
+}
+
+func (e wireEncoding) marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(Message); ok && e.scale != 0 {
+		v = roundMessage(m, e.scale)
+	}
+	data, err := json.Marshal(v)
+	if err != nil || !e.snakeCase {
+		return data, err
+	}
+	return renameKeys(data, toSnake)
+}
+
+func (e wireEncoding) unmarshal(data []byte, v interface{}) error {
+	if e.snakeCase {
+		renamed, err := renameKeys(data, toCamel)
+		if err != nil {
+			return err
+		}
+		data = renamed
+	}
+	return json.Unmarshal(data, v)
+}
+
+func roundPoint(p Point, scale float64) Point {
+	p.X = math.Round(p.X*scale) / scale
+	p.Y = math.Round(p.Y*scale) / scale
+	p.Z = math.Round(p.Z*scale) / scale
+	return p
+}
+
+func roundPoints(ps []Point, scale float64) []Point {
+	if ps == nil {
+		return nil
+	}
+	out := make([]Point, len(ps))
+	for i, p := range ps {
+		out[i] = roundPoint(p, scale)
+	}
+	return out
+}
+
+func roundRef(p *Point, scale float64) *Point {
+	if p == nil {
+		return nil
+	}
+	r := roundPoint(*p, scale)
+	return &r
+}
+
+// roundMessage returns a copy of m with every point coordinate rounded.
+func roundMessage(m Message, scale float64) Message {
+	m.Point = roundRef(m.Point, scale)
+	m.From = roundRef(m.From, scale)
+	m.To = roundRef(m.To, scale)
+	m.Points = roundPoints(m.Points, scale)
+	m.Selection = roundPoints(m.Selection, scale)
+	if m.Locks != nil {
+		locks := make([]lockState, len(m.Locks))
+		for i, l := range m.Locks {
+			l.Point = roundPoint(l.Point, scale)
+			locks[i] = l
+		}
+		m.Locks = locks
+	}
+	if m.Signals != nil {
+		signals := make([]signalEvent, len(m.Signals))
+		for i, e := range m.Signals {
+			e.Point = roundPoint(e.Point, scale)
+			signals[i] = e
+		}
+		m.Signals = signals
+	}
+	if m.Results != nil {
+		results := make([]itemResult, len(m.Results))
+		for i, r := range m.Results {
+			r.Point = roundRef(r.Point, scale)
+			results[i] = r
+		}
+		m.Results = results
+	}
+	if m.Updates != nil {
+		updates := make([]pointUpdate, len(m.Updates))
+		for i, u := range m.Updates {
+			u.Point = roundPoint(u.Point, scale)
+			updates[i] = u
+		}
+		m.Updates = updates
+	}
+	if m.Messages != nil {
+		msgs := make([]Message, len(m.Messages))
+		for i, sub := range m.Messages {
+			msgs[i] = roundMessage(sub, scale)
+		}
+		m.Messages = msgs
+	}
+	return m
+}
+
+// renameKeys rewrites every object key in the JSON document data.
+func renameKeys(data []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(renameValue(v, rename))
+}
+
+func renameValue(v interface{}, rename func(string) string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if k == "meta" {
+				// Meta keys belong to integrations and keep their spelling.
+				out[k] = e
+				continue
+			}
+			out[rename(k)] = renameValue(e, rename)
+		}
+		return out
+	case []interface{}:
+		for i, e := range v {
+			v[i] = renameValue(e, rename)
+		}
+	}
+	return v
+}
+
+func toSnake(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func toCamel(s string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range s {
+		switch {
+		case r == '_':
+			upper = true
+			continue
+		case upper:
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnakeCaseWire(t *testing.T) {
+	wire := newWireEncoding(true, -1)
+	data, err := wire.marshal(Message{Type: "init", StartTime: 7, Point: &Point{X: 1, Meta: map[string]string{"ownerId": "a"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(data)
+	if !strings.Contains(s, `"start_time":7`) || strings.Contains(s, "startTime") || !strings.Contains(s, `"ownerId":"a"`) {
+		t.Errorf("snake-case message = %s; want snake keys and meta keys as sent", s)
+	}
+
+	var msg Message
+	if err := wire.unmarshal([]byte(`{"type":"add","request_id":"r1","point":{"x":2}}`), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.RequestID != "r1" || msg.Point == nil || msg.Point.X != 2 {
+		t.Errorf("decoded %+v", msg)
+	}
+
+	for in, want := range map[string]string{"startTime": "start_time", "maxBatch": "max_batch", "x": "x"} {
+		if got := toSnake(in); got != want {
+			t.Errorf("toSnake(%q) = %q, want %q", in, got, want)
+		}
+		if got := toCamel(want); got != in {
+			t.Errorf("toCamel(%q) = %q, want %q", want, got, in)
+		}
+	}
+}
+
+func TestBroadcastCoordinatesAreRounded(t *testing.T) {
+	h := newHub("test")
+	h.wire = newWireEncoding(false, 2)
+	c, _ := testClient(h)
+	_, watcher := testClient(h)
+
+	p := Point{X: 0.1 + 0.2, Y: 1.23456, Z: -2.005}
+	send(t, h, c, Message{Type: "add", Point: &p})
+	adds := watcher.ofType(t, "add")
+	if len(adds) != 1 || adds[0].Point.X != 0.3 || adds[0].Point.Y != 1.23 {
+		t.Fatalf("broadcast %+v, want coordinates rounded to two decimals", adds)
+	}
+	// Stored points keep full precision.
+	if _, ok := h.points[h.key(p)]; !ok {
+		t.Errorf("stored %+v, want the point as sent", h.points)
+	}
+
+	// Nested messages and lists are rounded too.
+	data, err := h.wire.marshal(Message{Type: "delta", Messages: []Message{{Type: "addBatch", Points: []Point{{X: 1.0049}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); !strings.Contains(s, `"x":1`) || strings.Contains(s, "1.0049") {
+		t.Errorf("delta = %s", s)
+	}
+}
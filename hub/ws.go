@@ -0,0 +1,575 @@
+package hub
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newUpgrader returns the upgrader of a server's WebSocket connections.
+// checkOrigin may be nil to accept every origin.
+func newUpgrader(compress bool, checkOrigin func(r *http.Request) bool) *websocket.Upgrader {
+	if checkOrigin == nil {
+		checkOrigin = func(r *http.Request) bool { return true }
+	}
+	return &websocket.Upgrader{CheckOrigin: checkOrigin, EnableCompression: compress, Subprotocols: []string{"msgpack"}}
+}
+
+// resume sends a client reconnecting with the last sequence number it saw a
+// sync frame with the mutations it missed in messages, under the same write
+// lock discipline as sendInit. It reports false, having sent nothing, when
+// the room no longer remembers all of them.
+func (h *Hub) resume(c *client, since uint64) (bool, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	missed, seq, ok := h.missedSince(since)
+	if !ok {
+		return false, nil
+	}
+	data, err := c.encode(Message{Type: "sync", Seq: seq, StartTime: h.startTime, Mode: h.mode(), KeyDecimals: h.announcedKeyDecimals(), Quantum: h.moveQuantum, Messages: missed, Selection: h.selectedPoints(), Locks: h.lockStates(), Peers: h.peers(), Signals: h.recentSignals(), Simulation: h.simulationState(), ChatHistory: h.chatHistory()})
+	if err != nil {
+		return false, err
+	}
+	return true, c.writeFrame(data)
+}
+
+// sendInit sends the current state to a newly registered client. Snapshots
+// larger than initChunkSize are sent as an init frame without points followed
+// by initChunk frames, the last of which has done set.
+//
+// The client's write lock is held from before the snapshot is taken until the
+// last frame is written, so any broadcast racing with the snapshot reaches
+// the client afterwards; adds, removes and moves are idempotent against the
+// state it already received.
+//
+// When the encoded points exceed maxInitBytes the client is sent an initRef
+// instead, naming the snapshot URL to fetch and the sequence number the live
+// stream continues from; broadcasts with a seq at or below the fetched
+// snapshot's are already reflected in it.
+func (h *Hub) sendInit(c *client) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return h.encodeInit(c, c.writeFrame)
+}
+
+// encodeInit encodes the frames that bring c up to date with the room,
+// handing each to emit as soon as it is ready.
+func (h *Hub) encodeInit(c *client, emit func([]byte) error) error {
+	snap := h.snapshot()
+	ps := snap.Points
+	selection := h.selectedPoints()
+	write := func(msg Message) error {
+		data, err := c.encode(msg)
+		if err != nil {
+			return err
+		}
+		return emit(data)
+	}
+	initMsg := Message{Type: "init", Seq: snap.Seq, StartTime: h.startTime, Mode: h.mode(), KeyDecimals: h.announcedKeyDecimals(), Quantum: h.moveQuantum, Selection: selection, Locks: h.lockStates(), Peers: h.peers(), Signals: h.recentSignals(), Simulation: h.simulationState(), ChatHistory: h.chatHistory()}
+	if h.maxInitBytes > 0 {
+		data, err := h.wire.marshal(ps)
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > h.maxInitBytes {
+			initMsg.Type = "initRef"
+			initMsg.URL = "/snapshot.json?room=" + h.room
+			return write(initMsg)
+		}
+	}
+	if h.initChunkSize <= 0 || len(ps) <= h.initChunkSize {
+		initMsg.Points = ps
+		return write(initMsg)
+	}
+	if err := write(initMsg); err != nil {
+		return err
+	}
+	for start := 0; start < len(ps); start += h.initChunkSize {
+		end := start + h.initChunkSize
+		if end > len(ps) {
+			end = len(ps)
+		}
+		if err := write(Message{Type: "initChunk", Points: ps[start:end], Done: end == len(ps)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hub) wsHandler(w http.ResponseWriter, r *http.Request, identity string, access, granted role, spectator bool) {
+	format, err := requestFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.accept != nil && !h.accept.reserve() {
+		http.Error(w, "too many pending connections", http.StatusServiceUnavailable)
+		return
+	}
+	compressed := offersCompression(h.upgrader, r)
+	conn, err := h.upgrader.Upgrade(countingWriter{ResponseWriter: w, totals: h.metrics.totalsFor(compressed)}, r, nil)
+	if err != nil {
+		if h.accept != nil {
+			h.accept.release()
+		}
+		h.hotLog.Warn(slog.Default(), "upgrade failed", "room", h.room, "err", err)
+		return
+	}
+	if h.maxMessageBytes > 0 {
+		conn.SetReadLimit(h.maxMessageBytes)
+	}
+	var c *client
+	if h.accept != nil {
+		c = h.accept.register(h, conn, compressed, format)
+	} else {
+		c = h.addConn(conn, compressed, format)
+	}
+	c.identity = identity
+	c.role.Store(int32(access))
+	c.granted, c.spectator = granted, spectator
+	c.log.Info("connected", "identity", identity, "role", access.String(), "spectator", spectator, "remote", r.RemoteAddr)
+	joined := false
+	defer func() {
+		if joined {
+			c.presence.stop()
+			h.announce(c, "left")
+		}
+		c.log.Info("disconnected", "after", time.Since(c.connectedAt).Round(time.Millisecond), "received", c.received.Load())
+	}()
+	defer h.removeConn(c)
+	defer func() {
+		for _, p := range h.releaseLocks(c.id) {
+			p := p
+			h.broadcast(Message{Type: "unlock", Point: &p})
+		}
+	}()
+	// Without a read within readTimeout, not even a pong to the periodic
+	// pings, the connection is presumed dead and the read below fails.
+	extendRead := func() error {
+		if h.readTimeout <= 0 {
+			return nil
+		}
+		return conn.SetReadDeadline(time.Now().Add(h.readTimeout))
+	}
+	conn.SetPongHandler(func(string) error {
+		h.pong(c)
+		return extendRead()
+	})
+	if h.maxLifetime > 0 {
+		t := time.AfterFunc(h.lifetime(), func() { h.expire(c) })
+		defer t.Stop()
+	}
+
+	resumed := false
+	if s := r.URL.Query().Get("since"); s != "" {
+		if since, perr := strconv.ParseUint(s, 10, 64); perr == nil {
+			if resumed, err = h.resume(c, since); err != nil {
+				c.log.Warn("sync write failed", "err", err)
+				return
+			}
+		}
+	}
+	if !resumed {
+		if err := h.sendInit(c); err != nil {
+			c.log.Warn("init write failed", "err", err)
+			return
+		}
+	}
+	joined = true
+	h.announce(c, "joined")
+
+	if err := extendRead(); err != nil {
+		return
+	}
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				h.hotLog.Warn(c.log, "read failed", "err", err)
+			}
+			return
+		}
+		if err := extendRead(); err != nil {
+			return
+		}
+		c.lastRead.Store(time.Now().UnixMilli())
+		c.received.Add(1)
+		h.metrics.received.Add(1)
+		if c.bucket != nil {
+			ok, disconnect := c.bucket.take()
+			if disconnect {
+				c.log.Warn("dropping connection for exceeding the message rate")
+				h.metrics.droppedClients.Add(1)
+				return
+			}
+			if !ok {
+				if err := c.replyError(invalid(errRateLimited, "more than %g messages per second", h.flood.rate)); err != nil {
+					return
+				}
+				continue
+			}
+		}
+		if codec := c.codecOf(kind, data); !h.config.accepts(codec) {
+			err = c.replyError(invalid(errBadRequest, "room %s does not accept %s frames", h.room, codec))
+		} else if kind == websocket.BinaryMessage && codec == "binary" {
+			h.sequenced(func() { err = h.handleBinary(c, data) })
+		} else {
+			var msg Message
+			if codec == "msgpack" {
+				data, err = msgpackToJSON(data)
+			}
+			if err == nil {
+				err = h.wire.unmarshal(data, &msg)
+			}
+			if err != nil {
+				h.hotLog.Warn(c.log, "malformed frame", "codec", codec, "err", err)
+				// A field of the wrong type fails the whole message; the
+				// request ID may still be readable on its own.
+				var tag struct {
+					RequestID string `json:"requestId"`
+				}
+				h.wire.unmarshal(data, &tag)
+				c.requestID = tag.RequestID
+				err = c.replyError(invalid(errBadRequest, "malformed %s frame: %v", codec, err))
+			} else {
+				c.requestID = msg.RequestID
+				if mutates(msg.Type) {
+					h.sequenced(func() {
+						if err = h.handleMessage(c, msg); err == nil {
+							err = h.ack(c)
+						}
+					})
+				} else {
+					err = h.handleMessage(c, msg)
+				}
+			}
+			c.requestID = ""
+		}
+		if err != nil {
+			h.hotLog.Warn(c.log, "write failed", "err", err)
+			return
+		}
+	}
+}
+
+// handleMessage applies one message from c and broadcasts the outcome. Input
+// problems are reported back to c; the returned error is non-nil only when
+// that reply could not be written and the connection should be dropped.
+func (h *Hub) handleMessage(c *client, msg Message) error {
+	if h.strict {
+		if err := checkShape(msg); err != nil {
+			return c.replyError(err)
+		}
+	}
+	if h.config.ReadOnly && mutates(msg.Type) {
+		return c.replyError(invalid(errReadOnly, "room %s is read-only", h.room))
+	}
+	if !c.permits(msg.Type) {
+		return c.replyError(invalid(errUnauthorized, "%s role required for %s", requiredRole(msg.Type), msg.Type))
+	}
+	if msg.IdempotencyKey != "" && (msg.Type == "add" || msg.Type == "addIfAbsent" || msg.Type == "addBatch") {
+		if _, seen := h.idem.begin(msg.IdempotencyKey); seen {
+			return nil
+		}
+		// Messages rejected before anything was applied may be retried.
+		defer func() {
+			if !h.idem.isDone(msg.IdempotencyKey) {
+				h.idem.forget(msg.IdempotencyKey)
+			}
+		}()
+	}
+	switch msg.Type {
+	case "add":
+		if msg.Point == nil {
+			return c.replyError(invalid(errInvalidMessage, "%s requires point", msg.Type))
+		}
+		if err := validatePoint(*msg.Point, h.meta); err != nil {
+			return c.replyError(err)
+		}
+		p, err := h.addPoint(*msg.Point, c.principal())
+		if err != nil {
+			switch err.Code {
+			case errDuplicate:
+				// Adding a point that exists is a no-op.
+				return nil
+			case errTooClose:
+				reply := errorMessage(err.Code, err.Reason)
+				reply.Point = &p
+				return c.fail(reply)
+			}
+			return c.replyError(err)
+		}
+		h.broadcast(Message{Type: "add", Point: &p})
+		h.idem.finish(msg.IdempotencyKey, 0, nil)
+		c.undo.record(h, change{added: []Point{p}})
+		if h.idMode {
+			// Tell the adder which id the point was stored under so it can
+			// refer to it without repeating coordinates.
+			return c.reply(Message{Type: "added", ID: p.ID, Point: &p})
+		}
+	case "addIfAbsent":
+		if msg.Point == nil {
+			return c.replyError(invalid(errInvalidMessage, "%s requires point", msg.Type))
+		}
+		if err := validatePoint(*msg.Point, h.meta); err != nil {
+			return c.replyError(err)
+		}
+		p, err := h.addPoint(*msg.Point, c.principal())
+		created := err == nil
+		if created {
+			h.broadcast(Message{Type: "add", Point: &p})
+			h.idem.finish(msg.IdempotencyKey, 0, nil)
+			c.undo.record(h, change{added: []Point{p}})
+		}
+		if err := c.reply(Message{Type: "addResult", Created: &created, Point: &p}); err != nil {
+			return err
+		}
+	case "addBatch":
+		if err := h.checkBatch(len(msg.Points)); err != nil {
+			return c.replyError(err)
+		}
+		if msg.Atomic {
+			// All or nothing: report every offending entry and change nothing
+			// unless the whole batch can be applied.
+			if rejected := rejectInvalid(msg.Points, h.meta); len(rejected) > 0 {
+				return c.reply(Message{Type: "addBatchResult", Results: rejected})
+			}
+			added, rejected := h.addPointsAtomic(msg.Points, c.principal())
+			if len(rejected) > 0 {
+				return c.reply(Message{Type: "addBatchResult", Results: rejected})
+			}
+			if len(added) > 0 {
+				h.broadcast(Message{Type: "addBatch", Points: added})
+				h.idem.finish(msg.IdempotencyKey, 0, nil)
+			}
+			c.undo.record(h, change{added: added})
+			return nil
+		}
+		if i, err := validatePoints(msg.Points, h.meta); err != nil {
+			return c.replyError(invalid(err.Code, "point %d: %s", i, err.Reason))
+		}
+		added := h.addPoints(msg.Points, c.principal())
+		if len(added) > 0 {
+			h.broadcast(Message{Type: "addBatch", Points: added})
+			h.idem.finish(msg.IdempotencyKey, 0, nil)
+		}
+		c.undo.record(h, change{added: added})
+	case "remove":
+		if msg.Point == nil {
+			return c.replyError(invalid(errInvalidMessage, "%s requires point", msg.Type))
+		}
+		if !h.removesAny(role(c.role.Load())) {
+			if _, foreign := h.ownedOnly([]Point{*msg.Point}, c.principal()); foreign > 0 {
+				return c.replyError(invalid(errUnauthorized, "point is owned by another client"))
+			}
+		}
+		p, err := h.removePoint(*msg.Point, c.id)
+		if err != nil {
+			if err.Code == errNotFound {
+				return nil
+			}
+			return c.replyError(err)
+		}
+		h.afterRemove([]Point{p})
+		h.broadcast(Message{Type: "remove", Point: &p})
+		c.undo.record(h, change{removed: []Point{p}})
+	case "removeBatch":
+		if err := h.checkBatch(len(msg.Points)); err != nil {
+			return c.replyError(err)
+		}
+		ps, foreign := msg.Points, 0
+		if !h.removesAny(role(c.role.Load())) {
+			ps, foreign = h.ownedOnly(ps, c.principal())
+		}
+		removed, locked := h.removePoints(ps, c.id)
+		h.afterRemove(removed)
+		if len(removed) > 0 {
+			h.broadcast(Message{Type: "removeBatch", Points: removed})
+		}
+		c.undo.record(h, change{removed: removed})
+		if locked > 0 {
+			return c.replyError(invalid(errLocked, "%d points are locked by other connections", locked))
+		}
+		if foreign > 0 {
+			return c.replyError(invalid(errUnauthorized, "%d points are owned by other clients", foreign))
+		}
+	case "removePrefix":
+		if err := validatePath(msg.Path); err != nil {
+			return c.replyError(err)
+		}
+		if !h.removesAny(role(c.role.Load())) {
+			return c.replyError(invalid(errUnauthorized, "only admins may remove points owned by others"))
+		}
+		removed := h.removePrefix(msg.Path, c.id)
+		h.afterRemove(removed)
+		if len(removed) > 0 {
+			h.broadcast(Message{Type: "removeBatch", Points: removed})
+		}
+		c.undo.record(h, change{removed: removed})
+	case "clear":
+		if !h.removesAny(role(c.role.Load())) {
+			return c.replyError(invalid(errUnauthorized, "only admins may remove points owned by others"))
+		}
+		removed := h.clearPoints(c.id)
+		h.afterRemove(removed)
+		if len(removed) > 0 {
+			h.broadcast(Message{Type: "clear"})
+		}
+		c.undo.record(h, change{removed: removed})
+	case "select", "deselect":
+		ps := msg.Points
+		if msg.Point != nil {
+			ps = append(ps, *msg.Point)
+		}
+		if err := h.checkBatch(len(ps)); err != nil {
+			return c.replyError(err)
+		}
+		var changed []Point
+		if msg.Type == "select" {
+			changed = h.selectPoints(ps)
+		} else {
+			changed = h.deselectPoints(ps)
+		}
+		if len(changed) > 0 {
+			h.broadcast(Message{Type: msg.Type, Points: changed})
+		}
+	case "clearSelection":
+		if h.clearSelection() {
+			h.broadcast(Message{Type: "clearSelection"})
+		}
+	case "signal":
+		if msg.Point == nil {
+			return c.replyError(invalid(errInvalidMessage, "%s requires point", msg.Type))
+		}
+		if err := validatePoint(*msg.Point, h.meta); err != nil {
+			return c.replyError(err)
+		}
+		h.signal(*msg.Point)
+	case "chat":
+		return h.chat(c, msg)
+	case "presence":
+		if err := h.setPresence(c, msg); err != nil {
+			return c.replyError(err)
+		}
+	case "sync":
+		if msg.Since == nil {
+			return c.replyError(invalid(errInvalidMessage, "%s requires since", msg.Type))
+		}
+		var err error
+		h.sequenced(func() {
+			var resumed bool
+			if resumed, err = h.resume(c, *msg.Since); err == nil && !resumed {
+				err = h.sendInit(c)
+			}
+		})
+		return err
+	case "subscribe":
+		// Types and region are changed independently, so a client can follow
+		// its camera without repeating its types. A bare subscribe, or an
+		// empty types list, goes back to everything.
+		r, err := parseRegion(msg.Min, msg.Max)
+		if err != nil {
+			return c.replyError(err)
+		}
+		reset := msg.Types == nil && r == nil
+		if msg.Types != nil || reset {
+			h.subscribe(c, msg.Types)
+		}
+		if r != nil || reset {
+			h.setRegion(c, r)
+		}
+	case "update":
+		if msg.Point == nil {
+			return c.replyError(invalid(errInvalidMessage, "%s requires point", msg.Type))
+		}
+		u := pointUpdate{Point: *msg.Point, Weight: msg.Weight, Color: msg.Color, Label: msg.Label, Pinned: msg.Pinned, Velocity: msg.Velocity, Meta: msg.Meta, MetaMode: msg.MetaMode}
+		if err := u.validate(h.meta); err != nil {
+			return c.replyError(err)
+		}
+		p, err := h.updatePoint(u.Point, c.id, func(p *Point) *validationError { return u.apply(p, h.meta) })
+		if err != nil {
+			return c.replyError(err)
+		}
+		h.moves.flush(h.key(p))
+		h.broadcast(Message{Type: "update", Point: &p})
+	case "updateBatch":
+		if err := h.checkBatch(len(msg.Updates)); err != nil {
+			return c.replyError(err)
+		}
+		updated, rejected := h.updatePoints(msg.Updates, c.id)
+		for _, p := range updated {
+			h.moves.flush(h.key(p))
+		}
+		if len(updated) > 0 {
+			h.broadcast(Message{Type: "updateBatch", Points: updated})
+		}
+		if len(rejected) > 0 {
+			return c.reply(Message{Type: "updateBatchResult", Results: rejected})
+		}
+	case "move":
+		if msg.From == nil || msg.To == nil {
+			return nil
+		}
+		if err := validatePoint(*msg.To, h.meta); err != nil {
+			return c.replyError(err)
+		}
+		from, to, err := h.movePoint(*msg.From, *msg.To, c.id)
+		if err != nil {
+			return c.replyError(err)
+		}
+		h.moves.add(h.key(from), h.key(to), from, to)
+		c.undo.record(h, change{removed: []Point{from}, added: []Point{to}, move: true})
+	case "undo", "redo":
+		apply := h.undo
+		if msg.Type == "redo" {
+			apply = h.redo
+		}
+		if _, ok := apply(c); !ok {
+			return c.replyError(invalid(errNotFound, "nothing to %s", msg.Type))
+		}
+	case "lock":
+		if msg.Point == nil {
+			return c.replyError(invalid(errInvalidMessage, "%s requires point", msg.Type))
+		}
+		s, err := h.lockPoint(*msg.Point, c.id)
+		if err != nil {
+			return c.replyError(err)
+		}
+		h.broadcast(Message{Type: "lock", Point: &s.Point, Owner: s.Owner, ExpiresAt: s.ExpiresAt})
+	case "generate":
+		if msg.Generate == nil {
+			return c.replyError(invalid(errInvalidMessage, "generate requires generate"))
+		}
+		added, res, err := h.generate(msg.Generate, c.principal())
+		if err != nil {
+			return c.replyError(err)
+		}
+		c.undo.record(h, change{added: added})
+		return c.reply(Message{Type: "generated", Generated: &res})
+	case "pauseSimulation", "resumeSimulation":
+		state, err := h.pauseSimulation(msg.Type == "pauseSimulation")
+		if err != nil {
+			return c.replyError(err)
+		}
+		h.broadcast(Message{Type: "simulation", Simulation: state})
+	case "unlock":
+		if msg.Point == nil {
+			for _, p := range h.releaseLocks(c.id) {
+				p := p
+				h.broadcast(Message{Type: "unlock", Point: &p})
+			}
+			return nil
+		}
+		p, err := h.unlockPoint(*msg.Point, c.id)
+		if err != nil {
+			return c.replyError(err)
+		}
+		h.broadcast(Message{Type: "unlock", Point: &p})
+	default:
+		return c.replyError(invalid(errInvalidMessage, "unknown message type %q", msg.Type))
+	}
+	return nil
+}
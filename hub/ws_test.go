@@ -0,0 +1,61 @@
+package hub
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLargeInitIsSentInChunks(t *testing.T) {
+	h := newHub("test")
+	h.initChunkSize = 2
+	c, fc := testClient(h)
+	for i := 0; i < 5; i++ {
+		h.addPoint(Point{X: float64(i)}, "test")
+	}
+
+	if err := h.sendInit(c); err != nil {
+		t.Fatal(err)
+	}
+	msgs := fc.messages(t)
+	if len(msgs) != 4 || msgs[0].Type != "init" || len(msgs[0].Points) != 0 || msgs[0].Seq != 5 {
+		t.Fatalf("got %+v, want an empty init at seq 5 and three chunks", msgs)
+	}
+	total := 0
+	for i, m := range msgs[1:] {
+		if m.Type != "initChunk" || m.Done != (i == 2) {
+			t.Errorf("frame %d = %+v", i+1, m)
+		}
+		total += len(m.Points)
+	}
+	if total != 5 {
+		t.Errorf("chunks carried %d points, want 5", total)
+	}
+}
+
+func TestOversizedInitRefersToTheSnapshot(t *testing.T) {
+	s := newTestServer(t, WithMaxInitBytes(64))
+	var fc *fakeConn
+	s.Room("r", func(h *Hub) {
+		for i := 0; i < 10; i++ {
+			h.Add(Point{X: float64(i)}, "test")
+		}
+		var c *client
+		c, fc = testClient(h)
+		if err := h.sendInit(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+	msgs := fc.messages(t)
+	if len(msgs) != 1 || msgs[0].Type != "initRef" || len(msgs[0].Points) != 0 || msgs[0].Seq != 10 {
+		t.Fatalf("got %+v, want a lone initRef at seq 10", msgs)
+	}
+
+	rec := get(s, msgs[0].URL)
+	var snap RoomSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("%s: %d %s", msgs[0].URL, rec.Code, rec.Body)
+	}
+	if len(snap.Points) != 10 || snap.Seq != 10 {
+		t.Errorf("snapshot has %d points at seq %d", len(snap.Points), snap.Seq)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix namespaces the environment variables that stand in for flags:
+// -max-batch is read from UNIVERSE_MAX_BATCH.
+const envPrefix = "UNIVERSE_"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfig fills in every flag not given on the command line, first from
+// its environment variable and otherwise from the JSON config file at path,
+// so the precedence is command line, environment, file, then the built-in
+// default. The file maps flag names to strings, numbers or booleans, or to
+// arrays of them for repeatable flags.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	file := map[string]json.RawMessage{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for name := range file {
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+		}
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%s: %w", envName(f.Name), serr)
+			}
+			return
+		}
+		raw, ok := file[f.Name]
+		if !ok {
+			return
+		}
+		values, verr := configValues(raw)
+		if verr != nil {
+			err = fmt.Errorf("%s: %s: %w", path, f.Name, verr)
+			return
+		}
+		for _, v := range values {
+			if serr := fs.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%s: %s: %w", path, f.Name, serr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// configValues turns a config file value into the strings a flag is set
+// from, one per element when it is an array.
+func configValues(raw json.RawMessage) ([]string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			v, err := configValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	v, err := configValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	return []string{v}, nil
+}
+
+func configValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("want a string, number or boolean, got %s", raw)
+}
+
+// roomValues collects repeated room:value flags.
+type roomValues map[string]string
+
+func (rv roomValues) String() string {
+	parts := make([]string, 0, len(rv))
+	for name, v := range rv {
+		parts = append(parts, name+":"+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (rv roomValues) Set(value string) error {
+	room, v, ok := strings.Cut(value, ":")
+	if !ok || room == "" {
+		return fmt.Errorf("want room:value, got %q", value)
+	}
+	rv[room] = v
+	return nil
+}
@@ -1,164 +1,286 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/kfrico/universe/hub"
 )
 
-type point struct {
-	X float64 `json:"x"`
-	Y float64 `json:"y"`
-	Z float64 `json:"z"`
-}
-
-type message struct {
-	Type      string  `json:"type"`
-	Point     *point  `json:"point,omitempty"`
-	Points    []point `json:"points,omitempty"`
-	StartTime int64   `json:"startTime,omitempty"`
-}
-
-type hub struct {
-	mu        sync.Mutex
-	points    map[string]point
-	conns     map[*websocket.Conn]struct{}
-	startTime int64
-}
-
-func newHub() *hub {
-	return &hub{
-		points:    make(map[string]point),
-		conns:     make(map[*websocket.Conn]struct{}),
-		startTime: time.Now().UnixMilli(),
-	}
-}
-
-func (h *hub) key(p point) string {
-	return fmt.Sprintf("%.6f,%.6f,%.6f", p.X, p.Y, p.Z)
-}
-
-func (h *hub) addPoint(p point) bool {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	key := h.key(p)
-	if _, exists := h.points[key]; exists {
-		return false
-	}
-	h.points[key] = p
-	return true
-}
-
-func (h *hub) removePoint(p point) bool {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	key := h.key(p)
-	if _, exists := h.points[key]; !exists {
-		return false
+func main() {
+	configPath := flag.String("config", "", "JSON file of flag-name: value settings; command-line flags, then "+envPrefix+"* environment variables, take precedence over it")
+	addr := flag.String("addr", ":8080", "listen address")
+	tlsCert := flag.String("tls-cert", "", "serve HTTPS and wss:// with this PEM certificate file, reloaded when it changes; requires -tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	autocertHosts := flag.String("autocert-hosts", "", "comma-separated host names to obtain Let's Encrypt certificates for and serve HTTPS with; excludes -tls-cert")
+	autocertDir := flag.String("autocert-dir", "autocert", "with -autocert-hosts, directory caching certificates and the ACME account key")
+	autocertEmail := flag.String("autocert-email", "", "with -autocert-hosts, contact address given to Let's Encrypt")
+	autocertHTTP := flag.String("autocert-http", ":80", "with -autocert-hosts, address answering HTTP-01 challenges and redirecting other requests to HTTPS (empty to rely on TLS-ALPN-01 alone)")
+	staticDir := flag.String("static-dir", ".", "directory served at /")
+	allowedOrigins := flag.String("allowed-origins", "", "comma-separated origins allowed to open WebSocket connections and call the REST endpoints, e.g. https://example.com,https://*.example.org,http://localhost:*, host names such as example.net for any scheme and port, or * for any (pages from the server's own host name when empty)")
+	auditPath := flag.String("audit-log", "", "append a JSON line per mutation to this file (disabled when empty)")
+	auditBuffer := flag.Int("audit-buffer", 1024, "number of audit entries buffered before new ones are dropped")
+	webhookURLs := flag.String("webhook-urls", "", "comma-separated URLs to POST batches of mutations to as JSON (disabled when empty)")
+	webhookBatch := flag.Int("webhook-batch", 100, "maximum number of mutations in one webhook delivery")
+	webhookInterval := flag.Duration("webhook-interval", time.Second, "longest wait before a webhook delivery that is not full is sent")
+	webhookSecret := flag.String("webhook-secret", "", "sign webhook bodies with this HMAC-SHA256 key in the X-Universe-Signature header")
+	maxBatch := flag.Int("max-batch", 10000, "maximum number of points in one addBatch or removeBatch message (0 for no limit)")
+	maxGenerate := flag.Int("max-generate", 100000, "maximum number of points one generate command may create (0 for no limit)")
+	maxMessageBytes := flag.Int64("max-message-bytes", 4<<20, "maximum size of an incoming WebSocket message in bytes (0 for no limit)")
+	idMode := flag.Bool("id-mode", false, "key points by id instead of coordinates, allowing coincident points")
+	keyDecimals := flag.Int("key-decimals", 6, "decimal places of the coordinates identifying a point outside -id-mode; points that round alike are duplicates (see -min-distance for a distance tolerance)")
+	readStdin := flag.Bool("stdin", false, "add newline-delimited JSON points read from standard input")
+	checkInterval := flag.Duration("check-interval", 30*time.Second, "interval between connection probes and consistency checks (0 to disable)")
+	compactInterval := flag.Duration("compact-interval", time.Minute, "interval between compaction passes (0 to disable)")
+	maxLifetime := flag.Duration("max-conn-lifetime", 0, "close connections with a going-away frame after this long (0 to disable)")
+	lifetimeJitter := flag.Duration("conn-lifetime-jitter", time.Minute, "random extra lifetime added per connection to stagger reconnects")
+	initChunkSize := flag.Int("init-chunk-size", 5000, "split init snapshots larger than this many points into initChunk frames (0 to disable)")
+	maxInitBytes := flag.Int64("max-init-bytes", 0, "send an initRef pointing at /snapshot.json instead of the init points when they encode to more than this many bytes (0 for no limit)")
+	acceptDepth := flag.Int("accept-queue", 1024, "maximum number of connections waiting to be registered before new ones get 503 (0 to register directly)")
+	compress := flag.Bool("compress", true, "negotiate permessage-deflate with clients that offer it")
+	compressMin := flag.Int("compress-threshold", 512, "with -compress, send frames smaller than this many bytes uncompressed (0 to compress every frame)")
+	slowWrite := flag.Duration("slow-write", 0, "treat broadcast writes taking longer than this as slow: withhold low-priority messages from the client (0 to disable)")
+	slowWriteStrikes := flag.Int("slow-write-strikes", 5, "drop a client after this many slow writes without recovering (0 to never drop)")
+	slowWriteRecover := flag.Int("slow-write-recover", 20, "consecutive timely writes after which a slow client gets low-priority messages again")
+	clientRate := flag.Float64("client-rate", 0, "messages per second each connection may send on average; faster ones are answered with rate_limited errors (0 for no limit)")
+	clientBurst := flag.Int("client-burst", 50, "with -client-rate, messages a connection may send at once before the rate applies")
+	clientRateStrikes := flag.Int("client-rate-disconnect", 100, "with -client-rate, drop a connection after this many consecutive rate-limited messages (0 to never drop)")
+	sendQueue := flag.Int("send-queue", 256, "frames buffered per client; when full, low-priority messages are dropped and a client with only high-priority ones is disconnected (0 to write synchronously)")
+	reconnectMin := flag.Duration("shutdown-reconnect-min", time.Second, "shortest reconnect delay suggested to clients in the shutdown message")
+	reconnectMax := flag.Duration("shutdown-reconnect-max", 10*time.Second, "longest reconnect delay suggested to clients in the shutdown message; each client gets a random delay in between")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "deadline for writing each frame to a client")
+	readTimeout := flag.Duration("read-timeout", 75*time.Second, "drop connections that send nothing, not even a pong to the -check-interval pings, for this long; must exceed -check-interval (0 to disable)")
+	idemTTL := flag.Duration("idempotency-ttl", 10*time.Minute, "how long idempotency keys on adds are remembered")
+	idemSize := flag.Int("idempotency-size", 10000, "maximum number of idempotency keys remembered per room")
+	maxBroadcastRate := flag.Float64("max-broadcast-rate", 0, "maximum broadcast frames per second per room; excess is merged into delta frames (0 for no cap)")
+	broadcastTick := flag.Duration("broadcast-tick", 0, "hold broadcasts and send them as one delta frame per room this often, e.g. 50ms; excludes -max-broadcast-rate (0 to send them as they happen)")
+	moveQuantum := flag.Float64("move-quantum", 0.001, "coordinate unit of the int16 deltas in binary move frames")
+	moveWindow := flag.Duration("move-coalesce", 0, "broadcast only the latest position of a point moved repeatedly within this window (0 to disable)")
+	boundsInterval := flag.Duration("bounds-interval", 0, "broadcast the room's bounding box at most this often when it changes (0 to disable)")
+	boundsThreshold := flag.Float64("bounds-threshold", 0.01, "minimum change of a bounding box corner coordinate that triggers a bounds broadcast")
+	minDistance := flag.Float64("min-distance", 0, "reject adds and moves that would put a point closer than this to another (0 to disable)")
+	perRoomMinDistance := roomValues{}
+	flag.Var(perRoomMinDistance, "room-min-distance", "per-room minimum distance as room:value, overriding -min-distance (repeatable)")
+	staleAfter := flag.Duration("peer-stale-after", 45*time.Second, "announce a peer as stale when its last pong is older than this; peers are reaped after twice -check-interval (0 to disable)")
+	lockTimeout := flag.Duration("lock-timeout", 30*time.Second, "release point locks not renewed within this long (0 for no expiry)")
+	maxTotalPoints := flag.Int64("max-total-points", 0, "maximum number of points across all rooms; further adds get limit_exceeded errors (0 for no limit)")
+	maxOwnerPoints := flag.Int("max-points-per-owner", 0, "maximum number of points one owner, a connection or authenticated identity, may have in a room (0 for no limit)")
+	pointTTL := flag.Duration("point-ttl", 0, "remove points added without an expiresAt or ttlMs this long after they were added (0 to keep them)")
+	expiryInterval := flag.Duration("expiry-interval", time.Second, "how often expired points are removed and the removals broadcast (0 to never expire points)")
+	simStep := flag.Duration("sim-step", 0, "advance points that have a velocity this often, e.g. 50ms; requires -id-mode (0 to never move them)")
+	simPublish := flag.Duration("sim-publish", 100*time.Millisecond, "broadcast the positions of simulated points at most this often")
+	recordDir := flag.String("record-dir", "", "write timestamped recordings of every loaded room to this directory, on shutdown and every -record-interval; listed and restored under /admin/recordings (disabled when empty)")
+	recordInterval := flag.Duration("record-interval", 10*time.Minute, "interval between recordings (0 for only on shutdown and on request)")
+	recordKeep := flag.Int("record-keep", 48, "number of recordings kept (0 for all)")
+	recordMaxAge := flag.Duration("record-max-age", 0, "delete recordings older than this (0 for no limit)")
+	tombstoneRetention := flag.Duration("tombstone-retention", 10*time.Minute, "how long removals are remembered for /points/changed; older polls get a full reset")
+	tombstoneLimit := flag.Int("tombstone-limit", 10000, "maximum number of removals remembered per room for /points/changed")
+	roomConfigPath := flag.String("room-config", "", "JSON file with default and per-room rules (gridStep, twoD, maxPoints, readOnly, codecs)")
+	dataDir := flag.String("data-dir", "", "directory each room's points are saved to and loaded from at startup, surviving restarts (disabled when empty)")
+	persistInterval := flag.Duration("persist-interval", time.Second, "delay between a room changing and it being saved to -data-dir")
+	roomTTL := flag.Duration("room-ttl", 10*time.Minute, "how long a room must be empty of connections and points before it is reclaimed")
+	roomDir := flag.String("room-dir", "", "directory idle rooms are unloaded to (disabled when empty)")
+	preloadRooms := flag.Bool("preload-rooms", false, "load every room saved in -room-dir at startup; /healthz reports not ready and /ws is gated until done")
+	startupDelay := flag.Duration("startup-delay", 0, "minimum time after startup before the server reports ready and accepts WebSocket connections")
+	readyMode := flag.String("ready-mode", "reject", `what /ws does with connections before the server is ready: "reject" with 503 or "queue" until ready`)
+	unloadAfter := flag.Duration("room-unload-after", 30*time.Minute, "how long a populated room must have no connections before it is unloaded to -room-dir")
+	transformSpec := flag.String("transform", "", `coordinate transform applied to added points, e.g. "axes=x,-z,y;scale=0.01;offset=0,0,5"`)
+	perRoomTransforms := roomValues{}
+	flag.Var(perRoomTransforms, "room-transform", "per-room coordinate transform as room:spec, overriding -transform (repeatable)")
+	logLevel := flag.String("log-level", "info", "lowest level logged: debug, info, warn or error; debug logs every mutation")
+	logFormat := flag.String("log-format", "text", "log output: text or json (one object per line, for log shippers)")
+	logSample := flag.Duration("log-sample-window", 10*time.Second, "collapse identical client-triggered log lines within this window (0 to log every line)")
+	debug := flag.Bool("debug", false, "serve GET /debug/state with goroutine, connection and config details (requires -admin-token)")
+	adminToken := flag.String("admin-token", "", "bearer token required by administrative endpoints, which are disabled without one")
+	coordDecimals := flag.Int("coord-decimals", -1, "round point coordinates in WebSocket messages to this many decimals; storage keeps full precision; requires -id-mode (-1 to disable)")
+	metaKeys := flag.Int("max-meta-keys", 32, "maximum number of meta keys on a point")
+	metaBytes := flag.Int("max-meta-bytes", 4096, "maximum total bytes of a point's meta keys and values")
+	strict := flag.Bool("strict-messages", true, "reject WebSocket messages of unknown types or with missing or unexpected fields")
+	signalRetention := flag.Duration("signal-retention", 0, "replay signals younger than this to clients in their init (0 to disable)")
+	signalLimit := flag.Int("signal-retain-max", 100, "maximum number of signals retained per room for -signal-retention")
+	chatLength := flag.Int("chat-max-length", 500, "longest chat message in bytes (0 to disable chat)")
+	chatHistory := flag.Int("chat-history", 50, "chat messages per room replayed to joining clients")
+	chatRate := flag.Float64("chat-rate", 1, "chat messages per second each connection may send on average (0 for no limit)")
+	chatBurst := flag.Int("chat-burst", 5, "with -chat-rate, chat messages a connection may send at once")
+	aclPath := flag.String("acl", "", "JSON file mapping identities to viewer, editor or admin roles per room; enables access control (requires -jwt-secret or -jwt-keys)")
+	jwtSecret := flag.String("jwt-secret", "", "HS256 secret verifying bearer tokens without a kid; their subject identifies callers; enables authentication")
+	jwtKeys := flag.String("jwt-keys", "", "JSON file mapping kid to HS256 secret for verifying bearer tokens by their kid header; enables authentication")
+	anonymous := flag.String("anonymous", "full", `with authentication and no -acl, what callers without a token may do: "full", "read-only" or "reject"`)
+	syncHistory := flag.Int("sync-history", 10000, "mutations remembered per room so clients reconnecting with ?since= or a sync message get only what they missed (0 to always send init)")
+	ownerRemoves := flag.Bool("owner-removes", false, "let only a point's owner, or an -acl admin, remove it; clear and removePrefix become admin-only")
+	presenceInterval := flag.Duration("presence-interval", 100*time.Millisecond, "broadcast a client's presence updates at most this often; updates in between are sent as one (0 for no limit)")
+	undoDepth := flag.Int("undo-depth", 50, "changes each connection can undo and redo with undo and redo messages (0 to disable)")
+	backplaneURL := flag.String("backplane", "", "redis://[:password@]host[:port] whose pub/sub relays point changes between instances serving the same rooms (disabled when empty)")
+	backplaneChannel := flag.String("backplane-channel", "universe", "pub/sub channel used by -backplane")
+	jsonCase := flag.String("json-case", "camel", `key casing of JSON exchanged with clients: "camel" or "snake"`)
+	importTimeout := flag.Duration("import-timeout", 30*time.Second, "timeout for fetching a remote snapshot in /admin/import")
+	maxImportBytes := flag.Int64("max-import-bytes", 64<<20, "largest snapshot /admin/import fetches or file /import accepts")
+	flag.Parse()
+	if *configPath == "" {
+		*configPath = os.Getenv(envName("config"))
 	}
-	delete(h.points, key)
-	return true
-}
-
-func (h *hub) snapshotPoints() []point {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	out := make([]point, 0, len(h.points))
-	for _, p := range h.points {
-		out = append(out, p)
+	if err := loadConfig(flag.CommandLine, *configPath); err != nil {
+		fatal("invalid config", "err", err)
 	}
-	return out
-}
-
-func (h *hub) addConn(conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	h.conns[conn] = struct{}{}
-}
-
-func (h *hub) removeConn(conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	delete(h.conns, conn)
-	conn.Close()
-}
-
-func (h *hub) broadcast(msg message) {
-	payload, err := json.Marshal(msg)
+	handler, err := newLogHandler(*logFormat, *logLevel)
 	if err != nil {
-		log.Println("broadcast marshal error:", err)
-		return
+		fatal(err.Error())
 	}
+	slog.SetDefault(slog.New(handler))
 
-	h.mu.Lock()
-	conns := make([]*websocket.Conn, 0, len(h.conns))
-	for c := range h.conns {
-		conns = append(conns, c)
+	if *readyMode != "reject" && *readyMode != "queue" {
+		fatal(fmt.Sprintf("invalid -ready-mode %q", *readyMode))
 	}
-	h.mu.Unlock()
 
-	for _, c := range conns {
-		if err := c.WriteMessage(websocket.TextMessage, payload); err != nil {
-			log.Println("write ws error:", err)
-			h.removeConn(c)
+	opts := []hub.Option{
+		hub.WithStaticDir(*staticDir),
+		hub.WithAllowedOrigins(*allowedOrigins),
+		hub.WithAuditLog(*auditPath, *auditBuffer),
+		hub.WithWebhooks(*webhookURLs, *webhookBatch, *webhookInterval),
+		hub.WithWebhookSecret(*webhookSecret),
+		hub.WithMaxBatch(*maxBatch),
+		hub.WithMaxGenerate(*maxGenerate),
+		hub.WithMaxMessageBytes(*maxMessageBytes),
+		hub.WithIDMode(*idMode),
+		hub.WithKeyDecimals(*keyDecimals),
+		hub.WithCheckInterval(*checkInterval),
+		hub.WithCompactInterval(*compactInterval),
+		hub.WithConnLifetime(*maxLifetime, *lifetimeJitter),
+		hub.WithInitChunkSize(*initChunkSize),
+		hub.WithMaxInitBytes(*maxInitBytes),
+		hub.WithAcceptQueue(*acceptDepth),
+		hub.WithCompression(*compress, *compressMin),
+		hub.WithSlowWrites(*slowWrite, *slowWriteStrikes, *slowWriteRecover),
+		hub.WithClientRate(*clientRate, *clientBurst, *clientRateStrikes),
+		hub.WithSendQueue(*sendQueue),
+		hub.WithShutdownReconnect(*reconnectMin, *reconnectMax),
+		hub.WithWriteTimeout(*writeTimeout),
+		hub.WithReadTimeout(*readTimeout),
+		hub.WithIdempotency(*idemTTL, *idemSize),
+		hub.WithMaxBroadcastRate(*maxBroadcastRate),
+		hub.WithBroadcastTick(*broadcastTick),
+		hub.WithMoveQuantum(*moveQuantum),
+		hub.WithMoveCoalesce(*moveWindow),
+		hub.WithBounds(*boundsInterval, *boundsThreshold),
+		hub.WithMinDistance(*minDistance),
+		hub.WithPeerStaleAfter(*staleAfter),
+		hub.WithLockTimeout(*lockTimeout),
+		hub.WithPointLimits(*maxTotalPoints, *maxOwnerPoints),
+		hub.WithPointExpiry(*pointTTL, *expiryInterval),
+		hub.WithSimulation(*simStep, *simPublish),
+		hub.WithRecorder(*recordDir, *recordInterval, *recordKeep, *recordMaxAge),
+		hub.WithTombstones(*tombstoneRetention, *tombstoneLimit),
+		hub.WithRoomConfig(*roomConfigPath),
+		hub.WithDataDir(*dataDir, *persistInterval),
+		hub.WithRoomTTL(*roomTTL),
+		hub.WithRoomDir(*roomDir, *unloadAfter, *preloadRooms),
+		hub.WithStartupDelay(*startupDelay, *readyMode == "queue"),
+		hub.WithTransform(*transformSpec),
+		hub.WithLogSampleWindow(*logSample),
+		hub.WithAdminToken(*adminToken, *debug),
+		hub.WithCoordDecimals(*coordDecimals),
+		hub.WithMetaLimits(*metaKeys, *metaBytes),
+		hub.WithStrictMessages(*strict),
+		hub.WithSignalRetention(*signalRetention, *signalLimit),
+		hub.WithChat(*chatLength, *chatHistory),
+		hub.WithChatRate(*chatRate, *chatBurst),
+		hub.WithACL(*aclPath),
+		hub.WithJWT(*jwtSecret, *jwtKeys),
+		hub.WithAnonymous(*anonymous),
+		hub.WithSyncHistory(*syncHistory),
+		hub.WithOwnerRemoves(*ownerRemoves),
+		hub.WithPresenceInterval(*presenceInterval),
+		hub.WithUndoDepth(*undoDepth),
+		hub.WithBackplane(*backplaneURL, *backplaneChannel),
+		hub.WithJSONCase(*jsonCase),
+		hub.WithImport(*importTimeout, *maxImportBytes),
+	}
+	for room, v := range perRoomMinDistance {
+		d, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			fatal(fmt.Sprintf("invalid -room-min-distance %s:%s", room, v))
 		}
+		opts = append(opts, hub.WithRoomMinDistance(room, d))
 	}
-}
-
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
-
-func (h *hub) wsHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	for room, spec := range perRoomTransforms {
+		opts = append(opts, hub.WithRoomTransform(room, spec))
+	}
+	universe, err := hub.NewServer(opts...)
 	if err != nil {
-		log.Println("upgrade error:", err)
-		return
+		fatal(err.Error())
 	}
-	h.addConn(conn)
-	defer h.removeConn(conn)
+	defer universe.Close()
 
-	initMsg := message{Type: "init", Points: h.snapshotPoints(), StartTime: h.startTime}
-	if err := conn.WriteJSON(initMsg); err != nil {
-		log.Println("init write error:", err)
-		return
+	if *readStdin {
+		go universe.Ingest(os.Stdin, "stdin")
 	}
 
-	for {
-		var msg message
-		if err := conn.ReadJSON(&msg); err != nil {
-			log.Println("read error:", err)
-			return
+	srv := &http.Server{Addr: *addr, Handler: universe}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatal("-tls-cert and -tls-key must be set together")
+	}
+	if *tlsCert != "" {
+		certs, err := loadCertFiles(*tlsCert, *tlsKey)
+		if err != nil {
+			fatal("load certificate failed", "err", err)
 		}
-
-		switch msg.Type {
-		case "add":
-			if msg.Point != nil && h.addPoint(*msg.Point) {
-				h.broadcast(message{Type: "add", Point: msg.Point})
-			}
-		case "remove":
-			if msg.Point != nil && h.removePoint(*msg.Point) {
-				h.broadcast(message{Type: "remove", Point: msg.Point})
-			}
-		default:
-			log.Println("unknown message type:", msg.Type)
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	}
+	if *autocertHosts != "" {
+		if *tlsCert != "" {
+			fatal("-autocert-hosts and -tls-cert are exclusive")
+		}
+		m, err := newAutocert(*autocertHosts, *autocertDir, *autocertEmail)
+		if err != nil {
+			fatal(err.Error())
+		}
+		srv.TLSConfig = m.TLSConfig()
+		if *autocertHTTP != "" {
+			go func() {
+				if err := http.ListenAndServe(*autocertHTTP, m.HTTPHandler(nil)); err != nil {
+					slog.Error("acme challenge listener failed", "addr", *autocertHTTP, "err", err)
+				}
+			}()
 		}
 	}
-}
-
-func main() {
-	h := newHub()
-
-	http.HandleFunc("/ws", h.wsHandler)
-	http.Handle("/", http.FileServer(http.Dir(".")))
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		slog.Info("shutting down", "signal", (<-sig).String())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		// Stop accepting connections before telling clients to go away.
+		drained := make(chan struct{})
+		go func() {
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Error("http shutdown failed", "err", err)
+			}
+			close(drained)
+		}()
+		universe.Shutdown()
+		<-drained
+	}()
 
-	addr := ":8080"
-	log.Println("listening on", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	if srv.TLSConfig != nil {
+		slog.Info("listening", "addr", *addr, "tls", true)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		slog.Info("listening", "addr", *addr)
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		fatal("serve failed", "err", err)
+	}
+	<-stopped
 }
-
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newAutocert returns a manager that obtains and renews Let's Encrypt
+// certificates for the comma-separated hosts, and for no others, caching
+// them in dir.
+func newAutocert(hosts, dir, email string) (*autocert.Manager, error) {
+	var names []string
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			names = append(names, h)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("-autocert-hosts names no host")
+	}
+	if dir == "" {
+		return nil, errors.New("-autocert-hosts requires -autocert-dir")
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(names...),
+		Cache:      autocert.DirCache(dir),
+		Email:      email,
+	}, nil
+}
+
+// certFiles serves a certificate and key read from disk, reloading them when
+// either file changes so renewals by an external ACME client such as certbot
+// take effect without a restart.
+type certFiles struct {
+	certPath, keyPath string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func loadCertFiles(certPath, keyPath string) (*certFiles, error) {
+	f := &certFiles{certPath: certPath, keyPath: keyPath}
+	if _, err := f.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// latestModTime returns the later modification time of the two files.
+func (f *certFiles) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{f.certPath, f.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (f *certFiles) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	modTime, err := f.latestModTime()
+	if err != nil {
+		if f.cert != nil {
+			slog.Warn("keeping the loaded certificate", "err", err)
+			return f.cert, nil
+		}
+		return nil, err
+	}
+	if f.cert != nil && modTime.Equal(f.modTime) {
+		return f.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(f.certPath, f.keyPath)
+	if err != nil {
+		if f.cert != nil {
+			// A renewal may have replaced one file but not yet the other.
+			slog.Warn("keeping the loaded certificate", "err", err)
+			return f.cert, nil
+		}
+		return nil, err
+	}
+	if f.cert != nil {
+		slog.Info("reloaded certificate", "cert", f.certPath)
+	}
+	f.cert, f.modTime = &cert, modTime
+	return f.cert, nil
+}